@@ -4,11 +4,13 @@ import (
 	"encoding/json"
 	"net/http"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/etclabscore/core-pool/chain"
 	"github.com/etclabscore/core-pool/common"
 	"github.com/etclabscore/core-pool/library/logger"
 	"github.com/etclabscore/core-pool/storage"
@@ -33,6 +35,7 @@ type ApiConfig struct {
 type ApiServer struct {
 	config              *ApiConfig
 	backend             *storage.RedisClient
+	chain               chain.ChainSpec
 	hashrateWindow      time.Duration
 	hashrateLargeWindow time.Duration
 	stats               atomic.Value
@@ -52,12 +55,13 @@ func setHeader(w http.ResponseWriter) {
 	w.Header().Set("Cache-Control", "no-cache")
 }
 
-func NewApiServer(cfg *ApiConfig, backend *storage.RedisClient) *ApiServer {
+func NewApiServer(cfg *ApiConfig, backend *storage.RedisClient, spec chain.ChainSpec) *ApiServer {
 	hashrateWindow := util.MustParseDuration(cfg.HashrateWindow)
 	hashrateLargeWindow := util.MustParseDuration(cfg.HashrateLargeWindow)
 	return &ApiServer{
 		config:              cfg,
 		backend:             backend,
+		chain:               spec,
 		hashrateWindow:      hashrateWindow,
 		hashrateLargeWindow: hashrateLargeWindow,
 		miners:              make(map[string]*Entry),
@@ -147,17 +151,82 @@ func (s *ApiServer) collectStats() {
 		logger.Error("Failed to fetch stats from backend: %v", err)
 		return
 	}
+	solo := splitSoloStats(stats)
 	if len(s.config.LuckWindow) > 0 {
 		stats["luck"], err = s.backend.CollectLuckStats(s.config.LuckWindow)
 		if err != nil {
 			logger.Error("Failed to fetch luck stats from backend: %v", err)
 			return
 		}
+		solo["luck"], err = s.backend.CollectSoloLuckStats(s.config.LuckWindow)
+		if err != nil {
+			logger.Error("Failed to fetch solo luck stats from backend: %v", err)
+			return
+		}
+	}
+	stats["solo"] = solo
+	pps, err := s.backend.GetPPSStats()
+	if err != nil {
+		logger.Error("Failed to fetch PPS reserve stats from backend: %v", err)
+		return
+	}
+	if pps != nil {
+		stats["pps"] = pps
 	}
 	s.stats.Store(stats)
 	logger.Info("Stats collection finished %s", time.Since(start))
 }
 
+// Solo blocks, miners and hashrate are reported apart from shared rounds,
+// whose totals and hashrate count shared mining only
+func splitSoloStats(stats map[string]interface{}) map[string]interface{} {
+	solo := make(map[string]interface{})
+	for _, key := range []string{"candidates", "immature", "matured"} {
+		blocks, _ := stats[key].([]*storage.BlockData)
+		shared, soloBlocks := splitSoloBlocks(blocks)
+		stats[key], solo[key] = shared, soloBlocks
+		// Candidates and immature blocks are listed in full
+		if key != "matured" {
+			stats[key+"Total"], solo[key+"Total"] = int64(len(shared)), int64(len(soloBlocks))
+		}
+	}
+	maturedTotal, _ := stats["maturedTotal"].(int64)
+	soloMatured, _ := stats["soloMaturedTotal"].(int64)
+	stats["maturedTotal"], solo["maturedTotal"] = maturedTotal-soloMatured, soloMatured
+
+	solo["miners"], solo["hashrate"] = stats["soloMiners"], stats["soloHashrate"]
+	soloMiners, _ := stats["soloMiners"].(map[string]storage.Miner)
+	solo["minersTotal"] = len(soloMiners)
+	delete(stats, "soloMiners")
+	delete(stats, "soloHashrate")
+	delete(stats, "soloMaturedTotal")
+	return solo
+}
+
+// Part of solo stats an endpoint reports
+func soloStats(stats map[string]interface{}, keys ...string) map[string]interface{} {
+	solo, _ := stats["solo"].(map[string]interface{})
+	reply := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		reply[key] = solo[key]
+	}
+	return reply
+}
+
+// Solo blocks are listed apart from blocks of shared rounds
+func splitSoloBlocks(blocks []*storage.BlockData) ([]*storage.BlockData, []*storage.BlockData) {
+	shared := make([]*storage.BlockData, 0, len(blocks))
+	solo := make([]*storage.BlockData, 0)
+	for _, block := range blocks {
+		if block.Solo {
+			solo = append(solo, block)
+		} else {
+			shared = append(shared, block)
+		}
+	}
+	return shared, solo
+}
+
 func (s *ApiServer) StatsIndex(w http.ResponseWriter, r *http.Request) {
 	setHeader(w)
 	w.WriteHeader(http.StatusOK)
@@ -169,6 +238,13 @@ func (s *ApiServer) StatsIndex(w http.ResponseWriter, r *http.Request) {
 	}
 	reply["nodes"] = nodes
 
+	upstreams, err := s.backend.GetUpstreamStates()
+	if err != nil {
+		logger.Error("Failed to get upstreams stats from backend: %v", err)
+	}
+	reply["upstreams"] = upstreams
+	reply["chain"] = s.chainInfo(nodes)
+
 	stats := s.getStats()
 	if stats != nil {
 		reply["now"] = util.MakeTimestamp()
@@ -178,6 +254,10 @@ func (s *ApiServer) StatsIndex(w http.ResponseWriter, r *http.Request) {
 		reply["maturedTotal"] = stats["maturedTotal"]
 		reply["immatureTotal"] = stats["immatureTotal"]
 		reply["candidatesTotal"] = stats["candidatesTotal"]
+		reply["solo"] = soloStats(stats, "hashrate", "minersTotal", "maturedTotal", "immatureTotal", "candidatesTotal")
+		if stats["pps"] != nil {
+			reply["pps"] = stats["pps"]
+		}
 	}
 
 	err = json.NewEncoder(w).Encode(reply)
@@ -186,6 +266,26 @@ func (s *ApiServer) StatsIndex(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Chain parameters at the highest height reported by the nodes
+func (s *ApiServer) chainInfo(nodes []map[string]interface{}) map[string]interface{} {
+	var height int64
+	for _, node := range nodes {
+		value, _ := node["height"].(string)
+		h, _ := strconv.ParseInt(value, 10, 64)
+		if h > height {
+			height = h
+		}
+	}
+	return map[string]interface{}{
+		"name":        s.chain.Name(),
+		"chainId":     s.chain.ChainID(),
+		"forks":       s.chain.ForkHeights(),
+		"height":      height,
+		"blockReward": s.chain.BlockReward(height).String(),
+		"epochLength": s.chain.EpochLength(uint64(height)),
+	}
+}
+
 func (s *ApiServer) MinersIndex(w http.ResponseWriter, r *http.Request) {
 	setHeader(w)
 	w.WriteHeader(http.StatusOK)
@@ -197,6 +297,7 @@ func (s *ApiServer) MinersIndex(w http.ResponseWriter, r *http.Request) {
 		reply["miners"] = stats["miners"]
 		reply["hashrate"] = stats["hashrate"]
 		reply["minersTotal"] = stats["minersTotal"]
+		reply["solo"] = soloStats(stats, "miners", "hashrate", "minersTotal")
 	}
 
 	err := json.NewEncoder(w).Encode(reply)
@@ -219,6 +320,7 @@ func (s *ApiServer) BlocksIndex(w http.ResponseWriter, r *http.Request) {
 		reply["candidates"] = stats["candidates"]
 		reply["candidatesTotal"] = stats["candidatesTotal"]
 		reply["luck"] = stats["luck"]
+		reply["solo"] = soloStats(stats, "matured", "maturedTotal", "immature", "immatureTotal", "candidates", "candidatesTotal", "luck")
 	}
 
 	err := json.NewEncoder(w).Encode(reply)
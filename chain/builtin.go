@@ -0,0 +1,114 @@
+package chain
+
+// Built-in network names
+const (
+	Classic  = "classic"
+	Mordor   = "mordor"
+	Ethereum = "ethereum"
+	Ropsten  = "ropsten"
+	Ubiq     = "ubiq"
+	EthPoW   = "ethw"
+)
+
+func uint64Ptr(v uint64) *uint64 { return &v }
+func int64Ptr(v int64) *int64    { return &v }
+
+// Built-in chain specs, spec with the same name in chainSpecs file overrides them
+var builtinConfigs = []*Config{
+	{
+		Name:    Classic,
+		ChainId: 61,
+		Hasher:  HasherConfig{Algorithm: "etchash", Ecip1099FBlock: uint64Ptr(11700000)},
+		Reward: RewardConfig{
+			Schedule:  []RewardStep{{0, "5000000000000000000"}},
+			EraLength: 5000000,
+			UncleRule: UncleRuleEcip1017,
+		},
+		Forks: map[string]int64{"ecip1017": 5000000},
+	},
+	{
+		Name:    Mordor,
+		ChainId: 63,
+		Hasher:  HasherConfig{Algorithm: "etchash", Ecip1099FBlock: uint64Ptr(2520000)},
+		Reward: RewardConfig{
+			Schedule:  []RewardStep{{0, "5000000000000000000"}},
+			EraLength: 2000000,
+			UncleRule: UncleRuleEcip1017,
+		},
+		Forks: map[string]int64{"ecip1017": 0},
+	},
+	{
+		Name:    Ethereum,
+		ChainId: 1,
+		Hasher:  HasherConfig{Algorithm: "ethash"},
+		Reward: RewardConfig{
+			Schedule: []RewardStep{
+				{0, "5000000000000000000"},
+				{4370000, "3000000000000000000"},
+				{7280000, "2000000000000000000"},
+				// Ethereum moved to PoS at the merge, no block reward after it
+				{15537394, "0"},
+			},
+		},
+		BurnFBlock: int64Ptr(12965000),
+		Forks:      map[string]int64{"byzantium": 4370000, "constantinople": 7280000, "london": 12965000, "paris": 15537394},
+	},
+	{
+		Name:    Ropsten,
+		ChainId: 3,
+		Hasher:  HasherConfig{Algorithm: "ethash"},
+		Reward: RewardConfig{
+			Schedule: []RewardStep{
+				{0, "5000000000000000000"},
+				{1700000, "3000000000000000000"},
+				{4230000, "2000000000000000000"},
+			},
+		},
+		BurnFBlock: int64Ptr(10499401),
+		Forks:      map[string]int64{"byzantium": 1700000, "constantinople": 4230000, "london": 10499401},
+	},
+	{
+		Name:    Ubiq,
+		ChainId: 8,
+		Hasher:  HasherConfig{Algorithm: "ubqhash", Uip1Epoch: uint64Ptr(22)},
+		Reward: RewardConfig{
+			Schedule: []RewardStep{
+				{0, "8000000000000000000"},
+				{358364, "7000000000000000000"},
+				{716728, "6000000000000000000"},
+				{1075091, "5000000000000000000"},
+				{1433455, "4000000000000000000"},
+				{1791819, "3000000000000000000"},
+				{2150182, "2000000000000000000"},
+				{2508546, "1000000000000000000"},
+			},
+			UncleDepth: 2,
+		},
+	},
+	{
+		// EthereumPoW forked off Ethereum at the merge block and kept PoW with 2 ETHW block reward.
+		// After the fork baseFee goes to ETHW multisig instead of being burnt, still deducted from miner income
+		Name:    EthPoW,
+		ChainId: 10001,
+		Hasher:  HasherConfig{Algorithm: "ethash"},
+		Reward: RewardConfig{
+			Schedule: []RewardStep{
+				{0, "5000000000000000000"},
+				{4370000, "3000000000000000000"},
+				{7280000, "2000000000000000000"},
+			},
+		},
+		BurnFBlock: int64Ptr(12965000),
+		Forks:      map[string]int64{"byzantium": 4370000, "constantinople": 7280000, "london": 12965000, "ethpow": 15537394},
+	},
+}
+
+func init() {
+	for _, cfg := range builtinConfigs {
+		spec, err := NewSpec(cfg)
+		if err != nil {
+			panic(err)
+		}
+		Register(spec)
+	}
+}
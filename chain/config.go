@@ -0,0 +1,196 @@
+package chain
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/etclabscore/core-pool/payouts/coinhash"
+	"github.com/etclabscore/core-pool/rpc"
+
+	"github.com/etclabscore/go-etchash"
+)
+
+const (
+	// Uncle reward rules
+	UncleRuleEthash   = "ethash"   // (uncleHeight + depth - height) * reward / depth
+	UncleRuleEcip1017 = "ecip1017" // same as ethash in era 1, 1/32 of block reward after
+
+	defaultUncleDepth            = 8
+	defaultUncleInclusionDivisor = 32
+
+	epochLengthDefault  = 30000
+	epochLengthECIP1099 = 60000
+)
+
+// Chain spec config in JSON
+type Config struct {
+	Name    string       `json:"name"`
+	ChainId uint64       `json:"chainId"`
+	Hasher  HasherConfig `json:"hasher"`
+	Reward  RewardConfig `json:"reward"`
+	// EIP-1559 activation height, baseFeePerGas * gasUsed is deducted from block reward after it
+	BurnFBlock *int64 `json:"eip1559FBlock"`
+	// Fork heights, informational only
+	Forks map[string]int64 `json:"forks"`
+}
+
+type HasherConfig struct {
+	// Only etchash family is supported for now: ethash, etchash, ubqhash
+	Algorithm      string  `json:"algorithm"`
+	Ecip1099FBlock *uint64 `json:"ecip1099FBlock"`
+	Uip1Epoch      *uint64 `json:"uip1Epoch"`
+}
+
+type RewardConfig struct {
+	// Block reward steps, reward in Wei applies starting from height
+	Schedule []RewardStep `json:"schedule"`
+	// ECIP-1017 era length, if positive reward is reduced by 20% every era
+	EraLength int64 `json:"eraLength"`
+	// Uncle reward rule, ethash or ecip1017
+	UncleRule string `json:"uncleRule"`
+	// Max depth of includable uncle, 8 by default
+	UncleDepth int64 `json:"uncleDepth"`
+	// Uncle inclusion reward is block reward divided by this, 32 by default
+	UncleInclusionDivisor int64 `json:"uncleInclusionDivisor"`
+}
+
+type RewardStep struct {
+	Height int64  `json:"height"`
+	Reward string `json:"reward"`
+}
+
+type rewardStep struct {
+	height int64
+	reward *big.Int
+}
+
+// Chain spec backed by Config
+type spec struct {
+	config   Config
+	schedule []rewardStep
+	depth    *big.Int
+	divisor  *big.Int
+
+	hasherOnce sync.Once
+	hasher     Hasher
+}
+
+// NewSpec validates config and makes chain spec
+func NewSpec(cfg *Config) (ChainSpec, error) {
+	if len(cfg.Name) == 0 {
+		return nil, fmt.Errorf("name is required")
+	}
+	switch strings.ToLower(cfg.Hasher.Algorithm) {
+	case "", "ethash", "etchash", "ubqhash":
+	default:
+		return nil, fmt.Errorf("unsupported hasher %q", cfg.Hasher.Algorithm)
+	}
+	if len(cfg.Reward.Schedule) == 0 {
+		return nil, fmt.Errorf("reward schedule is empty")
+	}
+	s := &spec{config: *cfg}
+	for _, step := range cfg.Reward.Schedule {
+		reward, ok := new(big.Int).SetString(step.Reward, 0)
+		if !ok || reward.Sign() < 0 {
+			return nil, fmt.Errorf("invalid reward %q at height %v", step.Reward, step.Height)
+		}
+		s.schedule = append(s.schedule, rewardStep{height: step.Height, reward: reward})
+	}
+	sort.Slice(s.schedule, func(i, j int) bool { return s.schedule[i].height < s.schedule[j].height })
+
+	switch s.config.Reward.UncleRule {
+	case "":
+		s.config.Reward.UncleRule = UncleRuleEthash
+	case UncleRuleEthash, UncleRuleEcip1017:
+	default:
+		return nil, fmt.Errorf("unsupported uncle rule %q", s.config.Reward.UncleRule)
+	}
+	if s.config.Reward.UncleRule == UncleRuleEcip1017 && s.config.Reward.EraLength <= 0 {
+		return nil, fmt.Errorf("uncle rule %s requires eraLength", UncleRuleEcip1017)
+	}
+	if s.config.Reward.UncleDepth <= 0 {
+		s.config.Reward.UncleDepth = defaultUncleDepth
+	}
+	if s.config.Reward.UncleInclusionDivisor <= 0 {
+		s.config.Reward.UncleInclusionDivisor = defaultUncleInclusionDivisor
+	}
+	s.depth = big.NewInt(s.config.Reward.UncleDepth)
+	s.divisor = big.NewInt(s.config.Reward.UncleInclusionDivisor)
+	return s, nil
+}
+
+func (s *spec) Name() string {
+	return s.config.Name
+}
+
+func (s *spec) ChainID() uint64 {
+	return s.config.ChainId
+}
+
+func (s *spec) Hasher() Hasher {
+	s.hasherOnce.Do(func() {
+		s.hasher = etchash.New(s.config.Hasher.Ecip1099FBlock, s.config.Hasher.Uip1Epoch)
+	})
+	return s.hasher
+}
+
+func (s *spec) EpochLength(height uint64) uint64 {
+	if fblock := s.config.Hasher.Ecip1099FBlock; fblock != nil && height >= *fblock {
+		return epochLengthECIP1099
+	}
+	return epochLengthDefault
+}
+
+func (s *spec) era(height int64) *big.Int {
+	if s.config.Reward.EraLength <= 0 {
+		return new(big.Int)
+	}
+	return coinhash.GetBlockEra(big.NewInt(height), big.NewInt(s.config.Reward.EraLength))
+}
+
+func (s *spec) BlockReward(height int64) *big.Int {
+	reward := s.schedule[0].reward
+	for _, step := range s.schedule {
+		if height < step.height {
+			break
+		}
+		reward = step.reward
+	}
+	return coinhash.GetBlockWinnerRewardByEra(s.era(height), reward)
+}
+
+func (s *spec) UncleInclusionReward(height int64) *big.Int {
+	return new(big.Int).Div(s.BlockReward(height), s.divisor)
+}
+
+func (s *spec) UncleReward(uncleHeight, height int64) *big.Int {
+	reward := s.BlockReward(height)
+	if s.config.Reward.UncleRule == UncleRuleEcip1017 && s.era(height).Sign() > 0 {
+		return coinhash.GetRewardForUncle(reward)
+	}
+	return coinhash.GetUncleRewardByDepth(big.NewInt(uncleHeight), big.NewInt(height), reward, s.depth)
+}
+
+func (s *spec) BurntFees(height int64, block *rpc.GetBlockReply) *big.Int {
+	if s.config.BurnFBlock == nil || height < *s.config.BurnFBlock {
+		return new(big.Int)
+	}
+	return coinhash.CalcLondonBurntFees(block)
+}
+
+func (s *spec) ForkHeights() map[string]int64 {
+	forks := make(map[string]int64, len(s.config.Forks)+2)
+	for name, height := range s.config.Forks {
+		forks[name] = height
+	}
+	if fblock := s.config.Hasher.Ecip1099FBlock; fblock != nil {
+		forks["ecip1099"] = int64(*fblock)
+	}
+	if s.config.BurnFBlock != nil {
+		forks["eip1559"] = *s.config.BurnFBlock
+	}
+	return forks
+}
@@ -0,0 +1,113 @@
+package chain
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/etclabscore/core-pool/rpc"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Chain spec: PoW algorithm, epoch length, block and uncle rewards, burn rules and fork heights
+
+// PoW algorithm used to verify shares
+type Hasher interface {
+	Compute(blockNum uint64, hashNoNonce common.Hash, nonce uint64) (mixDigest common.Hash, result common.Hash)
+}
+
+type ChainSpec interface {
+	Name() string
+	// EIP-155 chain ID, 0 disables the check
+	ChainID() uint64
+	// PoW algorithm used to verify shares, created on first call
+	Hasher() Hasher
+	// DAG epoch length at given height
+	EpochLength(height uint64) uint64
+	// Static block reward at given height in Wei
+	BlockReward(height int64) *big.Int
+	// Extra reward for every included uncle
+	UncleInclusionReward(height int64) *big.Int
+	// Reward of uncle at uncleHeight included by block at height
+	UncleReward(uncleHeight, height int64) *big.Int
+	// Burnt fees deducted from block reward
+	BurntFees(height int64, block *rpc.GetBlockReply) *big.Int
+	// Fork names and heights
+	ForkHeights() map[string]int64
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]ChainSpec)
+)
+
+// Register adds chain spec, spec with the same name is replaced, so JSON file can override built-in networks
+func Register(spec ChainSpec) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[strings.ToLower(spec.Name())] = spec
+}
+
+// Lookup returns chain spec by network name
+func Lookup(name string) (ChainSpec, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	spec, ok := registry[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown network %q, known networks: %s", name, strings.Join(namesLocked(), ", "))
+	}
+	return spec, nil
+}
+
+// Names of registered networks
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return namesLocked()
+}
+
+func namesLocked() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadFile loads and registers chain specs from JSON file holding either single spec or array of specs
+func LoadFile(path string) ([]ChainSpec, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var configs []*Config
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "[") {
+		err = json.Unmarshal(data, &configs)
+	} else {
+		var cfg Config
+		err = json.Unmarshal(data, &cfg)
+		configs = append(configs, &cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid chain spec file %s: %v", path, err)
+	}
+
+	specs := make([]ChainSpec, 0, len(configs))
+	for _, cfg := range configs {
+		spec, err := NewSpec(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chain spec %q in %s: %v", cfg.Name, path, err)
+		}
+		specs = append(specs, spec)
+	}
+	for _, spec := range specs {
+		Register(spec)
+	}
+	return specs, nil
+}
@@ -0,0 +1,143 @@
+package chain
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/etclabscore/core-pool/rpc"
+)
+
+func eth(v string) *big.Int {
+	r, _ := new(big.Int).SetString(v, 10)
+	return r
+}
+
+func TestBuiltinRewards(t *testing.T) {
+	tests := []struct {
+		network string
+		height  int64
+		block   string
+		uncle   string
+	}{
+		{Classic, 5000000, "5000000000000000000", "156250000000000000"},
+		{Classic, 5000001, "4000000000000000000", "125000000000000000"},
+		{Classic, 11700000, "3200000000000000000", "100000000000000000"},
+		{Mordor, 2000001, "4000000000000000000", "125000000000000000"},
+		{Ethereum, 4369999, "5000000000000000000", "156250000000000000"},
+		{Ethereum, 4370000, "3000000000000000000", "93750000000000000"},
+		{Ethereum, 13000000, "2000000000000000000", "62500000000000000"},
+		{Ubiq, 358363, "8000000000000000000", "250000000000000000"},
+		{Ubiq, 358364, "7000000000000000000", "218750000000000000"},
+		{Ubiq, 3000000, "1000000000000000000", "31250000000000000"},
+	}
+	for _, tt := range tests {
+		spec, err := Lookup(tt.network)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r := spec.BlockReward(tt.height); r.Cmp(eth(tt.block)) != 0 {
+			t.Errorf("%s block reward at %v must be %v, got %v", tt.network, tt.height, tt.block, r)
+		}
+		if r := spec.UncleInclusionReward(tt.height); r.Cmp(eth(tt.uncle)) != 0 {
+			t.Errorf("%s uncle inclusion reward at %v must be %v, got %v", tt.network, tt.height, tt.uncle, r)
+		}
+	}
+}
+
+func TestUncleRewards(t *testing.T) {
+	classic, _ := Lookup(Classic)
+	// Era 1: 7/8 of block reward for uncle one block behind
+	if r := classic.UncleReward(99, 100); r.Cmp(eth("4375000000000000000")) != 0 {
+		t.Errorf("Classic era 1 uncle reward must be 7/8 of block reward, got %v", r)
+	}
+	// Era 2: fixed 1/32 of block reward
+	if r := classic.UncleReward(5000000, 5000002); r.Cmp(eth("125000000000000000")) != 0 {
+		t.Errorf("Classic era 2 uncle reward must be 1/32 of block reward, got %v", r)
+	}
+	ubiq, _ := Lookup(Ubiq)
+	if r := ubiq.UncleReward(99, 100); r.Cmp(eth("4000000000000000000")) != 0 {
+		t.Errorf("Ubiq uncle reward must be 1/2 of block reward, got %v", r)
+	}
+	if r := ubiq.UncleReward(97, 100); r.Sign() != 0 {
+		t.Errorf("Ubiq uncle older than depth must not be rewarded, got %v", r)
+	}
+}
+
+func TestBurntFees(t *testing.T) {
+	block := &rpc.GetBlockReply{BaseFeePerGas: "0x2", GasUsed: "0x5"}
+	ethereum, _ := Lookup(Ethereum)
+	if r := ethereum.BurntFees(12964999, block); r.Sign() != 0 {
+		t.Errorf("Fees must not be burnt before london, got %v", r)
+	}
+	if r := ethereum.BurntFees(12965000, block); r.Int64() != 10 {
+		t.Errorf("Fees must be burnt after london, got %v", r)
+	}
+	classic, _ := Lookup(Classic)
+	if r := classic.BurntFees(20000000, block); r.Sign() != 0 {
+		t.Errorf("Classic must not burn fees, got %v", r)
+	}
+}
+
+func TestEpochLength(t *testing.T) {
+	classic, _ := Lookup(Classic)
+	if classic.EpochLength(11699999) != 30000 || classic.EpochLength(11700000) != 60000 {
+		t.Error("Classic epoch length must double at ecip1099")
+	}
+	ethereum, _ := Lookup(Ethereum)
+	if ethereum.EpochLength(15000000) != 30000 {
+		t.Error("Ethereum epoch length must stay 30000")
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chainspec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "chains.json")
+	data := `[{
+		"name": "devnet",
+		"hasher": {"algorithm": "etchash", "ecip1099FBlock": 100},
+		"reward": {"schedule": [{"height": 0, "reward": "2000000000000000000"}, {"height": 1000, "reward": "0x0de0b6b3a7640000"}]},
+		"eip1559FBlock": 500,
+		"forks": {"shanghai": 1000}
+	}]`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	specs, err := LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(specs) != 1 {
+		t.Fatalf("Must load one spec, got %v", len(specs))
+	}
+	spec, err := Lookup("DevNet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := spec.BlockReward(999); r.Cmp(eth("2000000000000000000")) != 0 {
+		t.Errorf("Wrong reward before step, got %v", r)
+	}
+	if r := spec.BlockReward(1000); r.Cmp(eth("1000000000000000000")) != 0 {
+		t.Errorf("Wrong reward after step, got %v", r)
+	}
+	forks := spec.ForkHeights()
+	if forks["shanghai"] != 1000 || forks["ecip1099"] != 100 || forks["eip1559"] != 500 {
+		t.Errorf("Wrong fork heights: %v", forks)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`{"name": "broken", "reward": {"schedule": []}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFile(path); err == nil {
+		t.Error("Must reject spec without reward schedule")
+	}
+	if _, err := Lookup("broken"); err == nil {
+		t.Error("Invalid spec must not be registered")
+	}
+}
@@ -8,6 +8,7 @@ require (
 	github.com/ethereum/go-ethereum v1.10.9
 	github.com/garyburd/redigo v1.6.2 // indirect
 	github.com/gorilla/mux v1.8.0
+	github.com/gorilla/websocket v1.5.3
 	github.com/lestrrat-go/file-rotatelogs v2.4.0+incompatible
 	github.com/lestrrat-go/strftime v1.0.5 // indirect
 	github.com/natefinch/lumberjack v2.0.0+incompatible
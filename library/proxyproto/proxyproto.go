@@ -0,0 +1,233 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	v1MaxLength = 107 // 含结尾\r\n的v1头部最大长度
+	v2HeaderLen = 16
+)
+
+var (
+	v1Prefix    = []byte("PROXY ")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	ErrInvalidHeader = errors.New("proxyproto: invalid header")
+)
+
+// ParseCIDRs 解析可信来源列表，单个IP视为/32或/128
+func ParseCIDRs(list []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted address %q", s)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// Listener 仅对来自可信来源的连接解析PROXY协议头部，其他连接原样返回，防止伪造客户端地址
+type Listener struct {
+	net.Listener
+	Trusted []*net.IPNet
+	// 读取头部的超时时间
+	Timeout time.Duration
+}
+
+func NewListener(l net.Listener, trusted []*net.IPNet, timeout time.Duration) *Listener {
+	return &Listener{Listener: l, Trusted: trusted, Timeout: timeout}
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.isTrusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return &Conn{Conn: conn, br: bufio.NewReader(conn), timeout: l.Timeout}, nil
+}
+
+func (l *Listener) isTrusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range l.Trusted {
+		if n.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn 在首次Read或RemoteAddr时读取头部，没有头部的连接保持原地址
+type Conn struct {
+	net.Conn
+	br      *bufio.Reader
+	timeout time.Duration
+	once    sync.Once
+	remote  net.Addr
+	err     error
+}
+
+// NetConn 返回底层连接
+func (c *Conn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.br.Read(b)
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *Conn) readHeader() {
+	if c.timeout > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+	}
+	c.remote, c.err = ReadHeader(c.br)
+}
+
+// ReadHeader 解析v1或v2头部并返回客户端地址,
+// 不以头部开头的数据不会被消费，此时返回nil地址；LOCAL/UNKNOWN同样返回nil地址
+func ReadHeader(br *bufio.Reader) (net.Addr, error) {
+	first, err := br.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch first[0] {
+	case v1Prefix[0]:
+		b, err := br.Peek(len(v1Prefix))
+		if err != nil || !bytes.Equal(b, v1Prefix) {
+			return nil, nil
+		}
+		return readV1(br)
+	case v2Signature[0]:
+		b, err := br.Peek(len(v2Signature))
+		if err != nil || !bytes.Equal(b, v2Signature) {
+			return nil, nil
+		}
+		return readV2(br)
+	}
+	return nil, nil
+}
+
+// PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n
+func readV1(br *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < v1MaxLength {
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, ErrInvalidHeader
+	}
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) < 2 {
+		return nil, ErrInvalidHeader
+	}
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, ErrInvalidHeader
+	}
+	if len(fields) != 6 {
+		return nil, ErrInvalidHeader
+	}
+	ip := net.ParseIP(fields[2])
+	if ip == nil || (fields[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, ErrInvalidHeader
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, ErrInvalidHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func readV2(br *bufio.Reader) (net.Addr, error) {
+	var header [v2HeaderLen]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		return nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, ErrInvalidHeader
+	}
+	command := header[12] & 0x0f
+	family := header[13]
+	length := int(binary.BigEndian.Uint16(header[14:16]))
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		return nil, err
+	}
+
+	// LOCAL: 负载均衡器自身的健康检查
+	if command == 0 {
+		return nil, nil
+	}
+	if command != 1 {
+		return nil, ErrInvalidHeader
+	}
+	switch family {
+	case 0x11: // TCP over IPv4
+		if length < 12 {
+			return nil, ErrInvalidHeader
+		}
+		ip := net.IP(append([]byte(nil), payload[0:4]...))
+		return &net.TCPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+	case 0x21: // TCP over IPv6
+		if length < 36 {
+			return nil, ErrInvalidHeader
+		}
+		ip := net.IP(append([]byte(nil), payload[0:16]...))
+		return &net.TCPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+	}
+	// UDP、unix socket等地址不适用于矿池，按未知处理
+	return nil, nil
+}
@@ -0,0 +1,112 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+func TestReadHeaderV1(t *testing.T) {
+	br := bufio.NewReader(bytes.NewBufferString("PROXY TCP4 10.1.2.3 192.168.0.11 56324 8008\r\n{\"id\":1}\n"))
+	addr, err := ReadHeader(br)
+	if err != nil {
+		t.Fatalf("Must parse v1 header: %v", err)
+	}
+	if addr.String() != "10.1.2.3:56324" {
+		t.Errorf("Must return source address, got %v", addr)
+	}
+	rest, _ := ioutil.ReadAll(br)
+	if string(rest) != "{\"id\":1}\n" {
+		t.Errorf("Must keep payload after header, got %q", rest)
+	}
+
+	br = bufio.NewReader(bytes.NewBufferString("PROXY TCP6 2001:db8::1 2001:db8::2 4000 8008\r\n"))
+	addr, err = ReadHeader(br)
+	if err != nil || addr.String() != "[2001:db8::1]:4000" {
+		t.Errorf("Must parse TCP6 header, got %v %v", addr, err)
+	}
+
+	br = bufio.NewReader(bytes.NewBufferString("PROXY UNKNOWN\r\n"))
+	addr, err = ReadHeader(br)
+	if err != nil || addr != nil {
+		t.Errorf("UNKNOWN must keep connection address, got %v %v", addr, err)
+	}
+
+	br = bufio.NewReader(bytes.NewBufferString("PROXY TCP4 10.1.2.3 192.168.0.11 56324\r\n"))
+	if _, err = ReadHeader(br); err != ErrInvalidHeader {
+		t.Errorf("Must reject truncated header, got %v", err)
+	}
+	br = bufio.NewReader(bytes.NewBufferString("PROXY TCP4 2001:db8::1 192.168.0.11 56324 8008\r\n"))
+	if _, err = ReadHeader(br); err != ErrInvalidHeader {
+		t.Errorf("Must reject address of wrong family, got %v", err)
+	}
+}
+
+func TestReadHeaderV2(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Write(v2Signature)
+	buf.Write([]byte{0x21, 0x11})
+	binary.Write(&buf, binary.BigEndian, uint16(12))
+	buf.Write(net.ParseIP("10.1.2.3").To4())
+	buf.Write(net.ParseIP("192.168.0.11").To4())
+	binary.Write(&buf, binary.BigEndian, uint16(56324))
+	binary.Write(&buf, binary.BigEndian, uint16(8008))
+	buf.WriteString("payload")
+
+	br := bufio.NewReader(&buf)
+	addr, err := ReadHeader(br)
+	if err != nil {
+		t.Fatalf("Must parse v2 header: %v", err)
+	}
+	if addr.String() != "10.1.2.3:56324" {
+		t.Errorf("Must return source address, got %v", addr)
+	}
+	rest, _ := ioutil.ReadAll(br)
+	if string(rest) != "payload" {
+		t.Errorf("Must keep payload after header, got %q", rest)
+	}
+
+	// LOCAL command carries no address
+	buf.Reset()
+	buf.Write(v2Signature)
+	buf.Write([]byte{0x20, 0x00, 0x00, 0x00})
+	addr, err = ReadHeader(bufio.NewReader(&buf))
+	if err != nil || addr != nil {
+		t.Errorf("LOCAL must keep connection address, got %v %v", addr, err)
+	}
+}
+
+func TestReadHeaderPassthrough(t *testing.T) {
+	br := bufio.NewReader(bytes.NewBufferString("{\"id\":1,\"method\":\"mining.subscribe\"}\n"))
+	addr, err := ReadHeader(br)
+	if err != nil || addr != nil {
+		t.Errorf("Must pass through data without header, got %v %v", addr, err)
+	}
+	rest, _ := ioutil.ReadAll(br)
+	if string(rest) != "{\"id\":1,\"method\":\"mining.subscribe\"}\n" {
+		t.Errorf("Must not consume data, got %q", rest)
+	}
+}
+
+func TestListenerTrusted(t *testing.T) {
+	trusted, err := ParseCIDRs([]string{"10.0.0.0/8", "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &Listener{Trusted: trusted}
+	if !l.isTrusted(&net.TCPAddr{IP: net.ParseIP("127.0.0.1")}) {
+		t.Error("Single address must be trusted")
+	}
+	if !l.isTrusted(&net.TCPAddr{IP: net.ParseIP("10.20.30.40")}) {
+		t.Error("Address in CIDR must be trusted")
+	}
+	if l.isTrusted(&net.TCPAddr{IP: net.ParseIP("192.168.1.1")}) {
+		t.Error("Address outside of CIDRs must not be trusted")
+	}
+	if _, err = ParseCIDRs([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("Must reject invalid CIDR")
+	}
+}
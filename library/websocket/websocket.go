@@ -0,0 +1,155 @@
+package websocket
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 协议实现由 gorilla/websocket 提供，这里只把消息流适配为 net.Conn
+const DefaultMaxMessageSize = 64 * 1024
+
+var (
+	ErrBadHandshake   = websocket.ErrBadHandshake
+	ErrMessageTooLong = websocket.ErrReadLimit
+)
+
+var upgrader = websocket.Upgrader{
+	// 矿机与浏览器矿工都可能连接，不校验 Origin
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// Conn 将websocket消息流转换为字节流，可当作net.Conn使用:
+// 每条收到的消息以换行结尾，便于按行读取；每次Write作为一条文本消息发送
+type Conn struct {
+	ws *websocket.Conn
+
+	wmu sync.Mutex
+	buf []byte
+}
+
+func newConn(ws *websocket.Conn) *Conn {
+	ws.SetReadLimit(DefaultMaxMessageSize)
+	return &Conn{ws: ws}
+}
+
+// IsUpgrade 判断是否为websocket握手请求
+func IsUpgrade(r *http.Request) bool {
+	return websocket.IsWebSocketUpgrade(r)
+}
+
+// Upgrade 完成服务端握手并接管HTTP连接，失败时已向客户端回复错误
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return nil, err
+	}
+	return newConn(ws), nil
+}
+
+// SetMaxMessageSize 收到的消息超过此长度时断开连接
+func (c *Conn) SetMaxMessageSize(n int) {
+	c.ws.SetReadLimit(int64(n))
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	for len(c.buf) == 0 {
+		msg, err := c.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+		if len(msg) > 0 && msg[len(msg)-1] != '\n' {
+			msg = append(msg, '\n')
+		}
+		c.buf = msg
+	}
+	n := copy(b, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if err := c.ws.WriteMessage(websocket.TextMessage, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close 发送关闭帧后关闭底层连接，可与Write并发调用
+func (c *Conn) Close() error {
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	c.ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	return c.ws.Close()
+}
+
+// ReadMessage 读取一条完整的数据消息，控制帧在内部处理，收到关闭帧时返回io.EOF
+func (c *Conn) ReadMessage() ([]byte, error) {
+	_, msg, err := c.ws.ReadMessage()
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		return nil, io.EOF
+	}
+	return msg, err
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.ws.LocalAddr()
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.ws.RemoteAddr()
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	if err := c.ws.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.ws.SetWriteDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.ws.SetReadDeadline(t)
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.ws.SetWriteDeadline(t)
+}
+
+// Dial 作为客户端连接ws://或wss://地址
+func Dial(rawurl string, timeout time.Duration) (*Conn, error) {
+	return DialConfig(rawurl, timeout, nil, nil)
+}
+
+// DialConfig 与 Dial 相同，可附加握手请求头及 wss 使用的 TLS 配置
+func DialConfig(rawurl string, timeout time.Duration, header http.Header, tlsConfig *tls.Config) (*Conn, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return nil, fmt.Errorf("websocket: unsupported scheme %q", u.Scheme)
+	}
+	dialer := &websocket.Dialer{
+		NetDial:          (&net.Dialer{Timeout: timeout}).Dial,
+		HandshakeTimeout: timeout,
+		TLSClientConfig:  tlsConfig,
+	}
+	ws, resp, err := dialer.Dial(rawurl, header)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("%v, status %s", err, resp.Status)
+		}
+		return nil, err
+	}
+	return newConn(ws), nil
+}
@@ -0,0 +1,120 @@
+package websocket
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Echo server answers every line with the same line
+func newEchoServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		br := bufio.NewReader(conn)
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				return
+			}
+			conn.Write([]byte(line))
+		}
+	}))
+}
+
+func wsURL(srv *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+}
+
+func TestConnRoundTrip(t *testing.T) {
+	srv := newEchoServer()
+	defer srv.Close()
+
+	client, err := Dial(wsURL(srv), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.Write([]byte(`{"id":1,"method":"mining.subscribe"}`))
+	line, err := bufio.NewReader(client).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "{\"id\":1,\"method\":\"mining.subscribe\"}\n" {
+		t.Errorf("Must read message as line, got %q", line)
+	}
+
+	client.SetMaxMessageSize(1 << 20)
+	client.Write([]byte(strings.Repeat("a", DefaultMaxMessageSize-1)))
+	msg, err := client.ReadMessage()
+	if err != nil || len(msg) != DefaultMaxMessageSize {
+		t.Errorf("Must echo long message, got %d bytes, %v", len(msg), err)
+	}
+}
+
+func TestConnMessageTooLong(t *testing.T) {
+	srv := newEchoServer()
+	defer srv.Close()
+
+	client, err := Dial(wsURL(srv), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.Write([]byte(strings.Repeat("a", DefaultMaxMessageSize+1)))
+	if _, err = client.ReadMessage(); err == nil {
+		t.Error("Server must drop connection on long message")
+	}
+}
+
+func TestConnClose(t *testing.T) {
+	done := make(chan error, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			done <- err
+			return
+		}
+		_, err = conn.Read(make([]byte, 16))
+		done <- err
+	}))
+	defer srv.Close()
+
+	client, err := Dial(wsURL(srv), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	select {
+	case err = <-done:
+		if err != io.EOF {
+			t.Errorf("Must return EOF on close, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close frame must end read")
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	srv := newEchoServer()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Must reject plain request, got %v", resp.StatusCode)
+	}
+	if _, err = Dial("http"+strings.TrimPrefix(srv.URL, "http"), time.Second); err == nil {
+		t.Error("Must reject non websocket scheme")
+	}
+}
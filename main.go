@@ -3,6 +3,7 @@
 package main
 
 import (
+	"github.com/etclabscore/core-pool/chain"
 	"github.com/etclabscore/core-pool/common"
 	"github.com/etclabscore/core-pool/library/clean"
 	"github.com/etclabscore/core-pool/library/logger"
@@ -13,6 +14,7 @@ import (
 var (
 	cfg         proxy.Config
 	backend     *storage.RedisClient
+	chainSpec   chain.ChainSpec
 	runLevelMap = map[string]bool{
 		"production": false,
 		"testing":    false,
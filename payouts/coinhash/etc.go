@@ -6,13 +6,6 @@ import (
 	"github.com/ethereum/go-ethereum/common/math"
 )
 
-// etc挖矿算法配置
-
-type EtcConfig struct {
-	Ecip1017FBlock    int64    `json:"ecip1017FBlock"`
-	Ecip1017EraRounds *big.Int `json:"ecip1017EraRounds"`
-}
-
 // params for etchash
 var (
 	HomesteadReward          = math.MustParseBig256("5000000000000000000")
@@ -20,11 +13,21 @@ var (
 	DisinflationRateDivisor  = big.NewInt(5) // Disinflation rate divisor for ECIP1017
 )
 
-// etchash 正常情况奖励
-func GetConstReward(era *big.Int) *big.Int {
-	var blockReward = HomesteadReward
-	wr := GetBlockWinnerRewardByEra(era, blockReward)
-	return wr
+// GetBlockEra gets which "Era" a given block is within, given an era length (ecip-1017 has era=5,000,000 blocks)
+// Returns a zero-index era number, so "Era 1": 0, "Era 2": 1, "Era 3": 2 ...
+func GetBlockEra(blockNum, eraLength *big.Int) *big.Int {
+	// If genesis block or impossible negative-numbered block, return zero-val.
+	if blockNum.Sign() < 1 {
+		return new(big.Int)
+	}
+
+	remainder := big.NewInt(0).Mod(big.NewInt(0).Sub(blockNum, big.NewInt(1)), eraLength)
+	base := big.NewInt(0).Sub(blockNum, remainder)
+
+	d := big.NewInt(0).Div(base, eraLength)
+	dremainder := big.NewInt(0).Mod(d, big.NewInt(1))
+
+	return new(big.Int).Sub(d, dremainder)
 }
 
 // GetRewardByEra gets a block reward at disinflation rate.
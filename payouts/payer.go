@@ -1,12 +1,14 @@
 package payouts
 
 import (
+	"context"
 	"fmt"
 	"math/big"
 	"os"
 	"strconv"
 	"time"
 
+	"github.com/etclabscore/core-pool/chain"
 	"github.com/etclabscore/core-pool/common"
 	"github.com/etclabscore/core-pool/library/logger"
 	"github.com/etclabscore/core-pool/rpc"
@@ -21,15 +23,18 @@ import (
 const txCheckInterval = 5 * time.Second
 
 type PayoutsConfig struct {
-	Enabled      bool   `json:"enabled"`
-	RequirePeers int64  `json:"requirePeers"`
-	Interval     string `json:"interval"`
-	Daemon       string `json:"daemon"`
-	Timeout      string `json:"timeout"`
-	Address      string `json:"address"`
-	Gas          string `json:"gas"`
-	GasPrice     string `json:"gasPrice"`
-	AutoGas      bool   `json:"autoGas"`
+	Enabled      bool      `json:"enabled"`
+	RequirePeers int64     `json:"requirePeers"`
+	Interval     string    `json:"interval"`
+	Daemon       string    `json:"daemon"`
+	DaemonAuth   *rpc.Auth `json:"daemonAuth"`
+	// 节点请求的超时与重试策略
+	DaemonCallPolicy *rpc.CallPolicy `json:"daemonCallPolicy"`
+	Timeout          string          `json:"timeout"`
+	Address          string          `json:"address"`
+	Gas              string          `json:"gas"`
+	GasPrice         string          `json:"gasPrice"`
+	AutoGas          bool            `json:"autoGas"`
 	// In Shannon
 	Threshold int64 `json:"threshold"`
 	BgSave    bool  `json:"bgsave"`
@@ -49,13 +54,18 @@ type PayoutsProcessor struct {
 	config   *PayoutsConfig
 	backend  *storage.RedisClient
 	rpc      *rpc.RPCClient
+	chain    chain.ChainSpec
 	halt     bool
 	lastFail error
 }
 
-func NewPayoutsProcessor(cfg *PayoutsConfig, backend *storage.RedisClient) *PayoutsProcessor {
-	u := &PayoutsProcessor{config: cfg, backend: backend}
-	u.rpc = rpc.NewRPCClient("PayoutsProcessor", cfg.Daemon, cfg.Timeout)
+func NewPayoutsProcessor(cfg *PayoutsConfig, backend *storage.RedisClient, spec chain.ChainSpec) *PayoutsProcessor {
+	u := &PayoutsProcessor{config: cfg, backend: backend, chain: spec}
+	var err error
+	u.rpc, err = rpc.NewClient("PayoutsProcessor", cfg.Daemon, cfg.Timeout, cfg.DaemonAuth, cfg.DaemonCallPolicy)
+	if err != nil {
+		logger.Fatal("Failed to setup payouts daemon connection: %v", err)
+	}
 	return u
 }
 
@@ -116,6 +126,11 @@ func (u *PayoutsProcessor) process() {
 		logger.Error("Payments suspended due to last critical error: %v", u.lastFail)
 		return
 	}
+	ctx := common.RoutineCtx
+	// 节点所在链与配置的网络不一致时，交易可能在另一条链上被广播
+	if !u.checkChainID(ctx) {
+		return
+	}
 	mustPay := 0
 	minersPaid := 0
 	totalAmount := big.NewInt(0)
@@ -127,6 +142,11 @@ func (u *PayoutsProcessor) process() {
 
 	// 循环从backend拿到的所有需要支付的记录，并处理(tips: login是钱包地址)
 	for _, login := range payees {
+		// 服务停止时不再开始新的支付
+		if ctx.Err() != nil {
+			logger.Warn("Payouts interrupted by shutdown")
+			break
+		}
 		amount, err := u.backend.GetBalance(login)
 		if err != nil {
 			err = fmt.Errorf("Get %s balance fail, from backend err: %v", login, err)
@@ -150,17 +170,17 @@ func (u *PayoutsProcessor) process() {
 
 		// Require active peers before processing
 		// 检查钱包连上的节点数是否满足
-		if !u.checkPeers() {
+		if !u.checkPeers(ctx) {
 			break
 		}
 		// Require unlocked account
 		// 检查付款账户是否处于解锁状态(内部实现是签名操作)
-		if !u.isUnlockedAccount() {
+		if !u.isUnlockedAccount(ctx) {
 			break
 		}
 
 		// Check if we have enough funds
-		poolBalance, err := u.rpc.GetBalance(u.config.Address)
+		poolBalance, err := u.rpc.GetBalance(ctx, u.config.Address)
 		if err != nil {
 			err = fmt.Errorf("Get pool balance failed, err: %v", err)
 			logger.Error(err.Error())
@@ -199,7 +219,10 @@ func (u *PayoutsProcessor) process() {
 		}
 
 		value := hexutil.EncodeBig(amountInWei)
-		txHash, err := u.rpc.SendTransaction(u.config.Address, login, u.config.GasHex(), u.config.GasPriceHex(), value, u.config.AutoGas)
+		// 支付已锁定且余额已扣除，交易不能因服务停止而中断
+		sendCtx, cancel := context.WithTimeout(context.Background(), u.rpc.Timeout("eth_sendTransaction"))
+		txHash, err := u.rpc.SendTransaction(sendCtx, u.config.Address, login, u.config.GasHex(), u.config.GasPriceHex(), value, u.config.AutoGas)
+		cancel()
 		if err != nil {
 			err = fmt.Errorf("Failed to send payment to %s, %v Shannon: %v. Check outgoing tx for %s in block explorer and docs/PAYOUTS.md",
 				login, amount, err, login)
@@ -226,23 +249,8 @@ func (u *PayoutsProcessor) process() {
 
 		// Wait for TX confirmation before further payouts
 		// 在支付新的交易之前，先等待当前交易确认
-		for {
-			logger.Info("Waiting for tx confirmation: %v", txHash)
-			time.Sleep(txCheckInterval)
-			receipt, err := u.rpc.GetTxReceipt(txHash)
-			if err != nil {
-				logger.Error("Failed to get tx receipt for %v: %v", txHash, err)
-				continue
-			}
-			// Tx has been mined
-			if receipt != nil && receipt.Confirmed() {
-				if receipt.Successful() {
-					logger.Info("Payout tx successful for %s: %s", login, txHash)
-				} else {
-					logger.Error("Payout tx failed for %s: %s. Address contract throws on incoming tx.", login, txHash)
-				}
-				break
-			}
+		if !u.waitForTx(ctx, login, txHash) {
+			break
 		}
 	}
 
@@ -258,9 +266,36 @@ func (u *PayoutsProcessor) process() {
 	}
 }
 
+// 等待交易被打包确认，服务停止时返回 false
+func (u *PayoutsProcessor) waitForTx(ctx context.Context, login, txHash string) bool {
+	for {
+		logger.Info("Waiting for tx confirmation: %v", txHash)
+		select {
+		case <-ctx.Done():
+			logger.Warn("Stopped waiting for payout tx confirmation for %s on shutdown: %s", login, txHash)
+			return false
+		case <-time.After(txCheckInterval):
+		}
+		receipt, err := u.rpc.GetTxReceipt(ctx, txHash)
+		if err != nil {
+			logger.Error("Failed to get tx receipt for %v: %v", txHash, err)
+			continue
+		}
+		// Tx has been mined
+		if receipt != nil && receipt.Confirmed() {
+			if receipt.Successful() {
+				logger.Info("Payout tx successful for %s: %s", login, txHash)
+			} else {
+				logger.Error("Payout tx failed for %s: %s. Address contract throws on incoming tx.", login, txHash)
+			}
+			return true
+		}
+	}
+}
+
 // 钱包账户签名（用于判断钱包地址是否解锁）
-func (self PayoutsProcessor) isUnlockedAccount() bool {
-	_, err := self.rpc.Sign(self.config.Address, "0x0")
+func (self PayoutsProcessor) isUnlockedAccount(ctx context.Context) bool {
+	_, err := self.rpc.Sign(ctx, self.config.Address, "0x0")
 	if err != nil {
 		logger.Error("Unable to process payouts: %v", err)
 		return false
@@ -269,8 +304,8 @@ func (self PayoutsProcessor) isUnlockedAccount() bool {
 }
 
 // 钱包连接的节点数量检查（用于保证交易确认成功率与速度）
-func (self PayoutsProcessor) checkPeers() bool {
-	n, err := self.rpc.GetPeerCount()
+func (self PayoutsProcessor) checkPeers(ctx context.Context) bool {
+	n, err := self.rpc.GetPeerCount(ctx)
 	if err != nil {
 		logger.Error("Unable to start payouts, failed to retrieve number of peers from node: %v", err)
 		return false
@@ -282,6 +317,24 @@ func (self PayoutsProcessor) checkPeers() bool {
 	return true
 }
 
+// 钱包节点链 ID 检查（防止在分叉链上支付，例如 ETH 与 ETHW）
+func (self PayoutsProcessor) checkChainID(ctx context.Context) bool {
+	expected := self.chain.ChainID()
+	if expected == 0 {
+		return true
+	}
+	id, err := self.rpc.GetChainID(ctx)
+	if err != nil {
+		logger.Error("Unable to start payouts, failed to retrieve chain id from node: %v", err)
+		return false
+	}
+	if id != expected {
+		logger.Error("Unable to start payouts, node is on chain %d but %s network requires %d", id, self.chain.Name(), expected)
+		return false
+	}
+	return true
+}
+
 // 判断是否满足支付的最小值
 func (self PayoutsProcessor) reachedThreshold(amount *big.Int) bool {
 	return big.NewInt(self.config.Threshold).Cmp(amount) < 0
@@ -336,8 +389,9 @@ func (self PayoutsProcessor) resolvePayouts() {
 }
 
 // 维护模式: 根据系统环境变量RESOLVE_PAYOUT，判断是否必须要解决支付问题
-//  RESOLVE_PAYOUT=1 或 RESOLVE_PAYOUT=0
-//  tips: 为1时必须解决，0则不用
+//
+//	RESOLVE_PAYOUT=1 或 RESOLVE_PAYOUT=0
+//	tips: 为1时必须解决，0则不用
 func (self PayoutsProcessor) mustResolvePayout() bool {
 	v, _ := strconv.ParseBool(os.Getenv("RESOLVE_PAYOUT"))
 	return v
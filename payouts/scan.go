@@ -0,0 +1,141 @@
+package payouts
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/etclabscore/core-pool/library/logger"
+	"github.com/etclabscore/core-pool/rpc"
+	"github.com/etclabscore/core-pool/storage"
+)
+
+// 单次解锁过程共享的区块缓存，候选块的 ±16 区块窗口批量获取
+type chainScan struct {
+	rpc    *rpc.RPCClient
+	blocks map[int64]*rpc.GetBlockReply
+	uncles map[int64][]*rpc.GetBlockReply
+
+	start    time.Time
+	calls    uint64
+	requests uint64
+}
+
+func newChainScan(client *rpc.RPCClient) *chainScan {
+	calls, requests := client.Counters()
+	return &chainScan{
+		rpc:      client,
+		blocks:   make(map[int64]*rpc.GetBlockReply),
+		uncles:   make(map[int64][]*rpc.GetBlockReply),
+		start:    time.Now(),
+		calls:    calls,
+		requests: requests,
+	}
+}
+
+// 候选块需要扫描的高度范围
+func scanWindow(candidate *storage.BlockData) []int64 {
+	heights := make([]int64, 0, minDepth*2)
+	for i := int64(minDepth * -1); i < minDepth; i++ {
+		if height := candidate.Height + i; height >= 0 {
+			heights = append(heights, height)
+		}
+	}
+	return heights
+}
+
+// 批量获取所有候选块窗口内尚未缓存的区块及其叔块
+func (c *chainScan) prefetch(ctx context.Context, candidates []*storage.BlockData) error {
+	var heights []int64
+	seen := make(map[int64]bool)
+	for _, candidate := range candidates {
+		if candidate.Height < minDepth {
+			continue
+		}
+		for _, height := range scanWindow(candidate) {
+			if _, ok := c.blocks[height]; !ok && !seen[height] {
+				seen[height] = true
+				heights = append(heights, height)
+			}
+		}
+	}
+	if len(heights) == 0 {
+		return nil
+	}
+
+	blocks, err := c.rpc.GetBlocksByHeight(ctx, heights)
+	if err != nil {
+		return err
+	}
+	var uncleHeights []int64
+	var uncleIndexes []int
+	for i, height := range heights {
+		block := blocks[i]
+		if block == nil {
+			return fmt.Errorf("Error while retrieving block %v from node, wrong node height. ", height)
+		}
+		c.blocks[height] = block
+		for index := range block.Uncles {
+			uncleHeights = append(uncleHeights, height)
+			uncleIndexes = append(uncleIndexes, index)
+		}
+	}
+	if len(uncleHeights) == 0 {
+		return nil
+	}
+
+	uncles, err := c.rpc.GetUncles(ctx, uncleHeights, uncleIndexes)
+	if err != nil {
+		return fmt.Errorf("Error while retrieving uncles from node: %v ", err)
+	}
+	for i, uncle := range uncles {
+		if uncle == nil {
+			return fmt.Errorf("Error while retrieving uncle of block %v from node. ", uncleHeights[i])
+		}
+		c.uncles[uncleHeights[i]] = append(c.uncles[uncleHeights[i]], uncle)
+	}
+	return nil
+}
+
+func (c *chainScan) block(height int64) *rpc.GetBlockReply {
+	return c.blocks[height]
+}
+
+// 区块内的叔块，顺序与 block.Uncles 一致
+func (c *chainScan) blockUncles(height int64) []*rpc.GetBlockReply {
+	return c.uncles[height]
+}
+
+func (c *chainScan) finish(candidates int) {
+	calls, requests := c.rpc.Counters()
+	logger.Info("Scanned %v blocks for %v candidates in %v, %v rpc calls with %v requests",
+		len(c.blocks), candidates, time.Since(c.start), calls-c.calls, requests-c.requests)
+}
+
+// 获取区块全部交易收据，节点支持时使用 eth_getBlockReceipts，否则批量请求 eth_getTransactionReceipt
+func (u *BlockUnlocker) getReceipts(ctx context.Context, height int64, block *rpc.GetBlockReply) ([]*rpc.TxReceipt, error) {
+	if len(block.Transactions) == 0 {
+		return nil, nil
+	}
+	if !u.noBlockReceipts {
+		receipts, err := u.rpc.GetBlockReceipts(ctx, height)
+		switch {
+		case rpc.IsMethodNotFound(err):
+			logger.Warn("Node doesn't support eth_getBlockReceipts, falling back to batched receipts: %v", err)
+			u.noBlockReceipts = true
+		case err != nil:
+			return nil, err
+		case len(receipts) == len(block.Transactions):
+			return receipts, nil
+		default:
+			logger.Warn("Node returned %v receipts for %v transactions of block %v, falling back to batched receipts",
+				len(receipts), len(block.Transactions), height)
+		}
+	}
+
+	hashes := make([]string, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		hashes[i] = tx.Hash
+	}
+	return u.rpc.GetTxReceipts(ctx, hashes)
+}
@@ -0,0 +1,113 @@
+package payouts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/etclabscore/core-pool/chain"
+	"github.com/etclabscore/core-pool/library/logger"
+	"github.com/etclabscore/core-pool/rpc"
+	"github.com/etclabscore/core-pool/storage"
+
+	"go.uber.org/zap"
+)
+
+type fakeReq struct {
+	Id     uint64        `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+// Fake node with block 100 found by pool and block 120 including pool's uncle
+func fakeChain(methods map[string]int) http.HandlerFunc {
+	height := func(v interface{}) int64 {
+		n, _ := strconv.ParseInt(strings.TrimPrefix(v.(string), "0x"), 16, 64)
+		return n
+	}
+	reply := func(req fakeReq) map[string]interface{} {
+		methods[req.Method]++
+		resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.Id}
+		switch req.Method {
+		case "eth_getBlockByNumber":
+			n := height(req.Params[0])
+			block := map[string]interface{}{"number": fmt.Sprintf("0x%x", n), "hash": fmt.Sprintf("0x%064x", n), "nonce": "0x1"}
+			if n == 100 {
+				block["nonce"] = "0xabc"
+				block["transactions"] = []map[string]string{{"hash": "0x01", "gasPrice": "0x1"}, {"hash": "0x02", "gasPrice": "0x2"}}
+			}
+			if n == 120 {
+				block["uncles"] = []string{"0xu"}
+			}
+			resp["result"] = block
+		case "eth_getUncleByBlockNumberAndIndex":
+			resp["result"] = map[string]string{"number": "0x76", "hash": fmt.Sprintf("0x%064x", 118), "nonce": "0xdef"}
+		case "eth_getTransactionReceipt":
+			resp["result"] = map[string]string{"transactionHash": req.Params[0].(string), "gasUsed": "0x5208"}
+		default:
+			resp["error"] = map[string]interface{}{"code": -32601, "message": "the method " + req.Method + " does not exist/is not available"}
+		}
+		return resp
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		if bytes.HasPrefix(data, []byte("[")) {
+			var reqs []fakeReq
+			json.Unmarshal(data, &reqs)
+			resps := make([]map[string]interface{}, len(reqs))
+			for i, req := range reqs {
+				resps[i] = reply(req)
+			}
+			json.NewEncoder(w).Encode(resps)
+			return
+		}
+		var req fakeReq
+		json.Unmarshal(data, &req)
+		json.NewEncoder(w).Encode(reply(req))
+	}
+}
+
+func TestUnlockCandidatesBatched(t *testing.T) {
+	logger.SugarLogger = zap.NewNop().Sugar()
+	methods := make(map[string]int)
+	srv := httptest.NewServer(fakeChain(methods))
+	defer srv.Close()
+
+	client, _ := rpc.NewClient("node", srv.URL, "1s", nil, nil)
+	spec, _ := chain.NewSpec(&chain.Config{Name: "devnet", Reward: chain.RewardConfig{Schedule: []chain.RewardStep{{Height: 0, Reward: "0"}}}})
+	u := &BlockUnlocker{config: &UnlockerConfig{}, rpc: client, chain: spec}
+	block := &storage.BlockData{Height: 98, Nonce: "0xabc"}
+	uncle := &storage.BlockData{Height: 117, Nonce: "0xdef"}
+	lost := &storage.BlockData{Height: 110, Nonce: "0x999"}
+
+	result, err := u.unlockCandidates(context.Background(), []*storage.BlockData{block, uncle, lost})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.blocks != 1 || result.uncles != 1 || result.orphans != 1 {
+		t.Fatalf("Unexpected result: %v blocks, %v uncles, %v orphans", result.blocks, result.uncles, result.orphans)
+	}
+	if block.Height != 100 || block.Reward.Int64() != 21000*3 {
+		t.Errorf("Block must be matched at height 100 with tx fees, got %v, %v", block.Height, block.Reward)
+	}
+	if uncle.Height != 120 || uncle.UncleHeight != 118 {
+		t.Errorf("Uncle must be matched in block 120, got %v/%v", uncle.Height, uncle.UncleHeight)
+	}
+	// Windows of all candidates overlap, every block is fetched once
+	if methods["eth_getBlockByNumber"] != 32+12+7 {
+		t.Errorf("Blocks must be fetched once per pass, fetched %v", methods["eth_getBlockByNumber"])
+	}
+	if !u.noBlockReceipts || methods["eth_getTransactionReceipt"] != 2 {
+		t.Errorf("Must fall back to batched receipts, got %v", methods)
+	}
+	if calls, _ := client.Counters(); calls != 4 {
+		t.Errorf("Must use 4 round trips for blocks, uncles and receipts, used %v", calls)
+	}
+}
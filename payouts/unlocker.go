@@ -1,12 +1,15 @@
 package payouts
 
 import (
+	"context"
 	"fmt"
+	"math"
 	"math/big"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/etclabscore/core-pool/chain"
 	"github.com/etclabscore/core-pool/common"
 	"github.com/etclabscore/core-pool/library/logger"
 	"github.com/etclabscore/core-pool/payouts/coinhash"
@@ -18,65 +21,95 @@ import (
 // ETH 算法类区块解锁器
 
 type UnlockerConfig struct {
-	Enabled        bool    `json:"enabled"`
-	PoolFee        float64 `json:"poolFee"`
-	PoolFeeAddress string  `json:"poolFeeAddress"`
-	Donate         bool    `json:"donate"`
-	Depth          int64   `json:"depth"`
-	ImmatureDepth  int64   `json:"immatureDepth"`
-	KeepTxFees     bool    `json:"keepTxFees"`
-	Interval       string  `json:"interval"`
-	Daemon         string  `json:"daemon"`
-	Timeout        string  `json:"timeout"`
-	Network        string  `json:"network"`
+	Enabled        bool      `json:"enabled"`
+	PoolFee        float64   `json:"poolFee"`
+	PoolFeeAddress string    `json:"poolFeeAddress"`
+	Donate         bool      `json:"donate"`
+	Depth          int64     `json:"depth"`
+	ImmatureDepth  int64     `json:"immatureDepth"`
+	KeepTxFees     bool      `json:"keepTxFees"`
+	Interval       string    `json:"interval"`
+	Daemon         string    `json:"daemon"`
+	DaemonAuth     *rpc.Auth `json:"daemonAuth"`
+	// 节点请求的超时与重试策略
+	DaemonCallPolicy *rpc.CallPolicy `json:"daemonCallPolicy"`
+	Timeout          string          `json:"timeout"`
+	Network          string          `json:"network"`
+	// 收益分配方式 prop、pplns、pps、fpps 或 score
+	RewardScheme string `json:"rewardScheme"`
+	// PPLNS 窗口 N = pplnsWindow * 全网难度
+	PPLNSWindow float64 `json:"pplnsWindow"`
+	// FPPS 按最近多少个成熟区块计算平均交易手续费
+	PPSFeeWindow int64 `json:"ppsFeeWindow"`
+	// score 模式下份额分数每经过此时间增长为 e 倍
+	ScoreDecay string `json:"scoreDecay"`
+	// SOLO 区块手续费百分比，1.0 为 1%
+	SoloFee float64 `json:"soloFee"`
+}
+
+const (
+	// 按出块轮次内的份额比例分配
+	SchemePROP = "prop"
+	// 按出块前最近 N 份额分配
+	SchemePPLNS = "pplns"
+	// 每个份额按区块奖励立即支付，区块收益归入矿池风险准备金
+	SchemePPS = "pps"
+	// 同 PPS，另加最近成熟区块的平均交易手续费
+	SchemeFPPS = "fpps"
+	// 按份额分数分配，分数随提交时间指数增长，每个区块后重新计算
+	SchemeScore = "score"
+
+	defaultPPLNSWindow  = 2.0
+	defaultPPSFeeWindow = 100
+	defaultScoreDecay   = 5 * time.Minute
+)
+
+// 份额分数的衰减常数，未设置时使用默认值
+func ScoreDecay(cfg *UnlockerConfig) time.Duration {
+	if len(cfg.ScoreDecay) == 0 {
+		return defaultScoreDecay
+	}
+	return util.MustParseDuration(cfg.ScoreDecay)
+}
+
+// 是否为按份额立即支付的模式
+func IsPPS(scheme string) bool {
+	return scheme == SchemePPS || scheme == SchemeFPPS
 }
 
 type BlockUnlocker struct {
 	config   *UnlockerConfig
 	backend  *storage.RedisClient
 	rpc      *rpc.RPCClient
+	chain    chain.ChainSpec
 	halt     bool
 	lastFail error
+	// 节点不支持 eth_getBlockReceipts
+	noBlockReceipts bool
 }
 
-const (
-	minDepth = 16
-
-	// network常量
-	EtcNetwork     = "classic"
-	MordorNetwork  = "mordor"
-	EthNetwork     = "ethereum"
-	RopstenNetwork = "ropsten"
-	UbiqNetwork    = "ubiq"
-)
+const minDepth = 16
+
+func NewBlockUnlocker(cfg *UnlockerConfig, backend *storage.RedisClient, spec chain.ChainSpec) *BlockUnlocker {
+	cfg.Network = spec.Name()
 
-func NewBlockUnlocker(cfg *UnlockerConfig, backend *storage.RedisClient, network string) *BlockUnlocker {
-	// determine which monetary policy to use based on network
-	// configure any reward params if needed.
-	// 根据EIP方案，对齐分叉高度
-	switch network {
-	case EtcNetwork:
-		coinhash.CoinConf.Ecip1017FBlock = 5000000
-		coinhash.CoinConf.Ecip1017EraRounds = big.NewInt(5000000)
-	case MordorNetwork:
-		coinhash.CoinConf.Ecip1017FBlock = 0
-		coinhash.CoinConf.Ecip1017EraRounds = big.NewInt(2000000)
-	case EthNetwork:
-		coinhash.CoinConf.ByzantiumHardForkHeight = big.NewInt(4370000)
-		coinhash.CoinConf.ConstantinopleHardForkHeight = big.NewInt(7280000)
-		coinhash.CoinConf.LondonHardForkHeight = big.NewInt(12965000)
-	case RopstenNetwork:
-		coinhash.CoinConf.ByzantiumHardForkHeight = big.NewInt(1700000)
-		coinhash.CoinConf.ConstantinopleHardForkHeight = big.NewInt(4230000)
-		coinhash.CoinConf.LondonHardForkHeight = big.NewInt(10499401)
-	case UbiqNetwork:
-		// nothing needs configuring here, simply proceed.
-		break
+	switch cfg.RewardScheme {
+	case "":
+		cfg.RewardScheme = SchemePROP
+	case SchemePROP, SchemePPLNS, SchemePPS, SchemeFPPS, SchemeScore:
 	default:
-		logger.Fatal("Invalid network set %s", network)
+		logger.Fatal("Invalid reward scheme %s", cfg.RewardScheme)
 	}
-
-	cfg.Network = network
+	if cfg.PPLNSWindow <= 0 {
+		cfg.PPLNSWindow = defaultPPLNSWindow
+	}
+	if cfg.PPSFeeWindow <= 0 {
+		cfg.PPSFeeWindow = defaultPPSFeeWindow
+	}
+	if cfg.RewardScheme == SchemeScore && ScoreDecay(cfg) <= 0 {
+		logger.Fatal("Score decay must be positive, your decay is %s", cfg.ScoreDecay)
+	}
+	logger.Info("Using %s reward scheme", cfg.RewardScheme)
 
 	if len(cfg.PoolFeeAddress) != 0 && !util.IsValidHexAddress(cfg.PoolFeeAddress) {
 		logger.Fatal("Invalid poolFeeAddress: %s", cfg.PoolFeeAddress)
@@ -87,8 +120,12 @@ func NewBlockUnlocker(cfg *UnlockerConfig, backend *storage.RedisClient, network
 	if cfg.ImmatureDepth < minDepth {
 		logger.Fatal("Immature depth can't be < %v, your depth is %v", minDepth, cfg.ImmatureDepth)
 	}
-	u := &BlockUnlocker{config: cfg, backend: backend}
-	u.rpc = rpc.NewRPCClient("BlockUnlocker", cfg.Daemon, cfg.Timeout)
+	u := &BlockUnlocker{config: cfg, backend: backend, chain: spec}
+	var err error
+	u.rpc, err = rpc.NewClient("BlockUnlocker", cfg.Daemon, cfg.Timeout, cfg.DaemonAuth, cfg.DaemonCallPolicy)
+	if err != nil {
+		logger.Fatal("Failed to setup unlocker daemon connection: %v", err)
+	}
 	return u
 }
 
@@ -99,8 +136,8 @@ func (u *BlockUnlocker) Start() {
 	logger.Info("Set block unlock interval to %v", intv)
 
 	// Immediately unlock after start
-	u.unlockPendingBlocks()
-	u.unlockAndCreditMiners()
+	u.unlockPendingBlocks(common.RoutineCtx)
+	u.unlockAndCreditMiners(common.RoutineCtx)
 	timer.Reset(intv)
 
 	common.RoutineGroup.GoRecover(func() error {
@@ -110,8 +147,8 @@ func (u *BlockUnlocker) Start() {
 				logger.Info("Stopping unlocker working module")
 				return nil
 			case <-timer.C:
-				u.unlockPendingBlocks()
-				u.unlockAndCreditMiners()
+				u.unlockPendingBlocks(common.RoutineCtx)
+				u.unlockAndCreditMiners(common.RoutineCtx)
 				timer.Reset(intv)
 			}
 		}
@@ -133,9 +170,16 @@ type UnlockResult struct {
  * to make sure we will find it. We can't rely on round height here, it's just a reference point.
  * ISSUE: https://github.com/ethereum/go-ethereum/issues/2333
  */
-func (u *BlockUnlocker) unlockCandidates(candidates []*storage.BlockData) (*UnlockResult, error) {
+func (u *BlockUnlocker) unlockCandidates(ctx context.Context, candidates []*storage.BlockData) (*UnlockResult, error) {
 	result := &UnlockResult{}
 
+	scan := newChainScan(u.rpc)
+	defer scan.finish(len(candidates))
+	if err := scan.prefetch(ctx, candidates); err != nil {
+		logger.Error("Error while retrieving blocks from node: %v", err)
+		return nil, err
+	}
+
 	// Data row is: "height:nonce:powHash:mixDigest:timestamp:diff:totalShares"
 	for _, candidate := range candidates {
 		orphan := true
@@ -148,28 +192,14 @@ func (u *BlockUnlocker) unlockCandidates(candidates []*storage.BlockData) (*Unlo
 			// avoid scanning the first 16 blocks
 			continue
 		}
-		for i := int64(minDepth * -1); i < minDepth; i++ {
-			height := candidate.Height + i
-
-			if height < 0 {
-				continue
-			}
-
-			block, err := u.rpc.GetBlockByHeight(height)
-
-			if err != nil {
-				logger.Error("Error while retrieving block %v from node: %v", height, err)
-				return nil, err
-			}
-			if block == nil {
-				return nil, fmt.Errorf("Error while retrieving block %v from node, wrong node height. ", height)
-			}
+		for _, height := range scanWindow(candidate) {
+			block := scan.block(height)
 
 			if matchCandidate(block, candidate) {
 				orphan = false
 				result.blocks++
 
-				err = u.handleBlock(block, candidate)
+				err := u.handleBlock(ctx, block, candidate)
 				if err != nil {
 					u.halt = true
 					u.lastFail = err
@@ -180,26 +210,14 @@ func (u *BlockUnlocker) unlockCandidates(candidates []*storage.BlockData) (*Unlo
 				break
 			}
 
-			if len(block.Uncles) == 0 {
-				continue
-			}
-
 			// Trying to find uncle in current block during our forward check
-			for uncleIndex, uncleHash := range block.Uncles {
-				uncle, err := u.rpc.GetUncleByBlockNumberAndIndex(height, uncleIndex)
-				if err != nil {
-					return nil, fmt.Errorf("Error while retrieving uncle of block %v from node: %v ", uncleHash, err)
-				}
-				if uncle == nil {
-					return nil, fmt.Errorf("Error while retrieving uncle of block %v from node. ", height)
-				}
-
+			for _, uncle := range scan.blockUncles(height) {
 				// Found uncle
 				if matchCandidate(uncle, candidate) {
 					orphan = false
 					result.uncles++
 
-					err := handleUncle(height, uncle, candidate, u.config)
+					err := handleUncle(height, uncle, candidate, u.chain)
 					if err != nil {
 						u.halt = true
 						u.lastFail = err
@@ -244,39 +262,21 @@ func matchCandidate(block *rpc.GetBlockReply, candidate *storage.BlockData) bool
 }
 
 // 处理区块数据
-func (u *BlockUnlocker) handleBlock(block *rpc.GetBlockReply, candidate *storage.BlockData) error {
+func (u *BlockUnlocker) handleBlock(ctx context.Context, block *rpc.GetBlockReply, candidate *storage.BlockData) error {
 	correctHeight, err := strconv.ParseInt(strings.Replace(block.Number, "0x", "", -1), 16, 64)
 	if err != nil {
 		return err
 	}
 	candidate.Height = correctHeight
-	var reward = big.NewInt(0)
-	if u.config.Network == EtcNetwork || u.config.Network == MordorNetwork {
-		era := GetBlockEra(big.NewInt(candidate.Height), coinhash.CoinConf.Ecip1017EraRounds)
-		reward = coinhash.GetConstReward(era)
-		// Add reward for including uncles
-		uncleReward := coinhash.GetRewardForUncle(reward)
-		rewardForUncles := big.NewInt(0).Mul(uncleReward, big.NewInt(int64(len(block.Uncles))))
-		reward.Add(reward, rewardForUncles)
-
-	} else if u.config.Network == UbiqNetwork {
-		reward = coinhash.GetConstRewardUbiq(candidate.Height)
-		// Add reward for including uncles
-		uncleReward := new(big.Int).Div(reward, coinhash.Big32)
-		rewardForUncles := big.NewInt(0).Mul(uncleReward, big.NewInt(int64(len(block.Uncles))))
-		reward.Add(reward, rewardForUncles)
-
-	} else if u.config.Network == EthNetwork || u.config.Network == RopstenNetwork {
-		reward = coinhash.GetConstRewardEthereum(candidate.Height, block)
-		// Add reward for including uncles
-		uncleReward := new(big.Int).Div(reward, coinhash.Big32)
-		rewardForUncles := big.NewInt(0).Mul(uncleReward, big.NewInt(int64(len(block.Uncles))))
-		reward.Add(reward, rewardForUncles)
-	}
+	// 静态区块奖励 + 打包叔块奖励 - 燃烧费用
+	reward := u.chain.BlockReward(candidate.Height)
+	rewardForUncles := new(big.Int).Mul(u.chain.UncleInclusionReward(candidate.Height), big.NewInt(int64(len(block.Uncles))))
+	reward.Add(reward, rewardForUncles)
+	reward.Sub(reward, u.chain.BurntFees(candidate.Height, block))
 
 	// Add TX fees
 	// 添加打包交易的手续费到reward
-	extraTxReward, err := u.getExtraRewardForTx(block)
+	extraTxReward, err := u.getExtraRewardForTx(ctx, block)
 	if err != nil {
 		return fmt.Errorf("Error while fetching TX receipt: %v ", err)
 	}
@@ -285,6 +285,8 @@ func (u *BlockUnlocker) handleBlock(block *rpc.GetBlockReply, candidate *storage
 	} else {
 		reward.Add(reward, extraTxReward)
 	}
+	// FPPS 使用扣除燃烧费用后的交易收益
+	candidate.TxFees = new(big.Int).Sub(extraTxReward, u.chain.BurntFees(candidate.Height, block))
 
 	candidate.Orphan = false
 	candidate.Hash = block.Hash
@@ -293,26 +295,12 @@ func (u *BlockUnlocker) handleBlock(block *rpc.GetBlockReply, candidate *storage
 	return nil
 }
 
-func handleUncle(height int64, uncle *rpc.GetBlockReply, candidate *storage.BlockData, cfg *UnlockerConfig) error {
+func handleUncle(height int64, uncle *rpc.GetBlockReply, candidate *storage.BlockData, spec chain.ChainSpec) error {
 	uncleHeight, err := strconv.ParseInt(strings.Replace(uncle.Number, "0x", "", -1), 16, 64)
 	if err != nil {
 		return err
 	}
-	var reward = big.NewInt(0)
-	if cfg.Network == EtcNetwork || cfg.Network == MordorNetwork {
-		era := GetBlockEra(big.NewInt(height), coinhash.CoinConf.Ecip1017EraRounds)
-		reward = coinhash.GetUncleReward(
-			new(big.Int).SetInt64(uncleHeight), new(big.Int).SetInt64(height), era, coinhash.GetConstReward(era))
-	} else if cfg.Network == UbiqNetwork {
-		reward = coinhash.GetUncleRewardUbiq(
-			new(big.Int).SetInt64(uncleHeight), new(big.Int).SetInt64(height), coinhash.GetConstRewardUbiq(height))
-	} else if cfg.Network == EthNetwork || cfg.Network == RopstenNetwork {
-		reward = coinhash.GetUncleRewardEthereum(
-			new(big.Int).SetInt64(uncleHeight),
-			new(big.Int).SetInt64(height),
-			coinhash.GetStaticBlockRewardForETH(new(big.Int).SetInt64(height)),
-		)
-	}
+	reward := spec.UncleReward(uncleHeight, height)
 	candidate.Height = height
 	candidate.UncleHeight = uncleHeight
 	candidate.Orphan = false
@@ -322,13 +310,13 @@ func handleUncle(height int64, uncle *rpc.GetBlockReply, candidate *storage.Bloc
 }
 
 // 解锁待办（未成熟）区块
-func (u *BlockUnlocker) unlockPendingBlocks() {
+func (u *BlockUnlocker) unlockPendingBlocks(ctx context.Context) {
 	if u.halt {
 		logger.Error("Unlocking suspended due to last critical error: %v", u.lastFail)
 		return
 	}
 
-	current, err := u.rpc.GetLatestBlock()
+	current, err := u.rpc.GetLatestBlock(ctx)
 	if err != nil {
 		u.halt = true
 		u.lastFail = err
@@ -356,7 +344,7 @@ func (u *BlockUnlocker) unlockPendingBlocks() {
 		return
 	}
 
-	result, err := u.unlockCandidates(candidates)
+	result, err := u.unlockCandidates(ctx, candidates)
 	if err != nil {
 		u.halt = true
 		u.lastFail = err
@@ -376,12 +364,20 @@ func (u *BlockUnlocker) unlockPendingBlocks() {
 	}
 	logger.Debug("There are no orphan blocks, insert %d orphan blocks into the backend", result.orphans)
 
+	fees, err := u.backend.GetMinerFees()
+	if err != nil {
+		u.halt = true
+		u.lastFail = err
+		logger.Error("Failed to get miner fees from backend: %v", err)
+		return
+	}
+
 	totalRevenue := new(big.Rat)
 	totalMinersProfit := new(big.Rat)
 	totalPoolProfit := new(big.Rat)
 
 	for _, block := range result.maturedBlocks {
-		revenue, minersProfit, poolProfit, roundRewards, err := u.calculateRewards(block)
+		revenue, minersProfit, poolProfit, roundRewards, _, err := u.calculateRewards(block, fees)
 		if err != nil {
 			u.halt = true
 			u.lastFail = err
@@ -422,13 +418,13 @@ func (u *BlockUnlocker) unlockPendingBlocks() {
 }
 
 // 解锁成熟的块
-func (u *BlockUnlocker) unlockAndCreditMiners() {
+func (u *BlockUnlocker) unlockAndCreditMiners(ctx context.Context) {
 	if u.halt {
 		logger.Error("Unlocking suspended due to last critical error: %v", u.lastFail)
 		return
 	}
 
-	current, err := u.rpc.GetLatestBlock()
+	current, err := u.rpc.GetLatestBlock(ctx)
 	if err != nil {
 		u.halt = true
 		u.lastFail = err
@@ -456,7 +452,7 @@ func (u *BlockUnlocker) unlockAndCreditMiners() {
 		return
 	}
 
-	result, err := u.unlockCandidates(immature)
+	result, err := u.unlockCandidates(ctx, immature)
 	if err != nil {
 		u.halt = true
 		u.lastFail = err
@@ -476,25 +472,45 @@ func (u *BlockUnlocker) unlockAndCreditMiners() {
 	}
 	logger.Info("Inserted %v orphaned blocks to backend", result.orphans)
 
+	fees, err := u.backend.GetMinerFees()
+	if err != nil {
+		u.halt = true
+		u.lastFail = err
+		logger.Error("Failed to get miner fees from backend: %v", err)
+		return
+	}
+
 	totalRevenue := new(big.Rat)
 	totalMinersProfit := new(big.Rat)
 	totalPoolProfit := new(big.Rat)
 
 	for _, block := range result.maturedBlocks {
-		revenue, minersProfit, poolProfit, roundRewards, err := u.calculateRewards(block)
+		revenue, minersProfit, poolProfit, roundRewards, roundFees, err := u.calculateRewards(block, fees)
 		if err != nil {
 			u.halt = true
 			u.lastFail = err
 			logger.Error("Failed to calculate rewards for round %v: %v", block.RoundKey(), err)
 			return
 		}
-		err = u.backend.WriteMaturedBlock(block, roundRewards)
+		err = u.backend.WriteMaturedBlock(block, roundRewards, roundFees)
 		if err != nil {
 			u.halt = true
 			u.lastFail = err
 			logger.Error("Failed to credit rewards for round %v: %v", block.RoundKey(), err)
 			return
 		}
+		if u.config.RewardScheme == SchemePPLNS && !block.Solo {
+			u.trimShareLog(block)
+		}
+		if IsPPS(u.config.RewardScheme) && !block.Solo {
+			err = u.backend.WritePPSReserve(block, weiToShannonInt64(revenue), u.config.PPSFeeWindow)
+			if err != nil {
+				u.halt = true
+				u.lastFail = err
+				logger.Error("Failed to credit risk reserve for round %v: %v", block.RoundKey(), err)
+				return
+			}
+		}
 		totalRevenue.Add(totalRevenue, revenue)
 		totalMinersProfit.Add(totalMinersProfit, minersProfit)
 		totalPoolProfit.Add(totalPoolProfit, poolProfit)
@@ -521,18 +537,48 @@ func (u *BlockUnlocker) unlockAndCreditMiners() {
 	)
 }
 
-// 收益计算
-func (u *BlockUnlocker) calculateRewards(block *storage.BlockData) (*big.Rat, *big.Rat, *big.Rat, map[string]int64, error) {
+// 收益计算，fees 为矿工单独设置的手续费，返回的 roundFees 为每个矿工实际被收取的手续费金额（Shannon）
+func (u *BlockUnlocker) calculateRewards(block *storage.BlockData, fees map[string]float64) (*big.Rat, *big.Rat, *big.Rat, map[string]int64, map[string]int64, error) {
 	revenue := new(big.Rat).SetInt(block.Reward)
-	minersProfit, poolProfit := chargeFee(revenue, u.config.PoolFee)
+	if block.Solo {
+		return u.calculateSoloRewards(block, revenue, fees)
+	}
+	if IsPPS(u.config.RewardScheme) {
+		// 矿工已按份额获得收益，区块收益全部归入风险准备金
+		if block.ExtraReward != nil {
+			revenue.Add(revenue, new(big.Rat).SetInt(block.ExtraReward))
+		}
+		return revenue, new(big.Rat), new(big.Rat).Set(revenue), make(map[string]int64), make(map[string]int64), nil
+	}
 
-	shares, err := u.backend.GetRoundShares(block.RoundHeight, block.Nonce)
+	weights, err := u.roundWeights(block)
 	if err != nil {
-		return nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, err
 	}
+	minersProfit, rewards, roundFees := chargeMinerFees(revenue, weights, fees, u.config.PoolFee)
+	// 各矿工按自己的费率扣费，矿池收益为余下部分，保证账目平衡
+	poolProfit := new(big.Rat).Sub(revenue, minersProfit)
 
-	// PPLNS 奖励计算
-	rewards := calculateRewardsForShares(shares, block.TotalShares, minersProfit)
+	if block.ExtraReward != nil {
+		extraReward := new(big.Rat).SetInt(block.ExtraReward)
+		poolProfit.Add(poolProfit, extraReward)
+		revenue.Add(revenue, extraReward)
+	}
+
+	if len(u.config.PoolFeeAddress) != 0 {
+		address := strings.ToLower(u.config.PoolFeeAddress)
+		rewards[address] += weiToShannonInt64(poolProfit)
+	}
+
+	return revenue, minersProfit, poolProfit, rewards, roundFees, nil
+}
+
+// SOLO 区块扣除 soloFee（或矿工单独设置的手续费）后全部归发现者
+func (u *BlockUnlocker) calculateSoloRewards(block *storage.BlockData, revenue *big.Rat, fees map[string]float64) (*big.Rat, *big.Rat, *big.Rat, map[string]int64, map[string]int64, error) {
+	fee := minerFee(fees, block.SoloLogin, u.config.SoloFee)
+	minersProfit, poolProfit := chargeFee(revenue, fee)
+	rewards := map[string]int64{block.SoloLogin: weiToShannonInt64(minersProfit)}
+	roundFees := map[string]int64{block.SoloLogin: weiToShannonInt64(poolProfit)}
 
 	if block.ExtraReward != nil {
 		extraReward := new(big.Rat).SetInt(block.ExtraReward)
@@ -544,24 +590,175 @@ func (u *BlockUnlocker) calculateRewards(block *storage.BlockData) (*big.Rat, *b
 		address := strings.ToLower(u.config.PoolFeeAddress)
 		rewards[address] += weiToShannonInt64(poolProfit)
 	}
+	return revenue, minersProfit, poolProfit, rewards, roundFees, nil
+}
 
-	return revenue, minersProfit, poolProfit, rewards, nil
+// 矿工的手续费百分比，未单独设置时使用默认值
+func minerFee(fees map[string]float64, login string, fee float64) float64 {
+	if value, ok := fees[login]; ok {
+		return value
+	}
+	return fee
 }
 
-// PPLNS 根据每个钱包地址shares(key)的共享哈希shares(value)，按百分比分配收益
-func calculateRewardsForShares(shares map[string]int64, total int64, reward *big.Rat) map[string]int64 {
-	rewards := make(map[string]int64)
+// 每个矿工在轮次收益中的占比，score 模式下没有分数的轮次按份额计算
+func (u *BlockUnlocker) roundWeights(block *storage.BlockData) (map[string]*big.Rat, error) {
+	if u.config.RewardScheme == SchemeScore {
+		scores, err := u.backend.GetRoundScores(block.Nonce)
+		if err != nil {
+			return nil, err
+		}
+		if len(scores) > 0 {
+			return scoreWeights(scores), nil
+		}
+	}
+	shares, totalShares, err := u.roundShares(block)
+	if err != nil {
+		return nil, err
+	}
+	return shareWeights(shares, totalShares), nil
+}
+
+// 参与分配的份额，PROP 为出块轮次内的份额，PPLNS 为出块前最近 N 份额
+func (u *BlockUnlocker) roundShares(block *storage.BlockData) (map[string]int64, int64, error) {
+	if u.config.RewardScheme != SchemePPLNS {
+		shares, err := u.backend.GetRoundShares(block.RoundHeight, block.Nonce)
+		return shares, block.TotalShares, err
+	}
+	window := u.pplnsWindow(block)
+	entries, _, err := u.backend.GetShareWindow(block.Nonce, window)
+	if err != nil {
+		return nil, 0, err
+	}
+	shares, total := pplnsShares(entries, window)
+	return shares, total, nil
+}
+
+func (u *BlockUnlocker) pplnsWindow(block *storage.BlockData) int64 {
+	return int64(u.config.PPLNSWindow * float64(block.Difficulty))
+}
 
+// 汇总最近 N 份额（从新到旧），最早一份只计入窗口内的部分
+func pplnsShares(entries []storage.ShareLogEntry, window int64) (map[string]int64, int64) {
+	shares := make(map[string]int64)
+	total := int64(0)
+	for _, entry := range entries {
+		if total >= window {
+			break
+		}
+		diff := entry.Diff
+		if total+diff > window {
+			diff = window - total
+		}
+		shares[entry.Login] += diff
+		total += diff
+	}
+	return shares, total
+}
+
+// 区块入账后清理窗口之前的份额，后续区块的窗口不会早于当前区块
+func (u *BlockUnlocker) trimShareLog(block *storage.BlockData) {
+	first, err := u.shareLogStart(block)
+	if err != nil {
+		// 无法确定时只清除区块位置，不裁剪份额日志
+		logger.Warn("Share log is not trimmed for round %v: %v", block.RoundKey(), err)
+		first = 0
+	}
+	if err = u.backend.TrimShareLog(block.Nonce, first); err != nil {
+		logger.Warn("Failed to trim share log for round %v: %v", block.RoundKey(), err)
+	}
+}
+
+// 份额日志只能裁剪到所有待结算区块中最早的窗口起点，难度更高的区块窗口可能更靠前
+func (u *BlockUnlocker) shareLogStart(block *storage.BlockData) (int64, error) {
+	_, first, err := u.backend.GetShareWindow(block.Nonce, u.pplnsWindow(block))
+	if err != nil {
+		return 0, err
+	}
+	candidates, err := u.backend.GetCandidates(math.MaxInt64)
+	if err != nil {
+		return 0, err
+	}
+	immature, err := u.backend.GetImmatureBlocks(math.MaxInt64)
+	if err != nil {
+		return 0, err
+	}
+	for _, pending := range append(candidates, immature...) {
+		if pending.Solo || pending.Nonce == block.Nonce {
+			continue
+		}
+		_, start, err := u.backend.GetShareWindow(pending.Nonce, u.pplnsWindow(pending))
+		if err != nil {
+			return 0, err
+		}
+		if start < first {
+			first = start
+		}
+	}
+	return first, nil
+}
+
+// PPS 份额收益 (blockReward + fees) * shareDiff / networkDiff，扣除矿池手续费，单位 Shannon
+func PPSCredit(blockReward, fees *big.Int, shareDiff int64, networkDiff *big.Int, fee float64) int64 {
+	if networkDiff == nil || networkDiff.Sign() <= 0 {
+		return 0
+	}
+	reward := new(big.Int).Set(blockReward)
+	if fees != nil && fees.Sign() > 0 {
+		reward.Add(reward, fees)
+	}
+	value := new(big.Rat).SetFrac(new(big.Int).Mul(reward, big.NewInt(shareDiff)), networkDiff)
+	value, _ = chargeFee(value, fee)
+	return weiToShannonInt64(value)
+}
+
+// 根据每个钱包地址shares(key)的共享哈希shares(value)，计算收益占比
+func shareWeights(shares map[string]int64, total int64) map[string]*big.Rat {
+	weights := make(map[string]*big.Rat)
+	if total <= 0 {
+		return weights
+	}
 	for login, n := range shares {
-		percent := big.NewRat(n, total)
-		workerReward := new(big.Rat).Mul(reward, percent)
+		weights[login] = big.NewRat(n, total)
+	}
+	return weights
+}
+
+// 根据每个钱包地址的份额分数计算收益占比
+func scoreWeights(scores map[string]float64) map[string]*big.Rat {
+	weights := make(map[string]*big.Rat, len(scores))
+	total := new(big.Rat)
+	for login, score := range scores {
+		if score <= 0 || math.IsInf(score, 0) || math.IsNaN(score) {
+			continue
+		}
+		weights[login] = new(big.Rat).SetFloat64(score)
+		total.Add(total, weights[login])
+	}
+	for _, weight := range weights {
+		weight.Quo(weight, total)
+	}
+	return weights
+}
+
+// 按占比分配收益，每个矿工按自己的费率扣除手续费，返回矿工收益合计、每个矿工的收益与被收取的手续费（Shannon）
+func chargeMinerFees(reward *big.Rat, weights map[string]*big.Rat, fees map[string]float64, fee float64) (*big.Rat, map[string]int64, map[string]int64) {
+	minersProfit := new(big.Rat)
+	rewards := make(map[string]int64)
+	roundFees := make(map[string]int64)
+
+	for login, weight := range weights {
+		workerReward, workerFee := chargeFee(new(big.Rat).Mul(reward, weight), minerFee(fees, login, fee))
+		minersProfit.Add(minersProfit, workerReward)
 		rewards[login] += weiToShannonInt64(workerReward)
+		roundFees[login] += weiToShannonInt64(workerFee)
 	}
-	return rewards
+	return minersProfit, rewards, roundFees
 }
 
 // Returns new value after fee deduction and fee value.
-//  扣除费用和费用值后返回新值
+//
+//	扣除费用和费用值后返回新值
 func chargeFee(value *big.Rat, fee float64) (*big.Rat, *big.Rat) {
 	feePercent := new(big.Rat).SetFloat64(fee / 100)
 	feeValue := new(big.Rat).Mul(value, feePercent)
@@ -579,28 +776,25 @@ func weiToShannonInt64(wei *big.Rat) int64 {
 // GetBlockEra gets which "Era" a given block is within, given an era length (ecip-1017 has era=5,000,000 blocks)
 // Returns a zero-index era number, so "Era 1": 0, "Era 2": 1, "Era 3": 2 ...
 func GetBlockEra(blockNum, eraLength *big.Int) *big.Int {
-	// If genesis block or impossible negative-numbered block, return zero-val.
-	if blockNum.Sign() < 1 {
-		return new(big.Int)
-	}
-
-	remainder := big.NewInt(0).Mod(big.NewInt(0).Sub(blockNum, big.NewInt(1)), eraLength)
-	base := big.NewInt(0).Sub(blockNum, remainder)
-
-	d := big.NewInt(0).Div(base, eraLength)
-	dremainder := big.NewInt(0).Mod(d, big.NewInt(1))
-
-	return new(big.Int).Sub(d, dremainder)
+	return coinhash.GetBlockEra(blockNum, eraLength)
 }
 
 // ethash, etchash, ubqhash
-func (u *BlockUnlocker) getExtraRewardForTx(block *rpc.GetBlockReply) (*big.Int, error) {
+func (u *BlockUnlocker) getExtraRewardForTx(ctx context.Context, block *rpc.GetBlockReply) (*big.Int, error) {
 	amount := new(big.Int)
 
-	for _, tx := range block.Transactions {
-		receipt, err := u.rpc.GetTxReceipt(tx.Hash)
-		if err != nil {
-			return nil, err
+	height, err := strconv.ParseInt(strings.Replace(block.Number, "0x", "", -1), 16, 64)
+	if err != nil {
+		return nil, err
+	}
+	receipts, err := u.getReceipts(ctx, height, block)
+	if err != nil {
+		return nil, err
+	}
+	for i, tx := range block.Transactions {
+		receipt := receipts[i]
+		if receipt != nil && len(receipt.TxHash) > 0 && !strings.EqualFold(receipt.TxHash, tx.Hash) {
+			return nil, fmt.Errorf("Receipt %v doesn't match tx %v of block %v", receipt.TxHash, tx.Hash, height)
 		}
 		if receipt != nil {
 			gasUsed := util.String2Big(receipt.GasUsed)
@@ -1,10 +1,16 @@
 package payouts
 
 import (
+	"context"
+	"fmt"
+	"math"
 	"math/big"
 	"os"
+	"reflect"
 	"testing"
+	"time"
 
+	"github.com/etclabscore/core-pool/chain"
 	"github.com/etclabscore/core-pool/payouts/coinhash"
 	"github.com/etclabscore/core-pool/rpc"
 	"github.com/etclabscore/core-pool/storage"
@@ -20,7 +26,7 @@ func TestCalculateRewards(t *testing.T) {
 	expectedRewards := map[string]int64{"0x0": 4877996431, "0x1": 97559929, "0x2": 24389982, "0x3": 48780, "0x4": 4878}
 	totalShares := int64(1025011)
 
-	rewards := calculateRewardsForShares(shares, totalShares, blockReward)
+	_, rewards, _ := chargeMinerFees(blockReward, shareWeights(shares, totalShares), nil, 0)
 	expectedTotalAmount := int64(5000000000)
 
 	totalAmount := int64(0)
@@ -36,6 +42,134 @@ func TestCalculateRewards(t *testing.T) {
 	}
 }
 
+func TestPPLNSShares(t *testing.T) {
+	// Newest first, window ends in the middle of "0x0" share
+	entries := []storage.ShareLogEntry{{Login: "0x1", Diff: 400}, {Login: "0x2", Diff: 100}, {Login: "0x1", Diff: 200}, {Login: "0x0", Diff: 600}, {Login: "0x3", Diff: 1000}}
+	shares, total := pplnsShares(entries, 1000)
+	expected := map[string]int64{"0x0": 300, "0x1": 600, "0x2": 100}
+	if total != 1000 {
+		t.Errorf("Total shares must be equal to window: %v", total)
+	}
+	if !reflect.DeepEqual(shares, expected) {
+		t.Errorf("Must count only last N shares: %v", shares)
+	}
+
+	// Not enough shares since start of the log
+	shares, total = pplnsShares(entries[:2], 1000)
+	if total != 500 || shares["0x1"] != 400 || shares["0x2"] != 100 {
+		t.Errorf("Must count all shares when log is shorter than window: %v of %v", shares, total)
+	}
+}
+
+func TestPROPAndPPLNSRewards(t *testing.T) {
+	blockReward, _ := new(big.Rat).SetString("5000000000000000000")
+	// Round shares since previous block, "0x1" joined late with high hashrate
+	round := map[string]int64{"0x0": 200, "0x1": 800}
+	// Share log carries shares of previous rounds too
+	entries := []storage.ShareLogEntry{{Login: "0x1", Diff: 800}, {Login: "0x0", Diff: 200}, {Login: "0x0", Diff: 1000}}
+
+	_, prop, _ := chargeMinerFees(blockReward, shareWeights(round, 1000), nil, 0)
+	if prop["0x0"] != 1000000000 || prop["0x1"] != 4000000000 {
+		t.Errorf("PROP must pay round shares: %v", prop)
+	}
+	shares, total := pplnsShares(entries, 2000)
+	_, pplns, _ := chargeMinerFees(blockReward, shareWeights(shares, total), nil, 0)
+	if pplns["0x0"] != 3000000000 || pplns["0x1"] != 2000000000 {
+		t.Errorf("PPLNS must pay last N shares: %v", pplns)
+	}
+}
+
+func TestScoreRewards(t *testing.T) {
+	blockReward, _ := new(big.Rat).SetString("5000000000000000000")
+	// Same shares, "0x1" submitted later in the round
+	scores := map[string]float64{"0x0": 1000, "0x1": 3000, "0x2": math.Inf(1)}
+	_, rewards, _ := chargeMinerFees(blockReward, scoreWeights(scores), nil, 0)
+	if len(rewards) != 2 || rewards["0x0"] != 1250000000 || rewards["0x1"] != 3750000000 {
+		t.Errorf("Must split rewards by score: %v", rewards)
+	}
+	if weights := scoreWeights(map[string]float64{"0x0": 0}); len(weights) != 0 {
+		t.Errorf("Must not pay without scores: %v", rewards)
+	}
+}
+
+func TestPPSCredit(t *testing.T) {
+	blockReward := big.NewInt(2000000000000000000)
+	networkDiff := big.NewInt(1000000000000000)
+
+	// 2 ETH * 4e9 / 1e15 = 8e12 Wei = 8000 Shannon, minus 1% fee
+	credit := PPSCredit(blockReward, nil, 4000000000, networkDiff, 1.0)
+	if credit != 7920 {
+		t.Error("Should return PPS credit 7920", "credit", credit)
+	}
+	// FPPS adds average tx fees of 0.5 ETH
+	fees := big.NewInt(500000000000000000)
+	credit = PPSCredit(blockReward, fees, 4000000000, networkDiff, 0)
+	if credit != 10000 {
+		t.Error("Should return FPPS credit 10000", "credit", credit)
+	}
+	if PPSCredit(blockReward, nil, 4000000000, big.NewInt(0), 1.0) != 0 {
+		t.Error("Must not credit shares without network difficulty")
+	}
+}
+
+func TestCalculateSoloRewards(t *testing.T) {
+	u := &BlockUnlocker{config: &UnlockerConfig{PoolFee: 1.0, SoloFee: 2.0, PoolFeeAddress: "0xFEE", RewardScheme: SchemePPLNS}}
+	reward, _ := new(big.Int).SetString("5000000000000000000", 10)
+	block := &storage.BlockData{Reward: reward, Solo: true, SoloLogin: "0x1", ExtraReward: big.NewInt(1000000000)}
+
+	revenue, minersProfit, poolProfit, rewards, fees, err := u.calculateRewards(block, map[string]float64{"0x2": 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fees["0x1"] != 100000000 {
+		t.Errorf("Charged solo fee must be recorded: %v", fees)
+	}
+	if len(rewards) != 2 || rewards["0x1"] != 4900000000 {
+		t.Errorf("Solo miner must get whole block minus solo fee: %v", rewards)
+	}
+	if rewards["0xfee"] != 100000001 {
+		t.Errorf("Pool must get solo fee and kept tx fees: %v", rewards)
+	}
+	if weiToShannonInt64(revenue) != 5000000001 || weiToShannonInt64(minersProfit) != 4900000000 || weiToShannonInt64(poolProfit) != 100000001 {
+		t.Errorf("Wrong solo revenue split: %v, %v, %v", revenue, minersProfit, poolProfit)
+	}
+}
+
+func TestCalculateSoloRewardsMinerFee(t *testing.T) {
+	u := &BlockUnlocker{config: &UnlockerConfig{PoolFee: 1.0, SoloFee: 2.0, PoolFeeAddress: "0xFEE", RewardScheme: SchemePPLNS}}
+	reward, _ := new(big.Int).SetString("5000000000000000000", 10)
+	block := &storage.BlockData{Reward: reward, Solo: true, SoloLogin: "0x1"}
+
+	_, _, _, rewards, fees, err := u.calculateRewards(block, map[string]float64{"0x1": 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fees["0x1"] != 25000000 || rewards["0x1"] != 4975000000 || rewards["0xfee"] != 25000000 {
+		t.Errorf("Miner fee must replace solo fee: %v, %v", rewards, fees)
+	}
+}
+
+func TestChargeMinerFees(t *testing.T) {
+	revenue, _ := new(big.Rat).SetString("5000000000000000000")
+	weights := shareWeights(map[string]int64{"0x0": 1, "0x1": 1, "0x2": 1, "0x3": 1, "0x4": 1}, 5)
+	// Partner discount, own rig, promotional tier, default fee for the rest
+	fees := map[string]float64{"0x0": 0.5, "0x1": 0, "0x2": 3.0}
+
+	minersProfit, rewards, roundFees := chargeMinerFees(revenue, weights, fees, 1.0)
+	expected := map[string]int64{"0x0": 995000000, "0x1": 1000000000, "0x2": 970000000, "0x3": 990000000, "0x4": 990000000}
+	if !reflect.DeepEqual(rewards, expected) {
+		t.Errorf("Each miner must be charged own fee: %v", rewards)
+	}
+	charged := map[string]int64{"0x0": 5000000, "0x1": 0, "0x2": 30000000, "0x3": 10000000, "0x4": 10000000}
+	if !reflect.DeepEqual(roundFees, charged) {
+		t.Errorf("Charged fee amounts must be returned: %v", roundFees)
+	}
+	poolProfit := new(big.Rat).Sub(revenue, minersProfit)
+	if weiToShannonInt64(minersProfit) != 4945000000 || weiToShannonInt64(poolProfit) != 55000000 {
+		t.Errorf("Books must balance: %v, %v", minersProfit, poolProfit)
+	}
+}
+
 func TestChargeFee(t *testing.T) {
 	orig, _ := new(big.Rat).SetString("5000000000000000000")
 	value, _ := new(big.Rat).SetString("5000000000000000000")
@@ -140,6 +274,65 @@ func TestGetRewardForUncle(t *testing.T) {
 	}
 }
 
+func TestEthPoWBlockReward(t *testing.T) {
+	spec, err := chain.Lookup(chain.EthPoW)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.ChainID() != 10001 {
+		t.Error("Should use chain id 10001", "chainId", spec.ChainID())
+	}
+	u := &BlockUnlocker{config: &UnlockerConfig{}, chain: spec}
+	tests := []struct {
+		number string
+		uncles int
+		reward string
+	}{
+		{"0x42ae4f", 0, "5000000000000000000"}, // 4369999
+		{"0x42ae50", 0, "3000000000000000000"}, // 4370000, byzantium
+		{"0x6f157f", 1, "3093750000000000000"}, // 7279999
+		{"0x6f1580", 1, "2062500000000000000"}, // 7280000, constantinople
+		{"0xc5d487", 0, "2000000000000000000"}, // 12964999
+		{"0xc5d488", 0, "1999999999999999000"}, // 12965000, london burns baseFee * gasUsed
+		{"0xed14f1", 0, "1999999999999999000"}, // 15537393
+		{"0xed14f2", 2, "2124999999999999000"}, // 15537394, ethpow fork keeps reward
+	}
+	for _, tt := range tests {
+		block := &rpc.GetBlockReply{Number: tt.number, BaseFeePerGas: "0x64", GasUsed: "0xa", Uncles: make([]string, tt.uncles)}
+		candidate := &storage.BlockData{}
+		if err := u.handleBlock(context.Background(), block, candidate); err != nil {
+			t.Fatal(err)
+		}
+		if candidate.Reward.String() != tt.reward {
+			t.Error("Wrong block reward", "height", candidate.Height, "reward", candidate.Reward, "expected", tt.reward)
+		}
+	}
+
+	ethereum, _ := chain.Lookup(chain.Ethereum)
+	if ethereum.BlockReward(15537394).Sign() != 0 {
+		t.Error("Ethereum must not reward blocks after the merge")
+	}
+}
+
+func TestEthPoWUncleReward(t *testing.T) {
+	spec, _ := chain.Lookup(chain.EthPoW)
+	uncle := &rpc.GetBlockReply{Number: "0xed14f1", Hash: "0x1"}
+	candidate := &storage.BlockData{}
+	if err := handleUncle(15537394, uncle, candidate, spec); err != nil {
+		t.Fatal(err)
+	}
+	if candidate.Reward.Cmp(big.NewInt(1750000000000000000)) != 0 {
+		t.Error("Should return uncleReward 1750000000000000000", "reward", candidate.Reward)
+	}
+	uncle.Number = "0xed14e9"
+	if err := handleUncle(15537394, uncle, candidate, spec); err != nil {
+		t.Fatal(err)
+	}
+	if candidate.Reward.Sign() != 0 {
+		t.Error("Should not reward uncle older than 8 blocks", "reward", candidate.Reward)
+	}
+}
+
 func TestMatchCandidate(t *testing.T) {
 	gethBlock := &rpc.GetBlockReply{Hash: "0x12345A", Nonce: "0x1A"}
 	parityBlock := &rpc.GetBlockReply{Hash: "0x12345A", SealFields: []string{"0x0A", "0x1A"}}
@@ -165,3 +358,46 @@ func TestMatchCandidate(t *testing.T) {
 		t.Error("Must match with hash")
 	}
 }
+
+// 两个待结算区块难度不同，成熟区块裁剪份额日志时不能破坏高难度区块的窗口
+func TestTrimShareLogPendingWindows(t *testing.T) {
+	backend := storage.NewRedisClient(&storage.Config{Endpoint: "127.0.0.1:6379"}, "test-unlocker")
+	if _, err := backend.Check(); err != nil {
+		t.Skip("redis is not available")
+	}
+	reset := func() {
+		for _, key := range backend.Client().Keys("test-unlocker:*").Val() {
+			backend.Client().Del(key)
+		}
+	}
+	reset()
+	defer reset()
+	backend.EnableShareLog()
+	u := &BlockUnlocker{config: &UnlockerConfig{PPLNSWindow: 2, RewardScheme: SchemePPLNS}, backend: backend}
+
+	nonce := 0
+	share := func() []string {
+		nonce++
+		return []string{fmt.Sprintf("0x%x", nonce), "0x0", "0x0"}
+	}
+	for i := 0; i < 10; i++ {
+		backend.WriteShare("0x1", "x", share(), 100, 1000, time.Minute, 0)
+	}
+	backend.WriteBlock("0x1", "x", share(), 100, 100, 1000, time.Minute, 0)
+	for i := 0; i < 4; i++ {
+		backend.WriteShare("0x2", "x", share(), 100, 1001, time.Minute, 0)
+	}
+	backend.WriteBlock("0x2", "x", share(), 100, 1000, 1001, time.Minute, 0)
+
+	candidates, err := backend.GetCandidates(math.MaxInt64)
+	if err != nil || len(candidates) != 2 {
+		t.Fatalf("Must write two candidates, got %v %v", candidates, err)
+	}
+	low, high := candidates[0], candidates[1]
+	u.trimShareLog(low)
+
+	entries, first, err := backend.GetShareWindow(high.Nonce, u.pplnsWindow(high))
+	if err != nil || first != 1 || len(entries) != 16 {
+		t.Errorf("Window of pending block must stay intact, got %d entries from %d, %v", len(entries), first, err)
+	}
+}
@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/etclabscore/core-pool/library/logger"
+
+	"github.com/gorilla/mux"
+)
+
+func (s *ProxyServer) listenAdmin() {
+	if len(s.config.Proxy.Admin.Token) == 0 {
+		logger.Fatal("You must set admin token")
+	}
+	r := mux.NewRouter()
+	r.HandleFunc("/admin/drain", s.adminAuth(s.AdminDrain)).Methods("POST")
+	r.HandleFunc("/admin/undrain", s.adminAuth(s.AdminUndrain)).Methods("POST")
+	r.HandleFunc("/admin/ports", s.adminAuth(s.AdminPorts)).Methods("GET")
+	r.HandleFunc("/admin/broadcast", s.adminAuth(s.AdminBroadcast)).Methods("GET")
+	r.HandleFunc("/admin/upstreams", s.adminAuth(s.AdminUpstreams)).Methods("GET")
+	r.HandleFunc("/admin/sessions", s.adminAuth(s.AdminSessions)).Methods("GET")
+	r.HandleFunc("/admin/sessions", s.adminAuth(s.AdminKickSessions)).Methods("DELETE")
+	r.HandleFunc("/admin/sessions/{id:[0-9]+}", s.adminAuth(s.AdminSession)).Methods("GET")
+	r.HandleFunc("/admin/sessions/{id:[0-9]+}", s.adminAuth(s.AdminKickSession)).Methods("DELETE")
+	r.HandleFunc("/admin/fees", s.adminAuth(s.AdminFees)).Methods("GET")
+	r.HandleFunc("/admin/fees/{login:0x[0-9a-fA-F]{40}}", s.adminAuth(s.AdminSetFee)).Methods("PUT")
+	r.HandleFunc("/admin/fees/{login:0x[0-9a-fA-F]{40}}", s.adminAuth(s.AdminDeleteFee)).Methods("DELETE")
+	logger.Info("Starting proxy admin on %v", s.config.Proxy.Admin.Listen)
+	err := http.ListenAndServe(s.config.Proxy.Admin.Listen, r)
+	if err != nil {
+		logger.Fatal("Failed to start proxy admin: %v", err)
+	}
+}
+
+// Admin requests must carry "Authorization: Bearer <token>"
+func (s *ProxyServer) adminAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.config.Proxy.Admin.Token)) != 1 {
+			logger.Warn("Unauthorized admin request from %s", r.RemoteAddr)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func writeAdminReply(w http.ResponseWriter, status int, reply interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(reply)
+	if err != nil {
+		logger.Error("Error serializing admin response: %v", err)
+	}
+}
+
+// Drain runs in background, query may override reconnect host and port from config
+func (s *ProxyServer) AdminDrain(w http.ResponseWriter, r *http.Request) {
+	host := s.config.Proxy.Stratum.ReconnectHost
+	port := s.config.Proxy.Stratum.ReconnectPort
+	if v := r.URL.Query().Get("host"); len(v) > 0 {
+		host = v
+	}
+	if v := r.URL.Query().Get("port"); len(v) > 0 {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			writeAdminReply(w, http.StatusBadRequest, map[string]string{"error": "invalid port"})
+			return
+		}
+		port = n
+	}
+	if s.isDraining() {
+		writeAdminReply(w, http.StatusConflict, map[string]string{"error": "already draining"})
+		return
+	}
+	go s.Drain(host, port)
+	writeAdminReply(w, http.StatusAccepted, map[string]interface{}{"draining": true, "host": host, "port": port})
+}
+
+// Undrain is allowed once drain finished, sessions closed by drain are not restored
+func (s *ProxyServer) AdminUndrain(w http.ResponseWriter, r *http.Request) {
+	if !s.Undrain() {
+		writeAdminReply(w, http.StatusConflict, map[string]string{"error": "not drained"})
+		return
+	}
+	writeAdminReply(w, http.StatusOK, map[string]interface{}{"draining": false})
+}
+
+// Stratum ports with current connection counts
+func (s *ProxyServer) AdminPorts(w http.ResponseWriter, r *http.Request) {
+	writeAdminReply(w, http.StatusOK, map[string]interface{}{"ports": s.portsStats()})
+}
+
+// Latency percentiles of the last completed job broadcast, nanoseconds
+func (s *ProxyServer) AdminBroadcast(w http.ResponseWriter, r *http.Request) {
+	var stats *BroadcastStats
+	if s.broadcaster != nil {
+		stats = s.broadcaster.lastStats()
+	}
+	writeAdminReply(w, http.StatusOK, map[string]interface{}{"broadcast": stats})
+}
+
+// Upstream states with reason of selection and block submission results
+func (s *ProxyServer) AdminUpstreams(w http.ResponseWriter, r *http.Request) {
+	states := s.upstreamStates()
+	submits := s.submits.snapshot()
+	upstreams := make([]map[string]interface{}, 0, len(states))
+	for _, u := range states {
+		upstreams = append(upstreams, map[string]interface{}{
+			"name":     u.Name,
+			"active":   u.Active,
+			"reason":   u.Reason,
+			"height":   u.Height,
+			"lag":      u.Lag,
+			"peers":    u.Peers,
+			"syncing":  u.Syncing,
+			"sick":     u.Sick,
+			"priority": u.Priority,
+			"weight":   u.Weight,
+			"submits":  submits[u.Name],
+		})
+	}
+	writeAdminReply(w, http.StatusOK, map[string]interface{}{"upstreams": upstreams})
+}
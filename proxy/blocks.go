@@ -19,6 +19,7 @@ const maxBacklog = 3
 type heightDiffPair struct {
 	diff   *big.Int
 	height uint64
+	seed   string
 }
 
 type BlockTemplate struct {
@@ -31,6 +32,8 @@ type BlockTemplate struct {
 	GetPendingBlockCache *rpc.GetBlockReplyPart
 	nonces               map[string]bool
 	headers              map[string]heightDiffPair
+	// Job ID => header hash, same backlog as headers
+	jobs map[string]string
 }
 
 type Block struct {
@@ -47,10 +50,22 @@ func (b Block) Nonce() uint64            { return b.nonce }
 func (b Block) MixDigest() common.Hash   { return b.mixDigest }
 func (b Block) NumberU64() uint64        { return b.number }
 
+// Number of recent heights which work is still accepted
+func (s *ProxyServer) backlog() uint64 {
+	if s.config.Proxy.MaxBacklog > 0 {
+		return uint64(s.config.Proxy.MaxBacklog)
+	}
+	return maxBacklog
+}
+
 func (s *ProxyServer) fetchBlockTemplate() {
+	// Poller and subscription may fetch at the same time
+	s.templateMu.Lock()
+	defer s.templateMu.Unlock()
+
 	r := s.rpc()
 	t := s.currentBlockTemplate()
-	reply, err := r.GetWork()
+	reply, err := r.GetWork(lCommon.RoutineCtx)
 	if err != nil {
 		logger.Error("Error while refreshing block template on %s: %v", r.Name, err)
 		return
@@ -75,16 +90,21 @@ func (s *ProxyServer) fetchBlockTemplate() {
 		Difficulty:           diff,
 		GetPendingBlockCache: pendingReply,
 		headers:              make(map[string]heightDiffPair),
+		jobs:                 make(map[string]string),
 	}
 	// Copy job backlog and add current one
 	newTemplate.headers[reply[0]] = heightDiffPair{
 		diff:   diff,
 		height: height,
+		seed:   reply[1],
 	}
+	newTemplate.jobs[jobID(reply[0])] = reply[0]
 	if t != nil {
+		backlog := s.backlog()
 		for k, v := range t.headers {
-			if v.height > height-maxBacklog {
+			if v.height+backlog > height {
 				newTemplate.headers[k] = v
+				newTemplate.jobs[jobID(k)] = k
 			}
 		}
 	}
@@ -0,0 +1,281 @@
+package proxy
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/etclabscore/core-pool/common"
+	"github.com/etclabscore/core-pool/library/logger"
+	"github.com/etclabscore/core-pool/util"
+)
+
+const (
+	defaultBroadcastWriteTimeout = 5 * time.Second
+	defaultBroadcastMaxSkipped   = 3
+)
+
+// outbound is one slot queue of a session, a job not yet written is replaced by newer one
+type outbound struct {
+	sync.Mutex
+	job     *BlockTemplate
+	round   *broadcastRound
+	queued  bool
+	skipped int
+}
+
+// broadcastRound collects delivery latencies of one job broadcast
+type broadcastRound struct {
+	sync.Mutex
+	start     time.Time
+	height    uint64
+	sessions  int
+	pending   int64
+	latencies []time.Duration
+	skipped   int
+	evicted   int
+	failed    int
+}
+
+type BroadcastStats struct {
+	Height    uint64 `json:"height"`
+	Sessions  int    `json:"sessions"`
+	Delivered int    `json:"delivered"`
+	Skipped   int    `json:"skipped"`
+	Evicted   int    `json:"evicted"`
+	Failed    int    `json:"failed"`
+	P50       int64  `json:"p50"`
+	P90       int64  `json:"p90"`
+	P99       int64  `json:"p99"`
+	Max       int64  `json:"max"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// broadcaster delivers jobs with fixed pool of workers, so slow sockets hold
+// a worker no longer than write timeout and never block the fan-out itself
+type broadcaster struct {
+	s            *ProxyServer
+	workers      int
+	writeTimeout time.Duration
+	maxSkipped   int
+	ready        chan *Session
+	last         atomic.Value
+}
+
+func newBroadcaster(s *ProxyServer, cfg *Broadcast) *broadcaster {
+	b := &broadcaster{
+		s:            s,
+		workers:      cfg.Workers,
+		writeTimeout: defaultBroadcastWriteTimeout,
+		maxSkipped:   cfg.MaxSkipped,
+	}
+	if b.workers <= 0 {
+		b.workers = runtime.NumCPU() * 8
+	}
+	if len(cfg.WriteTimeout) > 0 {
+		b.writeTimeout = util.MustParseDuration(cfg.WriteTimeout)
+	}
+	if b.maxSkipped <= 0 {
+		b.maxSkipped = defaultBroadcastMaxSkipped
+	}
+	// Session is queued at most once, so queue holding every connection never fills up
+	queueSize := cfg.QueueSize
+	if queueSize <= 0 {
+		queueSize = 65536
+	}
+	maxConn := 0
+	for _, port := range s.ports {
+		maxConn += port.config.MaxConn
+	}
+	if queueSize < maxConn {
+		queueSize = maxConn
+	}
+	b.ready = make(chan *Session, queueSize)
+	return b
+}
+
+func (b *broadcaster) start() {
+	logger.Info("Starting %d job broadcast workers, write timeout %v", b.workers, b.writeTimeout)
+	for i := 0; i < b.workers; i++ {
+		common.RoutineGroup.GoRecover(func() error {
+			for {
+				select {
+				case <-common.RoutineCtx.Done():
+					return nil
+				case cs := <-b.ready:
+					b.deliver(cs)
+				}
+			}
+		})
+	}
+}
+
+// broadcast queues job to every session, sessions lock is held only to take a snapshot
+func (b *broadcaster) broadcast(t *BlockTemplate, sessions []*Session) {
+	if len(sessions) == 0 {
+		return
+	}
+	round := &broadcastRound{
+		start:     time.Now(),
+		height:    t.Height,
+		sessions:  len(sessions),
+		pending:   int64(len(sessions)),
+		latencies: make([]time.Duration, 0, len(sessions)),
+	}
+	for _, cs := range sessions {
+		b.enqueue(cs, t, round)
+	}
+}
+
+func (b *broadcaster) enqueue(cs *Session, t *BlockTemplate, round *broadcastRound) {
+	q := &cs.out
+	q.Lock()
+	if q.queued {
+		// Coalesce: previous job was not written yet, it's superseded
+		prev := q.round
+		q.job, q.round = t, round
+		q.skipped++
+		overflow := q.skipped > b.maxSkipped
+		q.Unlock()
+
+		b.done(prev, 0, resultSkipped)
+		if overflow {
+			b.evict(cs)
+		}
+		return
+	}
+	q.job, q.round, q.queued = t, round, true
+	q.Unlock()
+
+	select {
+	case b.ready <- cs:
+	default:
+		// Queue is still full of evicted sessions, never stall the fan-out
+		q.Lock()
+		q.job, q.round, q.queued = nil, nil, false
+		q.Unlock()
+		logger.Warn("Job broadcast queue is full, skipping %s@%s", cs.identity(), cs.ip)
+		b.done(round, 0, resultSkipped)
+	}
+}
+
+func (b *broadcaster) deliver(cs *Session) {
+	q := &cs.out
+	q.Lock()
+	t, round := q.job, q.round
+	q.job, q.round, q.queued = nil, nil, false
+	q.Unlock()
+	if t == nil {
+		return
+	}
+
+	cs.conn.SetWriteDeadline(time.Now().Add(b.writeTimeout))
+	var err error
+	if vd := cs.varDiff(); vd != nil {
+		if diff, ok := vd.onIdle(time.Now(), cs.difficulty()); ok {
+			cs.setDifficulty(diff)
+			err = cs.sendDifficulty()
+		}
+	}
+	if err == nil {
+		err = cs.pushNewJob(t)
+	}
+	if err != nil {
+		logger.Error("Job transmit error to %s@%s: %v", cs.identity(), cs.ip, err)
+		b.s.removeSession(cs)
+		cs.conn.Close()
+		b.done(round, 0, resultFailed)
+		return
+	}
+	b.s.setDeadline(cs.conn)
+
+	q.Lock()
+	q.skipped = 0
+	q.Unlock()
+	b.done(round, time.Since(round.start), resultDelivered)
+}
+
+// Session that keeps missing jobs can't keep up, disconnect it.
+// It stays in ready queue, worker finds no job and just drops it.
+func (b *broadcaster) evict(cs *Session) {
+	q := &cs.out
+	q.Lock()
+	round := q.round
+	q.job, q.round = nil, nil
+	q.Unlock()
+
+	logger.Warn("Evicting slow stratum client %s@%s, %d jobs not delivered", cs.identity(), cs.ip, b.maxSkipped+1)
+	b.s.removeSession(cs)
+	cs.conn.Close()
+	b.done(round, 0, resultEvicted)
+}
+
+const (
+	resultDelivered = iota
+	resultSkipped
+	resultEvicted
+	resultFailed
+)
+
+func (b *broadcaster) done(round *broadcastRound, latency time.Duration, result int) {
+	if round == nil {
+		return
+	}
+	round.Lock()
+	switch result {
+	case resultDelivered:
+		round.latencies = append(round.latencies, latency)
+	case resultSkipped:
+		round.skipped++
+	case resultEvicted:
+		round.evicted++
+	case resultFailed:
+		round.failed++
+	}
+	round.Unlock()
+
+	if atomic.AddInt64(&round.pending, -1) == 0 {
+		stats := round.stats()
+		b.last.Store(stats)
+		logger.Info("Jobs broadcast at height %d to %d sessions, p50 %v p90 %v p99 %v max %v, skipped %d, evicted %d, failed %d",
+			stats.Height, stats.Sessions, time.Duration(stats.P50), time.Duration(stats.P90), time.Duration(stats.P99),
+			time.Duration(stats.Max), stats.Skipped, stats.Evicted, stats.Failed)
+	}
+}
+
+func (r *broadcastRound) stats() *BroadcastStats {
+	r.Lock()
+	defer r.Unlock()
+
+	sort.Slice(r.latencies, func(i, j int) bool { return r.latencies[i] < r.latencies[j] })
+	return &BroadcastStats{
+		Height:    r.height,
+		Sessions:  r.sessions,
+		Delivered: len(r.latencies),
+		Skipped:   r.skipped,
+		Evicted:   r.evicted,
+		Failed:    r.failed,
+		P50:       int64(percentile(r.latencies, 0.5)),
+		P90:       int64(percentile(r.latencies, 0.9)),
+		P99:       int64(percentile(r.latencies, 0.99)),
+		Max:       int64(percentile(r.latencies, 1)),
+		Timestamp: util.MakeTimestamp(),
+	}
+}
+
+// percentile of sorted durations
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(q*float64(len(sorted)-1))]
+}
+
+func (b *broadcaster) lastStats() *BroadcastStats {
+	if stats, ok := b.last.Load().(*BroadcastStats); ok {
+		return stats
+	}
+	return nil
+}
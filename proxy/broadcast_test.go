@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/etclabscore/core-pool/library/logger"
+
+	"go.uber.org/zap"
+)
+
+func TestBroadcasterCoalesce(t *testing.T) {
+	logger.SugarLogger = zap.NewNop().Sugar()
+	extranonces, _ := newExtranonceAllocator(2, 0, 0)
+	s := &ProxyServer{sessions: make(map[uint64]*Session)}
+	b := newBroadcaster(s, &Broadcast{Workers: 1, QueueSize: 16, MaxSkipped: 2})
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	cs := &Session{conn: conn, port: &stratumPort{extranonces: extranonces}}
+	s.registerSession(cs)
+
+	t1, t2 := &BlockTemplate{Height: 1}, &BlockTemplate{Height: 2}
+	b.broadcast(t1, []*Session{cs})
+	b.broadcast(t2, []*Session{cs})
+	if len(b.ready) != 1 {
+		t.Fatalf("Session must be queued once, got %d", len(b.ready))
+	}
+	if cs.out.job != t2 {
+		t.Error("Queued job must be replaced by the latest one")
+	}
+	if stats := b.lastStats(); stats == nil || stats.Height != 1 || stats.Skipped != 1 {
+		t.Errorf("Superseded broadcast must be finished as skipped, got %+v", stats)
+	}
+
+	b.broadcast(&BlockTemplate{Height: 3}, []*Session{cs})
+	b.broadcast(&BlockTemplate{Height: 4}, []*Session{cs})
+	if cs.out.job != nil {
+		t.Error("Job of evicted session must be dropped")
+	}
+	if _, ok := s.sessions[cs.id]; ok {
+		t.Error("Slow session must be evicted")
+	}
+	if stats := b.lastStats(); stats == nil || stats.Height != 4 || stats.Evicted != 1 {
+		t.Errorf("Eviction must finish broadcast, got %+v", stats)
+	}
+}
+
+func TestBroadcasterQueueFull(t *testing.T) {
+	logger.SugarLogger = zap.NewNop().Sugar()
+	s := &ProxyServer{sessions: make(map[uint64]*Session)}
+	b := newBroadcaster(s, &Broadcast{Workers: 1, QueueSize: 1, MaxSkipped: 2})
+
+	cs1, cs2 := &Session{}, &Session{}
+	done := make(chan struct{})
+	go func() {
+		b.broadcast(&BlockTemplate{Height: 1}, []*Session{cs1, cs2})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Broadcast must not block on full queue")
+	}
+	if cs2.out.queued || cs2.out.job != nil {
+		t.Error("Session that didn't fit queue must not stay queued")
+	}
+	if len(b.ready) != 1 {
+		t.Errorf("First session must be queued, got %d", len(b.ready))
+	}
+
+	s.ports = []*stratumPort{{config: StratumPort{MaxConn: 100}}, {config: StratumPort{MaxConn: 50}}}
+	if b = newBroadcaster(s, &Broadcast{QueueSize: 16}); cap(b.ready) != 150 {
+		t.Errorf("Queue must fit every port connection, got %d", cap(b.ready))
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Millisecond)
+	}
+	if p := percentile(sorted, 0.5); p != 50*time.Millisecond {
+		t.Errorf("Wrong p50 %v", p)
+	}
+	if p := percentile(sorted, 0.99); p != 99*time.Millisecond {
+		t.Errorf("Wrong p99 %v", p)
+	}
+	if p := percentile(sorted, 1); p != 100*time.Millisecond {
+		t.Errorf("Wrong max %v", p)
+	}
+	if p := percentile(nil, 0.5); p != 0 {
+		t.Errorf("Empty must be zero, got %v", p)
+	}
+}
@@ -4,24 +4,28 @@ import (
 	"github.com/etclabscore/core-pool/api"
 	"github.com/etclabscore/core-pool/payouts"
 	"github.com/etclabscore/core-pool/policy"
+	"github.com/etclabscore/core-pool/rpc"
 	"github.com/etclabscore/core-pool/storage"
 	"time"
 )
 
 type Config struct {
-	Name                  string        `json:"name"`
-	RunLevel              string        `json:"runlevel"`
-	MaxRoutine            int           `json:"maxRoutine"`
-	Proxy                 Proxy         `json:"proxy"`
-	Api                   api.ApiConfig `json:"api"`
-	Upstream              []Upstream    `json:"upstream"`
-	UpstreamCheckInterval string        `json:"upstreamCheckInterval"`
+	Name                  string         `json:"name"`
+	RunLevel              string         `json:"runlevel"`
+	MaxRoutine            int            `json:"maxRoutine"`
+	Proxy                 Proxy          `json:"proxy"`
+	Api                   api.ApiConfig  `json:"api"`
+	Upstream              []Upstream     `json:"upstream"`
+	UpstreamCheckInterval string         `json:"upstreamCheckInterval"`
+	UpstreamPolicy        UpstreamPolicy `json:"upstreamPolicy"`
 
 	Threads int `json:"threads"`
 
-	Network string         `json:"network"`
-	Coin    string         `json:"coin"`
-	Redis   storage.Config `json:"redis"`
+	Network string `json:"network"`
+	// JSON file with extra chain specs, specs named like a builtin network override it
+	ChainSpecs string         `json:"chainSpecs"`
+	Coin       string         `json:"coin"`
+	Redis      storage.Config `json:"redis"`
 
 	BlockUnlocker payouts.UnlockerConfig `json:"unlocker"`
 	Payouts       payouts.PayoutsConfig  `json:"payouts"`
@@ -44,29 +48,154 @@ type Proxy struct {
 	Difficulty           int64  `json:"difficulty"`
 	StateUpdateInterval  string `json:"stateUpdateInterval"`
 	HashrateExpiration   string `json:"hashrateExpiration"`
+	// X-Forwarded-For is honored only from these addresses, loopback if not set
+	ReverseProxies []string `json:"reverseProxies"`
+	// Shares for jobs of this many recent heights are accepted
+	MaxBacklog int `json:"maxBacklog"`
+
+	BlockSubscribe BlockSubscribe `json:"blockSubscribe"`
 
 	Policy policy.Config `json:"policy"`
 
 	MaxFails    int64 `json:"maxFails"`
 	HealthCheck bool  `json:"healthCheck"`
 
-	Stratum Stratum `json:"stratum"`
+	// Leading InstanceBits of extranonce are set to InstanceId,
+	// must be unique across proxies mining on the same upstream
+	InstanceId   uint64 `json:"instanceId"`
+	InstanceBits uint   `json:"instanceBits"`
+
+	Stratum    Stratum    `json:"stratum"`
+	VarDiff    VarDiff    `json:"varDiff"`
+	StaticDiff StaticDiff `json:"staticDiff"`
+	Solo       Solo       `json:"solo"`
+	Admin      Admin      `json:"admin"`
+	Broadcast  Broadcast  `json:"broadcast"`
+
+	ProxyProtocol ProxyProtocol `json:"proxyProtocol"`
 }
 
 type Stratum struct {
-	Enabled  bool   `json:"enabled"`
+	Enabled       bool   `json:"enabled"`
+	Listen        string `json:"listen"`
+	Timeout       string `json:"timeout"`
+	MaxConn       int    `json:"maxConn"`
+	TLS           bool   `json:"tls"`
+	CertFile      string `json:"certFile"`
+	KeyFile       string `json:"keyFile"`
+	ResumeTimeout string `json:"resumeTimeout"`
+	// In bytes, miner searches the rest of 8 byte nonce
+	ExtranonceSize int `json:"extranonceSize"`
+	// Where miners are sent by client.reconnect on drain, empty host means same address
+	ReconnectHost string `json:"reconnectHost"`
+	ReconnectPort int    `json:"reconnectPort"`
+	ReconnectWait int    `json:"reconnectWait"`
+	DrainTimeout  string `json:"drainTimeout"`
+	// Listen, tls and maxConn above are used only if ports are not set
+	Ports []StratumPort `json:"ports"`
+}
+
+type StratumPort struct {
+	Name     string `json:"name"`
 	Listen   string `json:"listen"`
-	Timeout  string `json:"timeout"`
-	MaxConn  int    `json:"maxConn"`
 	TLS      bool   `json:"tls"`
 	CertFile string `json:"certFile"`
 	KeyFile  string `json:"keyFile"`
+	MaxConn  int    `json:"maxConn"`
+	// Stratum messages are carried in WebSocket frames on given path
+	WebSocket bool   `json:"websocket"`
+	Path      string `json:"path"`
+	// Starting difficulty, proxy difficulty if not set
+	Difficulty int64 `json:"difficulty"`
+	// Proxy vardiff if not set
+	VarDiff *VarDiff `json:"varDiff"`
+	// EthProxy, NiceHash, Stratum2, all of them if empty
+	Protocols []string `json:"protocols"`
+	// All miners on this port mine solo
+	Solo bool `json:"solo"`
+	// In bytes, stratum extranonceSize if not set
+	ExtranonceSize int `json:"extranonceSize"`
+}
+
+type VarDiff struct {
+	Enabled         bool    `json:"enabled"`
+	MinDiff         int64   `json:"minDiff"`
+	MaxDiff         int64   `json:"maxDiff"`
+	MinSharesPerMin float64 `json:"minSharesPerMin"`
+	MaxSharesPerMin float64 `json:"maxSharesPerMin"`
+	RetargetTime    string  `json:"retargetTime"`
+}
+
+// Difficulty chosen by miner with "d=N" password or "+N" login suffix
+type StaticDiff struct {
+	Enabled bool  `json:"enabled"`
+	MinDiff int64 `json:"minDiff"`
+	MaxDiff int64 `json:"maxDiff"`
+}
+
+// Solo miners get whole block they find, unlocker charges soloFee
+type Solo struct {
+	// Miners opt in with login like "solo:0x...", disabled if empty
+	LoginPrefix string `json:"loginPrefix"`
+}
+
+// Push based block templates, upstream must have subscribeUrl
+type BlockSubscribe struct {
+	Enabled bool     `json:"enabled"`
+	Topics  []string `json:"topics"`
+	// Block refresh interval while subscription is alive
+	PollInterval  string `json:"pollInterval"`
+	RetryInterval string `json:"retryInterval"`
+	// Subscription without notifications this long is treated as dropped
+	IdleTimeout string `json:"idleTimeout"`
+}
+
+// Job delivery to stratum sessions
+type Broadcast struct {
+	Workers      int    `json:"workers"`
+	QueueSize    int    `json:"queueSize"`
+	WriteTimeout string `json:"writeTimeout"`
+	// Session is disconnected if this many jobs in a row were superseded before written
+	MaxSkipped int `json:"maxSkipped"`
+}
+
+// PROXY protocol v1/v2 header carries real miner address through balancer,
+// it is accepted only from trusted sources so outside clients can't spoof it
+type ProxyProtocol struct {
+	Stratum        bool     `json:"stratum"`
+	Http           bool     `json:"http"`
+	TrustedProxies []string `json:"trustedProxies"`
+	HeaderTimeout  string   `json:"headerTimeout"`
+}
+
+// Operator endpoints, requests are authorized by bearer token
+type Admin struct {
+	Enabled bool   `json:"enabled"`
+	Listen  string `json:"listen"`
+	Token   string `json:"token"`
 }
 
 type Upstream struct {
 	Name    string `json:"name"`
 	Url     string `json:"url"`
 	Timeout string `json:"timeout"`
+	// WebSocket url or IPC path for eth_subscribe notifications
+	SubscribeUrl string `json:"subscribeUrl"`
+	// Credentials and TLS options for both url and subscribeUrl
+	Auth *rpc.Auth `json:"auth"`
+	// Per method timeouts and retries of idempotent calls
+	CallPolicy *rpc.CallPolicy `json:"callPolicy"`
+	// Lower value is preferred, upstreams of the same priority are ordered by weight
+	Priority int `json:"priority"`
+	Weight   int `json:"weight"`
+}
+
+type UpstreamPolicy struct {
+	// Upstream more than this number of blocks behind the best head is not used
+	MaxHeadLag uint64 `json:"maxHeadLag"`
+	MinPeers   int64  `json:"minPeers"`
+	// Number of consecutive healthy checks before switching to a preferred upstream
+	SwitchChecks int `json:"switchChecks"`
 }
 
 type Logger struct {
@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/etclabscore/core-pool/library/logger"
+	"github.com/etclabscore/core-pool/util"
+)
+
+const (
+	defaultDrainTimeout = 10 * time.Second
+	// Shares read before connections were closed are still being processed
+	drainInflightTimeout = 5 * time.Second
+)
+
+const (
+	drainNone int32 = iota
+	drainRunning
+	drainDone
+)
+
+func (s *ProxyServer) addListener(l net.Listener) {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+	s.listeners = append(s.listeners, l)
+}
+
+func (s *ProxyServer) closeListeners() {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+	for _, l := range s.listeners {
+		l.Close()
+	}
+	s.listeners = nil
+}
+
+// Listener dropped from the list was closed by drain, not failed
+func (s *ProxyServer) isListening(l net.Listener) bool {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+	for _, v := range s.listeners {
+		if v == l {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *ProxyServer) isDraining() bool {
+	return atomic.LoadInt32(&s.draining) > 0
+}
+
+// Drain stops accepting stratum connections and asks miners to reconnect elsewhere.
+// Sessions keep submitting shares until they leave or drain timeout passes, but not
+// shorter than reconnect wait, then every connection left is closed and drain waits
+// for in-flight shares to be processed.
+// Empty host makes miners reconnect to the address they used before, e.g. another node behind balancer.
+func (s *ProxyServer) Drain(host string, port int) {
+	if !atomic.CompareAndSwapInt32(&s.draining, drainNone, drainRunning) {
+		return
+	}
+	start := time.Now()
+	s.closeListeners()
+
+	s.sessionsMu.RLock()
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, cs := range s.sessions {
+		sessions = append(sessions, cs)
+	}
+	s.sessionsMu.RUnlock()
+	logger.Info("Draining %d stratum sessions", len(sessions))
+
+	wait := s.config.Proxy.Stratum.ReconnectWait
+	for _, cs := range sessions {
+		if err := cs.sendReconnect(host, port, wait); err != nil {
+			logger.Warn("Failed to send reconnect to %s@%s: %v", cs.identity(), cs.ip, err)
+		}
+	}
+
+	timeout := defaultDrainTimeout
+	if len(s.config.Proxy.Stratum.DrainTimeout) > 0 {
+		timeout = util.MustParseDuration(s.config.Proxy.Stratum.DrainTimeout)
+	}
+	if reconnect := time.Duration(wait) * time.Second; timeout < reconnect {
+		timeout = reconnect
+	}
+	deadline := start.Add(timeout)
+	for s.countActive(sessions) > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if n := s.countActive(sessions); n > 0 {
+		logger.Warn("Drain timeout, closing %d sessions", n)
+	}
+
+	// Also closes connections not authorized yet or still in TLS or PROXY handshake
+	s.closeConns()
+	deadline = time.Now().Add(drainInflightTimeout)
+	for atomic.LoadInt64(&s.inflight) > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if n := atomic.LoadInt64(&s.inflight); n > 0 {
+		logger.Warn("Drain timeout, %d shares still in flight", n)
+	}
+	atomic.StoreInt32(&s.draining, drainDone)
+	logger.Info("Stratum drain finished %s", time.Since(start))
+}
+
+// Sessions of the list still connected
+func (s *ProxyServer) countActive(sessions []*Session) int {
+	s.sessionsMu.RLock()
+	defer s.sessionsMu.RUnlock()
+	n := 0
+	for _, cs := range sessions {
+		if !cs.removed {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *ProxyServer) trackConn(conn net.Conn) {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]struct{})
+	}
+	s.conns[conn] = struct{}{}
+}
+
+func (s *ProxyServer) untrackConn(conn net.Conn) {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	delete(s.conns, conn)
+}
+
+func (s *ProxyServer) closeConns() {
+	s.connsMu.Lock()
+	conns := make([]net.Conn, 0, len(s.conns))
+	for conn := range s.conns {
+		conns = append(conns, conn)
+	}
+	s.connsMu.Unlock()
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+// Undrain reopens stratum ports, it fails while drain is still running
+func (s *ProxyServer) Undrain() bool {
+	if !atomic.CompareAndSwapInt32(&s.draining, drainDone, drainNone) {
+		return false
+	}
+	logger.Info("Stratum drain cancelled, reopening ports")
+	s.ListenTCP()
+	return true
+}
+
+// client.reconnect is understood by NiceHash and EthereumStratum/2.0.0 miners only
+func (cs *Session) sendReconnect(host string, port, wait int) error {
+	if cs.stratumMode() != NiceHash && cs.stratumMode() != Stratum2 {
+		return nil
+	}
+	params := []interface{}{}
+	if len(host) > 0 && port > 0 {
+		params = append(params, host, port, wait)
+	}
+	return cs.sendTCPReq(JSONStratumReq{Method: "client.reconnect", Params: params})
+}
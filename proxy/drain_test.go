@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/etclabscore/core-pool/library/logger"
+
+	"go.uber.org/zap"
+)
+
+func newDrainTestServer() *ProxyServer {
+	logger.SugarLogger = zap.NewNop().Sugar()
+	cfg := &Config{}
+	cfg.Proxy.Stratum.Timeout = "10s"
+	cfg.Proxy.Stratum.DrainTimeout = "2s"
+	return &ProxyServer{config: cfg, sessions: make(map[uint64]*Session)}
+}
+
+func TestDrain(t *testing.T) {
+	s := newDrainTestServer()
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	extranonces, _ := newExtranonceAllocator(2, 0, 0)
+	cs := &Session{id: 1, conn: conn, enc: json.NewEncoder(conn), stratum: NiceHash, port: &stratumPort{extranonces: extranonces}}
+	s.trackConn(conn)
+	s.registerSession(cs)
+	// Connection which didn't authorize is not in sessions
+	idle, idlePeer := net.Pipe()
+	defer idlePeer.Close()
+	s.trackConn(idle)
+
+	done := make(chan struct{})
+	go func() {
+		s.Drain("pool.example.com", 8008)
+		close(done)
+	}()
+
+	line, err := bufio.NewReader(peer).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(line, `"client.reconnect"`) || !strings.Contains(line, `"pool.example.com",8008`) {
+		t.Errorf("Must ask miner to reconnect, got %s", line)
+	}
+
+	select {
+	case <-done:
+		t.Fatal("Drain must wait for session to leave")
+	case <-time.After(300 * time.Millisecond):
+	}
+	if s.Undrain() {
+		t.Error("Undrain must fail while drain is running")
+	}
+
+	// Share read before session left is still being processed
+	atomic.AddInt64(&s.inflight, 1)
+	s.removeSession(cs)
+	if _, err = idlePeer.Read(make([]byte, 1)); err == nil {
+		t.Error("Unauthorized connection must be closed once sessions left")
+	}
+	select {
+	case <-done:
+		t.Fatal("Drain must wait for in-flight shares")
+	case <-time.After(200 * time.Millisecond):
+	}
+	atomic.AddInt64(&s.inflight, -1)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Drain must finish once in-flight shares are processed")
+	}
+	if _, err = peer.Read(make([]byte, 1)); err == nil {
+		t.Error("Session must be closed after drain")
+	}
+}
+
+func TestDrainReconnectWait(t *testing.T) {
+	s := newDrainTestServer()
+	s.config.Proxy.Stratum.DrainTimeout = "100ms"
+	s.config.Proxy.Stratum.ReconnectWait = 1
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	// EthProxy miner gets no reconnect and keeps mining until drain closes it
+	cs := &Session{id: 1, conn: conn, enc: json.NewEncoder(conn), stratum: EthProxy}
+	s.trackConn(conn)
+	s.registerSession(cs)
+
+	start := time.Now()
+	s.Drain("", 0)
+	if d := time.Since(start); d < time.Second {
+		t.Errorf("Drain must give miners reconnect wait, finished in %v", d)
+	}
+	if _, err := peer.Read(make([]byte, 1)); err == nil {
+		t.Error("Session must be closed after drain")
+	}
+}
+
+func TestAdminUndrain(t *testing.T) {
+	s := newDrainTestServer()
+
+	w := httptest.NewRecorder()
+	s.AdminUndrain(w, httptest.NewRequest("POST", "/admin/undrain", nil))
+	if w.Code != http.StatusConflict {
+		t.Errorf("Undrain without drain must conflict, got %d", w.Code)
+	}
+
+	s.Drain("", 0)
+	w = httptest.NewRecorder()
+	s.AdminUndrain(w, httptest.NewRequest("POST", "/admin/undrain", nil))
+	if w.Code != http.StatusOK || s.isDraining() {
+		t.Errorf("Drained proxy must be undrained, got %d", w.Code)
+	}
+}
+
+func TestIsListening(t *testing.T) {
+	s := newDrainTestServer()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	s.addListener(l)
+	if !s.isListening(l) {
+		t.Fatal("Added listener must be listening")
+	}
+	s.closeListeners()
+	if s.isListening(l) {
+		t.Error("Closed listener must not be listening")
+	}
+}
@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+const defaultExtranonceSize = 2
+
+var errExtranonceExhausted = errors.New("extranonce space exhausted")
+
+// extranonceAllocator hands out unique extranonces of fixed width.
+// Leading partitionBits of every extranonce hold the partition (proxy instance),
+// so proxies sharing one upstream never give out overlapping nonce ranges.
+type extranonceAllocator struct {
+	sync.Mutex
+	size     int
+	prefix   uint64
+	capacity uint64
+	next     uint64
+	free     []uint64
+	used     map[uint64]struct{}
+}
+
+func newExtranonceAllocator(size int, partition uint64, partitionBits uint) (*extranonceAllocator, error) {
+	if size == 0 {
+		size = defaultExtranonceSize
+	}
+	// Miner needs at least 5 bytes of 8 byte nonce for itself
+	if size < 1 || size > 3 {
+		return nil, fmt.Errorf("extranonce size must be 1-3 bytes, got %d", size)
+	}
+	bits := uint(size * 8)
+	if partitionBits >= bits {
+		return nil, fmt.Errorf("%d partition bits leave no room in %d byte extranonce", partitionBits, size)
+	}
+	if partition >= 1<<partitionBits {
+		return nil, fmt.Errorf("partition %d does not fit in %d bits", partition, partitionBits)
+	}
+	free := bits - partitionBits
+	return &extranonceAllocator{
+		size:     size,
+		prefix:   partition << free,
+		capacity: 1 << free,
+		used:     make(map[uint64]struct{}),
+	}, nil
+}
+
+// Allocate returns released extranonce if any, otherwise next unused one
+func (a *extranonceAllocator) Allocate() (string, error) {
+	a.Lock()
+	defer a.Unlock()
+
+	var v uint64
+	if n := len(a.free); n > 0 {
+		v = a.free[n-1]
+		a.free = a.free[:n-1]
+	} else if a.next < a.capacity {
+		v = a.next
+		a.next++
+	} else {
+		return "", errExtranonceExhausted
+	}
+	a.used[v] = struct{}{}
+	return fmt.Sprintf("%0*x", a.size*2, a.prefix|v), nil
+}
+
+// Release returns extranonce back to the pool, unknown or already released values are ignored
+func (a *extranonceAllocator) Release(extranonce string) {
+	if len(extranonce) != a.size*2 {
+		return
+	}
+	n, err := strconv.ParseUint(extranonce, 16, 64)
+	if err != nil {
+		return
+	}
+	// Must belong to own partition
+	if n&^(a.capacity-1) != a.prefix {
+		return
+	}
+	v := n & (a.capacity - 1)
+
+	a.Lock()
+	defer a.Unlock()
+	if _, ok := a.used[v]; !ok {
+		return
+	}
+	delete(a.used, v)
+	a.free = append(a.free, v)
+}
+
+func (a *extranonceAllocator) InUse() int {
+	a.Lock()
+	defer a.Unlock()
+	return len(a.used)
+}
@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"testing"
+)
+
+func TestExtranonceAllocate(t *testing.T) {
+	a, err := newExtranonceAllocator(1, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < 256; i++ {
+		extranonce, err := a.Allocate()
+		if err != nil {
+			t.Fatalf("Must allocate %v extranonce: %v", i, err)
+		}
+		if len(extranonce) != 2 {
+			t.Errorf("Extranonce must be 2 hex chars: %v", extranonce)
+		}
+		if seen[extranonce] {
+			t.Errorf("Extranonce must be unique: %v", extranonce)
+		}
+		seen[extranonce] = true
+	}
+	if _, err := a.Allocate(); err != errExtranonceExhausted {
+		t.Error("Must reject when space is exhausted")
+	}
+
+	a.Release("2a")
+	a.Release("2a")
+	extranonce, err := a.Allocate()
+	if err != nil || extranonce != "2a" {
+		t.Errorf("Must reuse released extranonce: %v %v", extranonce, err)
+	}
+	if _, err := a.Allocate(); err != errExtranonceExhausted {
+		t.Error("Must not release the same extranonce twice")
+	}
+}
+
+func TestExtranoncePartition(t *testing.T) {
+	a, _ := newExtranonceAllocator(2, 3, 2)
+	b, _ := newExtranonceAllocator(2, 1, 2)
+
+	extranonce, _ := a.Allocate()
+	if extranonce != "c000" {
+		t.Errorf("Must start with partition prefix: %v", extranonce)
+	}
+	extranonce, _ = b.Allocate()
+	if extranonce != "4000" {
+		t.Errorf("Must start with partition prefix: %v", extranonce)
+	}
+
+	// Foreign partition must be ignored
+	a.Release("4000")
+	if b.InUse() != 1 || a.InUse() != 1 {
+		t.Error("Must not release extranonce of another partition")
+	}
+
+	if _, err := newExtranonceAllocator(2, 4, 2); err == nil {
+		t.Error("Must reject partition which does not fit")
+	}
+	if _, err := newExtranonceAllocator(4, 0, 0); err == nil {
+		t.Error("Must reject too wide extranonce")
+	}
+}
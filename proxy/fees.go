@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/etclabscore/core-pool/library/logger"
+	"github.com/etclabscore/core-pool/util"
+
+	"github.com/gorilla/mux"
+)
+
+// GET /admin/fees
+func (s *ProxyServer) AdminFees(w http.ResponseWriter, r *http.Request) {
+	fees, err := s.backend.GetMinerFees()
+	if err != nil {
+		logger.Error("Failed to get miner fees from backend: %v", err)
+		writeAdminReply(w, http.StatusInternalServerError, map[string]string{"error": "backend error"})
+		return
+	}
+	writeAdminReply(w, http.StatusOK, map[string]interface{}{
+		"poolFee": s.config.BlockUnlocker.PoolFee,
+		"soloFee": s.config.BlockUnlocker.SoloFee,
+		"fees":    fees,
+	})
+}
+
+// PUT /admin/fees/{login}?fee=0.5 overrides pool fee of a miner, in percent
+func (s *ProxyServer) AdminSetFee(w http.ResponseWriter, r *http.Request) {
+	login := strings.ToLower(mux.Vars(r)["login"])
+	if !util.IsValidHexAddress(login) {
+		writeAdminReply(w, http.StatusBadRequest, map[string]string{"error": "invalid login"})
+		return
+	}
+	fee, err := parseMinerFee(r.URL.Query().Get("fee"))
+	if err != nil {
+		writeAdminReply(w, http.StatusBadRequest, map[string]string{"error": "invalid fee"})
+		return
+	}
+	err = s.backend.SetMinerFee(login, fee)
+	if err != nil {
+		logger.Error("Failed to set miner fee in backend: %v", err)
+		writeAdminReply(w, http.StatusInternalServerError, map[string]string{"error": "backend error"})
+		return
+	}
+	logger.Info("Set pool fee of %s to %v%%", login, fee)
+	writeAdminReply(w, http.StatusOK, map[string]interface{}{"login": login, "fee": fee})
+}
+
+// DELETE /admin/fees/{login} restores default pool fee of a miner
+func (s *ProxyServer) AdminDeleteFee(w http.ResponseWriter, r *http.Request) {
+	login := strings.ToLower(mux.Vars(r)["login"])
+	if !util.IsValidHexAddress(login) {
+		writeAdminReply(w, http.StatusBadRequest, map[string]string{"error": "invalid login"})
+		return
+	}
+	deleted, err := s.backend.DeleteMinerFee(login)
+	if err != nil {
+		logger.Error("Failed to delete miner fee from backend: %v", err)
+		writeAdminReply(w, http.StatusInternalServerError, map[string]string{"error": "backend error"})
+		return
+	}
+	if !deleted {
+		writeAdminReply(w, http.StatusNotFound, map[string]string{"error": "fee not found"})
+		return
+	}
+	logger.Info("Restored default pool fee of %s", login)
+	writeAdminReply(w, http.StatusOK, map[string]interface{}{"login": login, "deleted": true})
+}
+
+// Fee is a percentage, 0 exempts miner from fees
+func parseMinerFee(value string) (float64, error) {
+	fee, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	if !(fee >= 0 && fee <= 100) {
+		return 0, strconv.ErrRange
+	}
+	return fee, nil
+}
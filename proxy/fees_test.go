@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/etclabscore/core-pool/chain"
+	"github.com/etclabscore/core-pool/library/logger"
+	"github.com/etclabscore/core-pool/payouts"
+	"github.com/etclabscore/core-pool/storage"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+func TestParseMinerFee(t *testing.T) {
+	for _, value := range []string{"0", "0.5", "100"} {
+		if _, err := parseMinerFee(value); err != nil {
+			t.Errorf("Fee %s must be valid: %v", value, err)
+		}
+	}
+	for _, value := range []string{"", "-1", "100.1", "NaN", "abc"} {
+		if _, err := parseMinerFee(value); err == nil {
+			t.Errorf("Fee %q must be rejected", value)
+		}
+	}
+}
+
+func feeRequest(method, login, fee string) *http.Request {
+	r := httptest.NewRequest(method, "/admin/fees/"+login+"?fee="+fee, nil)
+	return mux.SetURLVars(r, map[string]string{"login": login})
+}
+
+func TestAdminSetFeeInvalid(t *testing.T) {
+	logger.SugarLogger = zap.NewNop().Sugar()
+	s := &ProxyServer{config: &Config{}}
+
+	w := httptest.NewRecorder()
+	s.AdminSetFee(w, feeRequest("PUT", "miner", "0.5"))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Invalid login must be rejected, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	s.AdminDeleteFee(w, feeRequest("DELETE", "0x123", ""))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Invalid login must be rejected on delete, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	s.AdminSetFee(w, feeRequest("PUT", "0xb85150eb365e7df0941f0cf08235f987ba91506a", "101"))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Invalid fee must be rejected, got %d", w.Code)
+	}
+}
+
+func TestAdminFees(t *testing.T) {
+	logger.SugarLogger = zap.NewNop().Sugar()
+	backend := storage.NewRedisClient(&storage.Config{Endpoint: "127.0.0.1:6379"}, "test-fees")
+	if _, err := backend.Check(); err != nil {
+		t.Skip("redis is not available")
+	}
+	login := "0xb85150eb365e7df0941f0cf08235f987ba91506a"
+	backend.DeleteMinerFee(login)
+	defer backend.DeleteMinerFee(login)
+	s := &ProxyServer{config: &Config{}, backend: backend}
+
+	w := httptest.NewRecorder()
+	s.AdminSetFee(w, feeRequest("PUT", login, "0.5"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Fee must be set, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	s.AdminFees(w, httptest.NewRequest("GET", "/admin/fees", nil))
+	var reply struct {
+		Fees map[string]float64 `json:"fees"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&reply); err != nil || reply.Fees[login] != 0.5 {
+		t.Errorf("Fee must be listed, got %v %v", reply.Fees, err)
+	}
+
+	w = httptest.NewRecorder()
+	s.AdminDeleteFee(w, feeRequest("DELETE", login, ""))
+	if w.Code != http.StatusOK {
+		t.Errorf("Fee must be deleted, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	s.AdminDeleteFee(w, feeRequest("DELETE", login, ""))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Missing fee must not be found, got %d", w.Code)
+	}
+}
+
+func TestShareCreditMinerFee(t *testing.T) {
+	spec, err := chain.NewSpec(&chain.Config{Name: "devnet", Reward: chain.RewardConfig{Schedule: []chain.RewardStep{{Height: 0, Reward: "2000000000000000000"}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &ProxyServer{chain: spec, pps: newPPSCredits(&payouts.UnlockerConfig{RewardScheme: payouts.SchemePPS, PoolFee: 1.0})}
+	s.pps.minerFees.Store(map[string]float64{"0x1": 0})
+	h := heightDiffPair{height: 1, diff: big.NewInt(1000000000000000)}
+
+	// 2 ETH * 4e9 / 1e15 = 8000 Shannon
+	if credit := s.shareCredit("0x0", 4000000000, h); credit != 7920 {
+		t.Errorf("Default fee must be charged, got %d", credit)
+	}
+	if credit := s.shareCredit("0x1", 4000000000, h); credit != 8000 {
+		t.Errorf("Miner fee override must be charged, got %d", credit)
+	}
+}
@@ -2,7 +2,10 @@ package proxy
 
 import (
 	"regexp"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/etclabscore/core-pool/library/logger"
 	"github.com/etclabscore/core-pool/rpc"
@@ -13,6 +16,8 @@ import (
 var noncePattern = regexp.MustCompile("^0x[0-9a-f]{16}$")
 var hashPattern = regexp.MustCompile("^0x[0-9a-f]{64}$")
 var workerPattern = regexp.MustCompile("^[0-9a-zA-Z-_]{1,8}$")
+var diffHintPattern = regexp.MustCompile("^d=([0-9]+)$")
+var rigIdPattern = regexp.MustCompile("^0x[0-9a-fA-F]{1,64}$")
 
 // Stratum
 func (s *ProxyServer) handleLoginRPC(cs *Session, params []string, id string) (bool, *ErrorReply) {
@@ -20,37 +25,130 @@ func (s *ProxyServer) handleLoginRPC(cs *Session, params []string, id string) (b
 		return false, &ErrorReply{Code: -1, Message: "Invalid params"}
 	}
 
-	login := strings.ToLower(params[0])
+	login, solo := s.parseSoloLogin(cs, strings.ToLower(params[0]))
+	login, diff := parseLoginDiff(login)
 	if !util.IsValidHexAddress(login) {
 		return false, &ErrorReply{Code: -1, Message: "Invalid login"}
 	}
 	if !s.policy.ApplyLoginPolicy(login, cs.ip) {
 		return false, &ErrorReply{Code: -1, Message: "You are blacklisted"}
 	}
+	if len(params) > 1 {
+		if d := parsePasswordDiff(params[1]); d > 0 {
+			diff = d
+		}
+	}
+	if diff > 0 && s.config.Proxy.StaticDiff.Enabled {
+		diff = s.setStaticDifficulty(cs, diff)
+		logger.Info("Static difficulty %d for %v@%v", diff, login, cs.ip)
+	}
+	cs.Lock()
 	cs.login = login
+	cs.solo = solo
+	if workerPattern.MatchString(id) {
+		cs.worker = id
+	}
+	cs.Unlock()
+	if solo {
+		logger.Info("Solo mining for %v@%v", login, cs.ip)
+	}
 	s.registerSession(cs)
 	logger.Info("Stratum miner connected %v@%v", login, cs.ip)
 	return true, nil
 }
 
+// Pin session difficulty requested by miner, vardiff is off for such session
+func (s *ProxyServer) setStaticDifficulty(cs *Session, diff int64) int64 {
+	cfg := s.config.Proxy.StaticDiff
+	if cfg.MinDiff > 0 && diff < cfg.MinDiff {
+		diff = cfg.MinDiff
+	}
+	if cfg.MaxDiff > 0 && diff > cfg.MaxDiff {
+		diff = cfg.MaxDiff
+	}
+	cs.fixDifficulty(diff)
+	return diff
+}
+
+// Solo is chosen by port or by login prefix, returns login without prefix
+func (s *ProxyServer) parseSoloLogin(cs *Session, login string) (string, bool) {
+	solo := cs.port != nil && cs.port.config.Solo
+	if prefix := strings.ToLower(s.config.Proxy.Solo.LoginPrefix); len(prefix) > 0 && strings.HasPrefix(login, prefix) {
+		return login[len(prefix):], true
+	}
+	return login, solo
+}
+
+// Difficulty may be appended to login as "0x...+4000000000", returns login without suffix
+func parseLoginDiff(login string) (string, int64) {
+	i := strings.LastIndex(login, "+")
+	if i < 0 {
+		return login, 0
+	}
+	diff, err := strconv.ParseInt(login[i+1:], 10, 64)
+	if err != nil || diff <= 0 {
+		return login[:i], 0
+	}
+	return login[:i], diff
+}
+
+// Password may carry "d=4000000000", optionally among other comma separated options
+func parsePasswordDiff(password string) int64 {
+	for _, opt := range strings.Split(password, ",") {
+		m := diffHintPattern.FindStringSubmatch(strings.TrimSpace(opt))
+		if m == nil {
+			continue
+		}
+		diff, err := strconv.ParseInt(m[1], 10, 64)
+		if err == nil && diff > 0 {
+			return diff
+		}
+	}
+	return 0
+}
+
+// Split "login.worker", difficulty suffix goes to login whether it's
+// before the worker ("0x...+4000.rig") or after it ("0x....rig+4000")
+func splitWorker(s string) (string, string) {
+	parts := strings.SplitN(s, ".", 2)
+	if len(parts) == 1 {
+		return s, ""
+	}
+	login, worker := parts[0], parts[1]
+	if i := strings.LastIndex(worker, "+"); i >= 0 {
+		login, worker = login+worker[i:], worker[:i]
+	}
+	return login, worker
+}
+
 func (s *ProxyServer) handleGetWorkRPC(cs *Session) ([]string, *ErrorReply) {
 	t := s.currentBlockTemplate()
 	if t == nil || len(t.Header) == 0 || s.isSick() {
 		return nil, &ErrorReply{Code: 0, Message: "Work not ready"}
 	}
-	return []string{t.Header, t.Seed, s.diff, util.ToHex(int64(t.Height))}, nil
+	return []string{t.Header, t.Seed, cs.target(), util.ToHex(int64(t.Height))}, nil
 }
 
 // Stratum
 func (s *ProxyServer) handleTCPSubmitRPC(cs *Session, id string, params []string) (bool, *ErrorReply) {
 	s.sessionsMu.RLock()
-	_, ok := s.sessions[cs]
+	_, ok := s.sessions[cs.id]
 	s.sessionsMu.RUnlock()
 
 	if !ok {
 		return false, &ErrorReply{Code: 25, Message: "Not subscribed"}
 	}
-	return s.handleSubmitRPC(cs, cs.login, id, params)
+	// Shares are credited while draining too, drain waits for them before shutdown
+	atomic.AddInt64(&s.inflight, 1)
+	defer atomic.AddInt64(&s.inflight, -1)
+	reply, errReply := s.handleSubmitRPC(cs, cs.identity(), id, params)
+	if reply {
+		cs.markShare()
+	}
+	if vd := cs.varDiff(); reply && vd != nil {
+		vd.onShare(time.Now(), cs.difficulty())
+	}
+	return reply, errReply
 }
 
 func (s *ProxyServer) handleSubmitRPC(cs *Session, login, id string, params []string) (bool, *ErrorReply) {
@@ -78,7 +176,7 @@ func (s *ProxyServer) handleSubmitRPC(cs *Session, login, id string, params []st
 		return false, &ErrorReply{Code: -1, Message: "Malformed PoW result"}
 	}
 	t := s.currentBlockTemplate()
-	exist, validShare := s.processShare(login, id, cs.ip, t, params, stratumMode != EthProxy)
+	exist, validShare := s.processShare(cs, login, id, t, params, stratumMode != EthProxy)
 	ok := s.policy.ApplySharePolicy(cs.ip, !exist && validShare)
 
 	if exist {
@@ -106,6 +204,34 @@ func (s *ProxyServer) handleSubmitRPC(cs *Session, login, id string, params []st
 	return true, nil
 }
 
+// params[0] = hashrate in hex
+// params[1] = optional rig ID
+func (s *ProxyServer) handleSubmitHashrateRPC(cs *Session, login, id string, params []string) (bool, *ErrorReply) {
+	if !workerPattern.MatchString(id) {
+		id = "0"
+	}
+	if len(params) < 1 {
+		s.policy.ApplyMalformedPolicy(cs.ip)
+		return false, &ErrorReply{Code: -1, Message: "Invalid params"}
+	}
+	hashrate, err := strconv.ParseInt(strings.TrimPrefix(strings.ToLower(params[0]), "0x"), 16, 64)
+	if err != nil || hashrate < 0 {
+		s.policy.ApplyMalformedPolicy(cs.ip)
+		logger.Warn("Malformed hashrate from %s@%s %v", login, cs.ip, params)
+		return false, &ErrorReply{Code: -1, Message: "Invalid params"}
+	}
+	rigId := ""
+	if len(params) > 1 && rigIdPattern.MatchString(params[1]) {
+		rigId = strings.ToLower(params[1])
+	}
+
+	err = s.backend.WriteReportedHashrate(login, id, rigId, hashrate, s.hashrateExpiration)
+	if err != nil {
+		logger.Error("Failed to insert reported hashrate into backend: %v", err)
+	}
+	return true, nil
+}
+
 func (s *ProxyServer) handleGetBlockByNumberRPC() *rpc.GetBlockReplyPart {
 	t := s.currentBlockTemplate()
 	var reply *rpc.GetBlockReplyPart
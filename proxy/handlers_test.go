@@ -0,0 +1,64 @@
+package proxy
+
+import "testing"
+
+func TestSplitWorker(t *testing.T) {
+	tests := []struct {
+		in, login, worker string
+	}{
+		{"0xabc", "0xabc", ""},
+		{"0xabc.rig", "0xabc", "rig"},
+		{"0xabc+4000", "0xabc+4000", ""},
+		{"0xabc+4000.rig", "0xabc+4000", "rig"},
+		{"0xabc.rig+4000", "0xabc+4000", "rig"},
+		{"solo:0xabc+4000.rig", "solo:0xabc+4000", "rig"},
+		{"0xabc.", "0xabc", ""},
+	}
+	for _, tt := range tests {
+		login, worker := splitWorker(tt.in)
+		if login != tt.login || worker != tt.worker {
+			t.Errorf("splitWorker(%q) = %q, %q, want %q, %q", tt.in, login, worker, tt.login, tt.worker)
+		}
+	}
+}
+
+func TestParseLoginDiff(t *testing.T) {
+	tests := []struct {
+		in, login string
+		diff      int64
+	}{
+		{"0xabc", "0xabc", 0},
+		{"0xabc+4000000000", "0xabc", 4000000000},
+		{"0xabc+", "0xabc", 0},
+		{"0xabc+-5", "0xabc", 0},
+		{"0xabc+4k", "0xabc", 0},
+		{"0xabc+1+2", "0xabc+1", 2},
+	}
+	for _, tt := range tests {
+		login, diff := parseLoginDiff(tt.in)
+		if login != tt.login || diff != tt.diff {
+			t.Errorf("parseLoginDiff(%q) = %q, %v, want %q, %v", tt.in, login, diff, tt.login, tt.diff)
+		}
+	}
+}
+
+func TestParsePasswordDiff(t *testing.T) {
+	tests := []struct {
+		in   string
+		diff int64
+	}{
+		{"", 0},
+		{"x", 0},
+		{"d=4000000000", 4000000000},
+		{"x, d=4000 ,y", 4000},
+		{"d=0", 0},
+		{"d=-1", 0},
+		{"d=abc,d=5", 5},
+		{"diff=4000", 0},
+	}
+	for _, tt := range tests {
+		if diff := parsePasswordDiff(tt.in); diff != tt.diff {
+			t.Errorf("parsePasswordDiff(%q) = %v, want %v", tt.in, diff, tt.diff)
+		}
+	}
+}
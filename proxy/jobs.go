@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"strconv"
+	"strings"
+)
+
+const jobIDLength = 16
+
+// Job ID is derived from header hash, so every session and every proxy
+// names the same work the same way and it can be traced in logs.
+func jobID(header string) string {
+	h := strings.TrimPrefix(header, "0x")
+	if len(h) > jobIDLength {
+		return h[:jobIDLength]
+	}
+	return h
+}
+
+// Stratum sends hashes and height without 0x prefix
+func newJobDetails(header, seed string, height uint64) jobDetails {
+	return jobDetails{
+		JobID:      jobID(header),
+		SeedHash:   strings.TrimPrefix(seed, "0x"),
+		HeaderHash: strings.TrimPrefix(header, "0x"),
+		Height:     strconv.FormatUint(height, 16),
+	}
+}
+
+// job resolves job ID of current or backlog work, unknown ID means stale share
+func (t *BlockTemplate) job(id string) (jobDetails, bool) {
+	header, ok := t.jobs[id]
+	if !ok {
+		return jobDetails{}, false
+	}
+	h, ok := t.headers[header]
+	if !ok {
+		return jobDetails{}, false
+	}
+	return newJobDetails(header, h.seed, h.height), true
+}
+
+func (t *BlockTemplate) currentJob() jobDetails {
+	return newJobDetails(t.Header, t.Seed, t.Height)
+}
+
+func (s *ProxyServer) currentJob() (jobDetails, *ErrorReply) {
+	t := s.currentBlockTemplate()
+	if t == nil || len(t.Header) == 0 || s.isSick() {
+		return jobDetails{}, &ErrorReply{Code: 0, Message: "Work not ready"}
+	}
+	return t.currentJob(), nil
+}
+
+func (s *ProxyServer) lookupJob(id string) (jobDetails, bool) {
+	t := s.currentBlockTemplate()
+	if t == nil {
+		return jobDetails{}, false
+	}
+	return t.job(id)
+}
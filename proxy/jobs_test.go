@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"sync"
+	"testing"
+)
+
+func TestJobID(t *testing.T) {
+	header := "0x9a8ec0b8ee3c1a5f26d7a4d4a2a3b0a6fd0a31a8bf2c5f1d11e2e3a8c3b8d0e1"
+	if id := jobID(header); id != "9a8ec0b8ee3c1a5f" {
+		t.Errorf("Must take leading header bytes, got %s", id)
+	}
+	if jobID(header) != jobID(header[2:]) {
+		t.Error("Must not depend on 0x prefix")
+	}
+}
+
+func TestBlockTemplateJob(t *testing.T) {
+	current := "0x1111111111111111111111111111111111111111111111111111111111111111"
+	backlog := "0x2222222222222222222222222222222222222222222222222222222222222222"
+	tpl := &BlockTemplate{
+		Header: current,
+		Seed:   "0xaaaa",
+		Height: 100,
+		headers: map[string]heightDiffPair{
+			current: {diff: big.NewInt(1), height: 100, seed: "0xaaaa"},
+			backlog: {diff: big.NewInt(1), height: 99, seed: "0xaaaa"},
+		},
+		jobs: map[string]string{jobID(current): current, jobID(backlog): backlog},
+	}
+
+	job := tpl.currentJob()
+	if job.JobID != jobID(current) || job.HeaderHash != current[2:] || job.SeedHash != "aaaa" || job.Height != "64" {
+		t.Errorf("Wrong current job %+v", job)
+	}
+	job, ok := tpl.job(jobID(backlog))
+	if !ok || job.HeaderHash != backlog[2:] || job.Height != "63" {
+		t.Errorf("Must resolve backlog job, got %+v", job)
+	}
+	if _, ok = tpl.job("0123456789abcdef"); ok {
+		t.Error("Unknown job must be stale")
+	}
+}
+
+// Job requested by miner must not race with broadcast, run with -race
+func TestSendJobConcurrentPush(t *testing.T) {
+	header := "0x1111111111111111111111111111111111111111111111111111111111111111"
+	tpl := &BlockTemplate{
+		Header:  header,
+		Seed:    "0xaaaa",
+		Height:  100,
+		headers: map[string]heightDiffPair{header: {diff: big.NewInt(1), height: 100, seed: "0xaaaa"}},
+		jobs:    map[string]string{jobID(header): header},
+	}
+	s := &ProxyServer{config: &Config{}}
+	s.blockTemplate.Store(tpl)
+	cs := &Session{enc: json.NewEncoder(ioutil.Discard), stratum: Stratum2, diff: 1}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			cs.pushNewJob(tpl)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if err := cs.sendJob(s, nil, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+	if cs.currentJobID() != jobID(header) {
+		t.Errorf("Must keep current job, got %s", cs.currentJobID())
+	}
+}
@@ -6,38 +6,17 @@ import (
 	"strings"
 
 	"github.com/etclabscore/core-pool/library/logger"
-	"github.com/etclabscore/core-pool/payouts"
 	"github.com/etclabscore/core-pool/util"
 
-	"github.com/etclabscore/go-etchash"
 	"github.com/ethereum/go-ethereum/common"
 )
 
-var (
-	maxUint256                             = new(big.Int).Exp(big.NewInt(2), big.NewInt(256), big.NewInt(0))
-	ecip1099FBlockClassic uint64           = 11700000 // classic mainnet
-	ecip1099FBlockMordor  uint64           = 2520000  // mordor
-	uip1FEpoch            uint64           = 22       // ubiq mainnet
-	hasher                *etchash.Etchash = nil
-)
+var maxUint256 = new(big.Int).Exp(big.NewInt(2), big.NewInt(256), big.NewInt(0))
 
-func (s *ProxyServer) processShare(login, id, ip string, t *BlockTemplate, params []string, stratum bool) (bool, bool) {
-	if hasher == nil {
-		switch s.config.Network {
-		case payouts.EtcNetwork:
-			hasher = etchash.New(&ecip1099FBlockClassic, nil)
-		case payouts.MordorNetwork:
-			hasher = etchash.New(&ecip1099FBlockMordor, nil)
-		case payouts.UbiqNetwork:
-			hasher = etchash.New(nil, &uip1FEpoch)
-		case payouts.EthNetwork, payouts.RopstenNetwork:
-			hasher = etchash.New(&ecip1099FBlockClassic, nil)
-		default:
-			// unknown network
-			logger.Error("Unknown network configuration %s", s.config.Network)
-			return false, false
-		}
-	}
+func (s *ProxyServer) processShare(cs *Session, login, id string, t *BlockTemplate, params []string, stratum bool) (bool, bool) {
+	ip := cs.ip
+
+	hasher := s.chain.Hasher()
 
 	if len(params) != 3 {
 		logger.Warn("Shared length params must be 3, params: %v", params)
@@ -45,7 +24,7 @@ func (s *ProxyServer) processShare(login, id, ip string, t *BlockTemplate, param
 	}
 	nonceHex, hashNoNonce, mixDigest := params[0], params[1], params[2]
 	nonce, _ := strconv.ParseUint(strings.Replace(nonceHex, "0x", "", -1), 16, 64)
-	shareDiff := s.config.Proxy.Difficulty
+	shareDiff := cs.difficulty()
 
 	var result common.Hash
 	if stratum {
@@ -85,24 +64,29 @@ func (s *ProxyServer) processShare(login, id, ip string, t *BlockTemplate, param
 
 	logger.Debug("Difficulty pool/block/share = %d / %d / %d(%f) from %v@%v", shareDiff, t.Difficulty, shareDiffCalc, shareDiffFloat, login, ip)
 
-	// check share difficulty
+	// check share difficulty, fall back to previous one right after retarget
 	shareTarget := new(big.Int).Div(maxUint256, big.NewInt(shareDiff))
 	if result.Big().Cmp(shareTarget) > 0 {
-		return false, false
+		prevDiff := cs.previousDifficulty()
+		if prevDiff == 0 {
+			return false, false
+		}
+		prevTarget := new(big.Int).Div(maxUint256, big.NewInt(prevDiff))
+		if result.Big().Cmp(prevTarget) > 0 {
+			return false, false
+		}
+		shareDiff = prevDiff
 	}
 
 	// check target difficulty
 	target := new(big.Int).Div(maxUint256, big.NewInt(h.diff.Int64()))
 	if result.Big().Cmp(target) <= 0 {
-		ok, err := s.rpc().SubmitBlock(params)
-		if err != nil {
-			logger.Error("Block submission failure at height %v for %v: %v", h.height, t.Header, err)
-		} else if !ok {
-			logger.Error("Block rejected at height %v for %v", h.height, t.Header)
+		accepted, rejected := s.submitBlock(params, h.height)
+		if rejected {
 			return false, false
-		} else {
+		} else if accepted {
 			s.fetchBlockTemplate()
-			exist, err := s.backend.WriteBlock(login, id, params, shareDiff, h.diff.Int64(), h.height, s.hashrateExpiration)
+			exist, err := s.writeBlock(cs, login, id, params, shareDiff, h)
 			if exist {
 				return true, false
 			}
@@ -111,10 +95,14 @@ func (s *ProxyServer) processShare(login, id, ip string, t *BlockTemplate, param
 			} else {
 				logger.Info("Inserted block %v to backend", h.height)
 			}
-			logger.Info("Block found by miner %v@%v at height %d", login, ip, h.height)
+			if cs.isSolo() {
+				logger.Info("Solo block found by miner %v@%v at height %d", login, ip, h.height)
+			} else {
+				logger.Info("Block found by miner %v@%v at height %d", login, ip, h.height)
+			}
 		}
 	} else {
-		exist, err := s.backend.WriteShare(login, id, params, shareDiff, h.height, s.hashrateExpiration)
+		exist, err := s.writeShare(cs, login, id, params, shareDiff, h)
 		if exist {
 			return true, false
 		}
@@ -124,3 +112,18 @@ func (s *ProxyServer) processShare(login, id, ip string, t *BlockTemplate, param
 	}
 	return false, true
 }
+
+// Solo sessions write to their own solo round
+func (s *ProxyServer) writeShare(cs *Session, login, id string, params []string, shareDiff int64, h heightDiffPair) (bool, error) {
+	if cs.isSolo() {
+		return s.backend.WriteSoloShare(login, id, params, shareDiff, h.height, s.hashrateExpiration)
+	}
+	return s.backend.WriteShare(login, id, params, shareDiff, h.height, s.hashrateExpiration, s.shareCredit(login, shareDiff, h))
+}
+
+func (s *ProxyServer) writeBlock(cs *Session, login, id string, params []string, shareDiff int64, h heightDiffPair) (bool, error) {
+	if cs.isSolo() {
+		return s.backend.WriteSoloBlock(login, id, params, shareDiff, h.diff.Int64(), h.height, s.hashrateExpiration)
+	}
+	return s.backend.WriteBlock(login, id, params, shareDiff, h.diff.Int64(), h.height, s.hashrateExpiration, s.shareCredit(login, shareDiff, h))
+}
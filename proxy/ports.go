@@ -0,0 +1,174 @@
+package proxy
+
+import (
+	"fmt"
+	"math/bits"
+	"sync/atomic"
+)
+
+var protocolNames = map[string]int{
+	"EthProxy": EthProxy,
+	"NiceHash": NiceHash,
+	"Stratum2": Stratum2,
+}
+
+// stratumPort is a resolved port definition with its own connection count
+// and extranonce space
+type stratumPort struct {
+	config      StratumPort
+	difficulty  int64
+	vardiff     *VarDiff
+	protocols   map[int]bool
+	extranonces *extranonceAllocator
+	slots       chan struct{}
+	conns       int64
+}
+
+type portStats struct {
+	Name       string   `json:"name"`
+	Listen     string   `json:"listen"`
+	TLS        bool     `json:"tls"`
+	WebSocket  bool     `json:"websocket"`
+	Difficulty int64    `json:"difficulty"`
+	VarDiff    bool     `json:"varDiff"`
+	MaxConn    int      `json:"maxConn"`
+	Protocols  []string `json:"protocols"`
+	Solo       bool     `json:"solo"`
+	Extranonce int      `json:"extranonceSize"`
+	Conns      int64    `json:"conns"`
+}
+
+// Without port list single port is made of legacy stratum settings.
+// Extranonces of every port start with instance id followed by port index,
+// so ports of any width never give out overlapping nonce ranges.
+func newStratumPorts(cfg *Proxy) ([]*stratumPort, error) {
+	defs := cfg.Stratum.Ports
+	if len(defs) == 0 {
+		defs = []StratumPort{{
+			Name:     "default",
+			Listen:   cfg.Stratum.Listen,
+			TLS:      cfg.Stratum.TLS,
+			CertFile: cfg.Stratum.CertFile,
+			KeyFile:  cfg.Stratum.KeyFile,
+			MaxConn:  cfg.Stratum.MaxConn,
+		}}
+	}
+
+	portBits := uint(bits.Len(uint(len(defs) - 1)))
+	ports := make([]*stratumPort, 0, len(defs))
+	listens := make(map[string]bool)
+	for i, def := range defs {
+		if len(def.Listen) == 0 {
+			return nil, fmt.Errorf("port #%d has no listen address", i)
+		}
+		if listens[def.Listen] {
+			return nil, fmt.Errorf("port %s is defined twice", def.Listen)
+		}
+		listens[def.Listen] = true
+		if len(def.Name) == 0 {
+			def.Name = def.Listen
+		}
+		if def.MaxConn <= 0 {
+			def.MaxConn = cfg.Stratum.MaxConn
+		}
+		if def.MaxConn <= 0 {
+			return nil, fmt.Errorf("port %s: maxConn must be positive", def.Name)
+		}
+
+		if def.WebSocket && len(def.Path) == 0 {
+			def.Path = "/"
+		}
+		if def.ExtranonceSize == 0 {
+			def.ExtranonceSize = cfg.Stratum.ExtranonceSize
+		}
+		if def.ExtranonceSize == 0 {
+			def.ExtranonceSize = defaultExtranonceSize
+		}
+
+		port := &stratumPort{config: def, difficulty: def.Difficulty, vardiff: def.VarDiff}
+		port.slots = make(chan struct{}, def.MaxConn)
+		if port.difficulty <= 0 {
+			port.difficulty = cfg.Difficulty
+		}
+		if port.vardiff == nil {
+			port.vardiff = &cfg.VarDiff
+		}
+		if err := validateVarDiff(port.vardiff); err != nil {
+			return nil, fmt.Errorf("port %s: %v", def.Name, err)
+		}
+		var err error
+		partition := cfg.InstanceId<<portBits | uint64(i)
+		port.extranonces, err = newExtranonceAllocator(def.ExtranonceSize, partition, cfg.InstanceBits+portBits)
+		if err != nil {
+			return nil, fmt.Errorf("port %s: %v", def.Name, err)
+		}
+
+		port.protocols = make(map[int]bool)
+		for _, name := range def.Protocols {
+			mode, ok := protocolNames[name]
+			if !ok {
+				return nil, fmt.Errorf("port %s: unknown protocol %q", def.Name, name)
+			}
+			port.protocols[mode] = true
+		}
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
+
+func validateVarDiff(vd *VarDiff) error {
+	if !vd.Enabled {
+		return nil
+	}
+	if vd.MinSharesPerMin <= 0 || vd.MaxSharesPerMin < vd.MinSharesPerMin {
+		return fmt.Errorf("invalid vardiff shares per minute window: %v - %v", vd.MinSharesPerMin, vd.MaxSharesPerMin)
+	}
+	if vd.MaxDiff > 0 && vd.MaxDiff < vd.MinDiff {
+		return fmt.Errorf("invalid vardiff difficulty bounds: %v - %v", vd.MinDiff, vd.MaxDiff)
+	}
+	return nil
+}
+
+// Empty protocol list allows every dialect
+func (p *stratumPort) allows(mode int) bool {
+	return len(p.protocols) == 0 || p.protocols[mode]
+}
+
+// acquire blocks while port is at max connections
+func (p *stratumPort) acquire() {
+	p.slots <- struct{}{}
+	atomic.AddInt64(&p.conns, 1)
+}
+
+func (p *stratumPort) release() {
+	atomic.AddInt64(&p.conns, -1)
+	<-p.slots
+}
+
+func (p *stratumPort) stats() portStats {
+	protocols := p.config.Protocols
+	if len(protocols) == 0 {
+		protocols = []string{"EthProxy", "NiceHash", "Stratum2"}
+	}
+	return portStats{
+		Name:       p.config.Name,
+		Listen:     p.config.Listen,
+		TLS:        p.config.TLS,
+		WebSocket:  p.config.WebSocket,
+		Difficulty: p.difficulty,
+		VarDiff:    p.vardiff.Enabled,
+		MaxConn:    p.config.MaxConn,
+		Protocols:  protocols,
+		Solo:       p.config.Solo,
+		Extranonce: p.config.ExtranonceSize,
+		Conns:      atomic.LoadInt64(&p.conns),
+	}
+}
+
+func (s *ProxyServer) portsStats() []portStats {
+	stats := make([]portStats, 0, len(s.ports))
+	for _, p := range s.ports {
+		stats = append(stats, p.stats())
+	}
+	return stats
+}
@@ -0,0 +1,157 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewStratumPortsLegacy(t *testing.T) {
+	cfg := &Proxy{Difficulty: 2000000000, Stratum: Stratum{Listen: "0.0.0.0:8008", MaxConn: 100}}
+	ports, err := newStratumPorts(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ports) != 1 || ports[0].config.Listen != "0.0.0.0:8008" || ports[0].config.MaxConn != 100 {
+		t.Fatalf("Must make single port of stratum settings, got %+v", ports)
+	}
+	if ports[0].difficulty != cfg.Difficulty {
+		t.Errorf("Must use proxy difficulty, got %v", ports[0].difficulty)
+	}
+	for _, mode := range []int{EthProxy, NiceHash, Stratum2} {
+		if !ports[0].allows(mode) {
+			t.Errorf("Must allow protocol %v", mode)
+		}
+	}
+}
+
+func TestNewStratumPorts(t *testing.T) {
+	cfg := &Proxy{
+		Difficulty: 2000000000,
+		VarDiff:    VarDiff{Enabled: true, MinSharesPerMin: 4, MaxSharesPerMin: 12, RetargetTime: "90s"},
+		Stratum: Stratum{MaxConn: 100, Ports: []StratumPort{
+			{Name: "low", Listen: "0.0.0.0:8008"},
+			{Name: "high", Listen: "0.0.0.0:8009", Difficulty: 8000000000, MaxConn: 10, VarDiff: &VarDiff{}, Protocols: []string{"NiceHash"}},
+		}},
+	}
+	ports, err := newStratumPorts(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	low, high := ports[0], ports[1]
+	if !low.vardiff.Enabled || low.config.MaxConn != 100 {
+		t.Error("Must inherit proxy vardiff and max connections")
+	}
+	if high.vardiff.Enabled || high.difficulty != 8000000000 || high.config.MaxConn != 10 {
+		t.Error("Must use own port settings")
+	}
+	if high.allows(EthProxy) || !high.allows(NiceHash) {
+		t.Error("Must allow listed protocols only")
+	}
+
+	cfg.Stratum.Ports[1].Listen = "0.0.0.0:8008"
+	if _, err = newStratumPorts(cfg); err == nil {
+		t.Error("Must reject duplicate listen address")
+	}
+	cfg.Stratum.Ports[1].Listen = "0.0.0.0:8009"
+	cfg.Stratum.Ports[1].Protocols = []string{"Stratum3"}
+	if _, err = newStratumPorts(cfg); err == nil {
+		t.Error("Must reject unknown protocol")
+	}
+	cfg.Stratum.Ports[1].Protocols = nil
+	cfg.Stratum.MaxConn = 0
+	if _, err = newStratumPorts(cfg); err == nil {
+		t.Error("Must reject port without max connections")
+	}
+}
+
+func TestStratumPortExtranonces(t *testing.T) {
+	cfg := &Proxy{
+		InstanceId:   1,
+		InstanceBits: 1,
+		Stratum: Stratum{MaxConn: 100, ExtranonceSize: 2, Ports: []StratumPort{
+			{Name: "small", Listen: "0.0.0.0:8008", ExtranonceSize: 1},
+			{Name: "default", Listen: "0.0.0.0:8009"},
+			{Name: "large", Listen: "0.0.0.0:8010", ExtranonceSize: 3},
+		}},
+	}
+	ports, err := newStratumPorts(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Instance bit followed by two port index bits
+	for i, want := range []string{"80", "a000", "c00000"} {
+		extranonce, err := ports[i].extranonces.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if extranonce != want {
+			t.Errorf("Port %s must allocate %s, got %s", ports[i].config.Name, want, extranonce)
+		}
+		if ports[i].stats().Extranonce != len(want)/2 {
+			t.Errorf("Port %s must report extranonce size %d", ports[i].config.Name, len(want)/2)
+		}
+	}
+	// Foreign extranonce is not released by another port
+	ports[0].extranonces.Release("a000")
+	if ports[1].extranonces.InUse() != 1 {
+		t.Error("Extranonce must be released by own port only")
+	}
+
+	cfg.InstanceBits = 7
+	if _, err = newStratumPorts(cfg); err == nil {
+		t.Error("Must reject port without room for extranonces")
+	}
+}
+
+func TestParseSoloLogin(t *testing.T) {
+	s := &ProxyServer{config: &Config{Proxy: Proxy{Solo: Solo{LoginPrefix: "solo:"}}}}
+	shared := &Session{port: &stratumPort{config: StratumPort{}}}
+	soloPort := &Session{port: &stratumPort{config: StratumPort{Solo: true}}}
+
+	tests := []struct {
+		cs    *Session
+		login string
+		want  string
+		solo  bool
+	}{
+		{shared, "0xb85150eb365e7df0941f0cf08235f987ba91506a", "0xb85150eb365e7df0941f0cf08235f987ba91506a", false},
+		{shared, "solo:0xb85150eb365e7df0941f0cf08235f987ba91506a+4000", "0xb85150eb365e7df0941f0cf08235f987ba91506a+4000", true},
+		{soloPort, "0xb85150eb365e7df0941f0cf08235f987ba91506a", "0xb85150eb365e7df0941f0cf08235f987ba91506a", true},
+		{&Session{}, "0xb85150eb365e7df0941f0cf08235f987ba91506a", "0xb85150eb365e7df0941f0cf08235f987ba91506a", false},
+	}
+	for _, tt := range tests {
+		login, solo := s.parseSoloLogin(tt.cs, tt.login)
+		if login != tt.want || solo != tt.solo {
+			t.Errorf("parseSoloLogin(%q) = %q, %v, want %q, %v", tt.login, login, solo, tt.want, tt.solo)
+		}
+	}
+
+	s.config.Proxy.Solo.LoginPrefix = ""
+	if login, solo := s.parseSoloLogin(shared, "solo:0x0"); solo || login != "solo:0x0" {
+		t.Error("Login prefix must be disabled when empty")
+	}
+}
+
+func TestStratumPortSlots(t *testing.T) {
+	port := &stratumPort{vardiff: &VarDiff{}, slots: make(chan struct{}, 1)}
+	port.acquire()
+	if port.stats().Conns != 1 {
+		t.Fatal("Acquired slot must be counted as connection")
+	}
+	acquired := make(chan struct{})
+	go func() {
+		port.acquire()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("Full port must block new connection")
+	case <-time.After(50 * time.Millisecond):
+	}
+	port.release()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("Released slot must admit waiting connection")
+	}
+}
@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"math/big"
+	"sync/atomic"
+
+	"github.com/etclabscore/core-pool/library/logger"
+	"github.com/etclabscore/core-pool/payouts"
+)
+
+// Pay-per-share credits, paid to miner balance together with the share
+type ppsCredits struct {
+	scheme string
+	fee    float64
+	// Average tx fees of recent matured blocks in Wei, FPPS only
+	fees atomic.Value
+	// Fee overrides by login, refreshed with the fees
+	minerFees atomic.Value
+}
+
+func newPPSCredits(cfg *payouts.UnlockerConfig) *ppsCredits {
+	if !payouts.IsPPS(cfg.RewardScheme) {
+		return nil
+	}
+	p := &ppsCredits{scheme: cfg.RewardScheme, fee: cfg.PoolFee}
+	p.fees.Store(new(big.Int))
+	p.minerFees.Store(make(map[string]float64))
+	return p
+}
+
+// Credit in Shannon for a share of given difficulty
+func (s *ProxyServer) shareCredit(login string, shareDiff int64, h heightDiffPair) int64 {
+	if s.pps == nil {
+		return 0
+	}
+	var fees *big.Int
+	if s.pps.scheme == payouts.SchemeFPPS {
+		fees = s.pps.fees.Load().(*big.Int)
+	}
+	fee, ok := s.pps.minerFees.Load().(map[string]float64)[login]
+	if !ok {
+		fee = s.pps.fee
+	}
+	return payouts.PPSCredit(s.chain.BlockReward(int64(h.height)), fees, shareDiff, h.diff, fee)
+}
+
+func (s *ProxyServer) refreshPPSFees() {
+	if s.pps == nil {
+		return
+	}
+	minerFees, err := s.backend.GetMinerFees()
+	if err != nil {
+		logger.Error("Failed to get miner fees from backend: %v", err)
+	} else {
+		s.pps.minerFees.Store(minerFees)
+	}
+	if s.pps.scheme != payouts.SchemeFPPS {
+		return
+	}
+	fees, err := s.backend.GetAverageBlockFees()
+	if err != nil {
+		logger.Error("Failed to get average block fees from backend: %v", err)
+		return
+	}
+	s.pps.fees.Store(fees)
+}
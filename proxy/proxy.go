@@ -10,8 +10,12 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/etclabscore/core-pool/chain"
 	"github.com/etclabscore/core-pool/common"
+	"github.com/etclabscore/core-pool/library/clean"
 	"github.com/etclabscore/core-pool/library/logger"
+	"github.com/etclabscore/core-pool/library/proxyproto"
+	"github.com/etclabscore/core-pool/payouts"
 	"github.com/etclabscore/core-pool/policy"
 	"github.com/etclabscore/core-pool/rpc"
 	"github.com/etclabscore/core-pool/storage"
@@ -22,23 +26,53 @@ import (
 
 type ProxyServer struct {
 	config             *Config
+	chain              chain.ChainSpec
 	blockTemplate      atomic.Value
 	upstream           int32
 	upstreams          []*rpc.RPCClient
 	backend            *storage.RedisClient
-	diff               string
 	policy             *policy.PolicyServer
 	hashrateExpiration time.Duration
 	failsCount         int64
+	templateMu         sync.Mutex
+	selection          upstreamSelection
+	submits            submitStats
+	pps                *ppsCredits
+	subscribed         int32
+	upstreamSwitch     chan struct{}
+	trustedProxies     []*net.IPNet
+	reverseProxies     []*net.IPNet
+	proxyHeaderTimeout time.Duration
 
 	// Stratum
 	sessionsMu sync.RWMutex
-	sessions   map[*Session]struct{}
-	timeout    time.Duration
-	// Extranonce
-	Extranonces map[string]bool
+	// Authorized sessions by id
+	sessions    map[uint64]*Session
+	sessionSeq  uint64
+	timeout     time.Duration
+	ports       []*stratumPort
+	broadcaster *broadcaster
+	// EthereumStratum/2.0.0 sessions waiting for resume
+	resumable *sessionStore
+	// Drain
+	listenersMu sync.Mutex
+	listeners   []net.Listener
+	draining    int32
+	inflight    int64
+	// Every open stratum connection, authorized or not
+	connsMu sync.Mutex
+	conns   map[net.Conn]struct{}
 }
 
+const (
+	defaultProxyHeaderTimeout = 3 * time.Second
+	// Shares of jobs issued before retarget are accepted at previous difficulty this long
+	previousDiffGrace = 30 * time.Second
+)
+
+// Reverse proxy on the same host is trusted unless reverseProxies are set
+var defaultReverseProxies = []string{"127.0.0.1", "::1"}
+
 type jobDetails struct {
 	JobID      string
 	SeedHash   string
@@ -46,52 +80,155 @@ type jobDetails struct {
 	Height     string
 }
 
-type staleJob struct {
-	SeedHash   string
-	HeaderHash string
-}
-
 type Session struct {
+	id  uint64
 	ip  string
 	enc *json.Encoder
 
 	// Stratum
 	sync.Mutex
-	conn  net.Conn
-	login string
+	conn net.Conn
+	out  outbound
+	port *stratumPort
+	// Guarded by session lock, miner may authorize again
+	login   string
+	worker  string
+	solo    bool
+	removed bool
 
 	stratum        int
 	subscriptionID string
 	Extranonce     string
 	ExtranonceSub  bool
 	JobDetails     jobDetails
-	staleJobs      map[string]staleJob
-	staleJobIDs    []string
+
+	// Difficulty, previous one is still accepted for a while after retarget
+	diff          int64
+	prevDiff      int64
+	prevDiffUntil int64
+	// Guarded by session lock, static difficulty turns vardiff off at any time
+	fixedDiff bool
+	vardiff   *vardiff
+
+	connectedAt int64
+	lastShareAt int64
 }
 
-func NewProxy(cfg *Config, backend *storage.RedisClient) *ProxyServer {
+func NewProxy(cfg *Config, backend *storage.RedisClient, spec chain.ChainSpec) *ProxyServer {
 	if len(cfg.Name) == 0 {
 		logger.Fatal("You must set instance name")
 	}
 	policy := policy.Start(&cfg.Proxy.Policy, backend)
 
-	proxy := &ProxyServer{config: cfg, backend: backend, policy: policy}
-	proxy.diff = util.GetTargetHex(cfg.Proxy.Difficulty)
+	proxy := &ProxyServer{config: cfg, backend: backend, policy: policy, chain: spec}
+
+	if cfg.BlockUnlocker.RewardScheme == payouts.SchemePPLNS {
+		backend.EnableShareLog()
+		logger.Info("Writing shares to PPLNS share log")
+	}
+	if cfg.BlockUnlocker.RewardScheme == payouts.SchemeScore {
+		decay := payouts.ScoreDecay(&cfg.BlockUnlocker)
+		backend.EnableShareScores(decay)
+		logger.Info("Writing share scores, decay %v", decay)
+	}
+	proxy.pps = newPPSCredits(&cfg.BlockUnlocker)
+	if proxy.pps != nil {
+		proxy.refreshPPSFees()
+		logger.Info("Crediting shares with %s, pool fee %v%%", cfg.BlockUnlocker.RewardScheme, cfg.BlockUnlocker.PoolFee)
+	}
+
+	if vd := cfg.Proxy.VarDiff; vd.Enabled {
+		if err := validateVarDiff(&vd); err != nil {
+			logger.Fatal("Invalid vardiff config: %v", err)
+		}
+		logger.Info("Vardiff enabled, target %v - %v shares per minute", vd.MinSharesPerMin, vd.MaxSharesPerMin)
+	}
+
+	if cfg.Proxy.BehindReverseProxy {
+		list := cfg.Proxy.ReverseProxies
+		if len(list) == 0 {
+			list = defaultReverseProxies
+		}
+		var err error
+		proxy.reverseProxies, err = proxyproto.ParseCIDRs(list)
+		if err != nil {
+			logger.Fatal("Invalid reverse proxies: %v", err)
+		}
+		logger.Info("X-Forwarded-For accepted from %v", list)
+	}
+
+	if pp := cfg.Proxy.ProxyProtocol; pp.Stratum || pp.Http {
+		var err error
+		proxy.trustedProxies, err = proxyproto.ParseCIDRs(pp.TrustedProxies)
+		if err != nil {
+			logger.Fatal("Invalid PROXY protocol trusted sources: %v", err)
+		}
+		if len(proxy.trustedProxies) == 0 {
+			logger.Fatal("You must set PROXY protocol trusted sources")
+		}
+		proxy.proxyHeaderTimeout = defaultProxyHeaderTimeout
+		if len(pp.HeaderTimeout) > 0 {
+			proxy.proxyHeaderTimeout = util.MustParseDuration(pp.HeaderTimeout)
+		}
+		logger.Info("PROXY protocol accepted from %v", pp.TrustedProxies)
+	}
 
 	proxy.upstreams = make([]*rpc.RPCClient, len(cfg.Upstream))
+	proxy.upstreamSwitch = make(chan struct{}, 1)
 	for i, v := range cfg.Upstream {
-		proxy.upstreams[i] = rpc.NewRPCClient(v.Name, v.Url, v.Timeout)
+		var err error
+		proxy.upstreams[i], err = rpc.NewClient(v.Name, v.Url, v.Timeout, v.Auth, v.CallPolicy)
+		if err != nil {
+			logger.Fatal("Failed to setup upstream %s: %v", v.Name, err)
+		}
 		logger.Info("Upstream: %s => %s", v.Name, v.Url)
 	}
 	logger.Info("Default upstream: %s => %s", proxy.rpc().Name, proxy.rpc().Url)
 
 	if cfg.Proxy.Stratum.Enabled {
-		proxy.sessions = make(map[*Session]struct{})
-		proxy.Extranonces = make(map[string]bool)
+		proxy.sessions = make(map[uint64]*Session)
+		var err error
+		proxy.ports, err = newStratumPorts(&cfg.Proxy)
+		if err != nil {
+			logger.Fatal("Invalid stratum ports config: %v", err)
+		}
+		proxy.broadcaster = newBroadcaster(proxy, &cfg.Proxy.Broadcast)
+		proxy.broadcaster.start()
+		if len(cfg.Proxy.Stratum.ResumeTimeout) > 0 {
+			resumeIntv := util.MustParseDuration(cfg.Proxy.Stratum.ResumeTimeout)
+			proxy.resumable = newSessionStore(resumeIntv)
+			expireTimer := time.NewTimer(resumeIntv)
+			logger.Info("Stratum sessions can be resumed within %v", resumeIntv)
+
+			common.RoutineGroup.GoRecover(func() error {
+				for {
+					select {
+					case <-common.RoutineCtx.Done():
+						logger.Info("Stopping parked sessions expire worker")
+						return nil
+					case <-expireTimer.C:
+						proxy.expireParkedSessions()
+						expireTimer.Reset(resumeIntv)
+					}
+				}
+			})
+		}
 		common.RoutineGroup.GoRecover(func() error {
 			proxy.ListenTCP()
 			return nil
 		})
+		// Runs before routine context is cancelled, clean chain is LIFO
+		clean.PushFunc(func() error {
+			proxy.Drain(cfg.Proxy.Stratum.ReconnectHost, cfg.Proxy.Stratum.ReconnectPort)
+			return nil
+		})
+	}
+
+	if cfg.Proxy.Admin.Enabled {
+		common.RoutineGroup.GoRecover(func() error {
+			proxy.listenAdmin()
+			return nil
+		})
 	}
 
 	proxy.fetchBlockTemplate()
@@ -102,6 +239,15 @@ func NewProxy(cfg *Config, backend *storage.RedisClient) *ProxyServer {
 	refreshTimer := time.NewTimer(refreshIntv)
 	logger.Info("Set block refresh every %v", refreshIntv)
 
+	// Poll less often while node pushes new work
+	pollIntv := refreshIntv
+	if cfg.Proxy.BlockSubscribe.Enabled {
+		if len(cfg.Proxy.BlockSubscribe.PollInterval) > 0 {
+			pollIntv = util.MustParseDuration(cfg.Proxy.BlockSubscribe.PollInterval)
+		}
+		proxy.subscribeTemplates()
+	}
+
 	checkIntv := util.MustParseDuration(cfg.UpstreamCheckInterval)
 	checkTimer := time.NewTimer(checkIntv)
 
@@ -116,7 +262,11 @@ func NewProxy(cfg *Config, backend *storage.RedisClient) *ProxyServer {
 				return nil
 			case <-refreshTimer.C:
 				proxy.fetchBlockTemplate()
-				refreshTimer.Reset(refreshIntv)
+				if proxy.isSubscribed() {
+					refreshTimer.Reset(pollIntv)
+				} else {
+					refreshTimer.Reset(refreshIntv)
+				}
 			}
 		}
 	})
@@ -150,6 +300,11 @@ func NewProxy(cfg *Config, backend *storage.RedisClient) *ProxyServer {
 					} else {
 						proxy.markOk()
 					}
+					err = backend.WriteUpstreamStates(cfg.Name, proxy.upstreamStates())
+					if err != nil {
+						logger.Error("Failed to write upstream states to backend: %v", err)
+					}
+					proxy.refreshPPSFees()
 				}
 				stateUpdateTimer.Reset(stateUpdateIntv)
 			}
@@ -169,10 +324,22 @@ func (s *ProxyServer) Start() {
 		Handler:        r,
 		MaxHeaderBytes: s.config.Proxy.LimitHeadersSize,
 	}
-	err := srv.ListenAndServe()
+	l, err := net.Listen("tcp", s.config.Proxy.Listen)
 	if err != nil {
 		logger.Fatal("Failed to start proxy: %v", err)
 	}
+	if s.config.Proxy.ProxyProtocol.Http {
+		l = s.proxyProtocolListener(l)
+	}
+	err = srv.Serve(l)
+	if err != nil {
+		logger.Fatal("Failed to start proxy: %v", err)
+	}
+}
+
+// Remote address of accepted connections is taken from PROXY protocol header
+func (s *ProxyServer) proxyProtocolListener(l net.Listener) net.Listener {
+	return proxyproto.NewListener(l, s.trustedProxies, s.proxyHeaderTimeout)
 }
 
 func (s *ProxyServer) rpc() *rpc.RPCClient {
@@ -180,23 +347,6 @@ func (s *ProxyServer) rpc() *rpc.RPCClient {
 	return s.upstreams[i]
 }
 
-func (s *ProxyServer) checkUpstreams() {
-	candidate := int32(0)
-	backup := false
-
-	for i, v := range s.upstreams {
-		if v.Check() && !backup {
-			candidate = int32(i)
-			backup = true
-		}
-	}
-
-	if s.upstream != candidate {
-		logger.Info("Switching to upstream %v wallet", s.upstreams[candidate].Name)
-		atomic.StoreInt32(&s.upstream, candidate)
-	}
-}
-
 func (s *ProxyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		s.writeError(w, 405, "rpc: POST method required, received "+r.Method)
@@ -208,17 +358,40 @@ func (s *ProxyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// X-Forwarded-For is honored only when request comes from reverse proxy,
+// client is the rightmost address that is not one of the proxies
 func (s *ProxyServer) remoteAddr(r *http.Request) string {
-	if s.config.Proxy.BehindReverseProxy {
-		ip := r.Header.Get("X-Forwarded-For")
-		if len(ip) > 0 && net.ParseIP(ip) != nil {
-			return ip
+	ip, _, _ := net.SplitHostPort(r.RemoteAddr)
+	if !s.config.Proxy.BehindReverseProxy || !isReverseProxy(ip, s.reverseProxies) {
+		return ip
+	}
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !isReverseProxy(hop, s.reverseProxies) {
+			break
 		}
 	}
-	ip, _, _ := net.SplitHostPort(r.RemoteAddr)
 	return ip
 }
 
+func isReverseProxy(ip string, proxies []*net.IPNet) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, n := range proxies {
+		if n.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *ProxyServer) handleClient(w http.ResponseWriter, r *http.Request, ip string) {
 	if r.ContentLength > s.config.Proxy.LimitBodySize {
 		logger.Warn("Socket flood from %s", ip)
@@ -229,7 +402,7 @@ func (s *ProxyServer) handleClient(w http.ResponseWriter, r *http.Request, ip st
 	r.Body = http.MaxBytesReader(w, r.Body, s.config.Proxy.LimitBodySize)
 	defer r.Body.Close()
 
-	cs := &Session{ip: ip, enc: json.NewEncoder(w)}
+	cs := &Session{ip: ip, enc: json.NewEncoder(w), diff: s.config.Proxy.Difficulty}
 	dec := json.NewDecoder(r.Body)
 	for {
 		var req JSONRpcReq
@@ -298,13 +471,74 @@ func (cs *Session) handleMessage(s *ProxyServer, r *http.Request, req *JSONRpcRe
 		reply := s.handleGetBlockByNumberRPC()
 		cs.sendResult(req.Id, reply)
 	case "eth_submitHashrate":
-		cs.sendResult(req.Id, true)
+		var params []string
+		if req.Params != nil {
+			err := json.Unmarshal(req.Params, &params)
+			if err != nil {
+				logger.Error("Unable to parse params from %v", cs.ip)
+				s.policy.ApplyMalformedPolicy(cs.ip)
+				break
+			}
+		}
+		reply, errReply := s.handleSubmitHashrateRPC(cs, login, vars["id"], params)
+		if errReply != nil {
+			cs.sendError(req.Id, errReply)
+			break
+		}
+		cs.sendResult(req.Id, reply)
 	default:
 		errReply := s.handleUnknownRPC(cs, req.Method)
 		cs.sendError(req.Id, errReply)
 	}
 }
 
+func (cs *Session) difficulty() int64 {
+	return atomic.LoadInt64(&cs.diff)
+}
+
+func (cs *Session) previousDifficulty() int64 {
+	if util.MakeTimestamp() > atomic.LoadInt64(&cs.prevDiffUntil) {
+		return 0
+	}
+	return atomic.LoadInt64(&cs.prevDiff)
+}
+
+// Keep old difficulty acceptable, miner may still be working on a job issued with it
+func (cs *Session) setDifficulty(diff int64) {
+	atomic.StoreInt64(&cs.prevDiffUntil, util.MakeTimestamp()+int64(previousDiffGrace/time.Millisecond))
+	atomic.StoreInt64(&cs.prevDiff, atomic.SwapInt64(&cs.diff, diff))
+}
+
+func (cs *Session) clearPreviousDifficulty() {
+	atomic.StoreInt64(&cs.prevDiff, 0)
+}
+
+func (cs *Session) varDiff() *vardiff {
+	cs.Lock()
+	defer cs.Unlock()
+	return cs.vardiff
+}
+
+func (cs *Session) isFixedDiff() bool {
+	cs.Lock()
+	defer cs.Unlock()
+	return cs.fixedDiff
+}
+
+// Pin difficulty of the session, vardiff is off from now on
+func (cs *Session) fixDifficulty(diff int64) {
+	cs.Lock()
+	cs.vardiff = nil
+	cs.fixedDiff = true
+	cs.Unlock()
+	cs.setDifficulty(diff)
+	cs.clearPreviousDifficulty()
+}
+
+func (cs *Session) target() string {
+	return util.GetTargetHex(cs.difficulty())
+}
+
 func (cs *Session) sendResult(id json.RawMessage, result interface{}) error {
 	message := JSONRpcResp{Id: id, Version: "2.0", Error: nil, Result: result}
 	return cs.enc.Encode(&message)
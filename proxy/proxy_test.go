@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/etclabscore/core-pool/library/proxyproto"
+)
+
+func TestRemoteAddr(t *testing.T) {
+	proxies, _ := proxyproto.ParseCIDRs([]string{"10.0.0.0/8"})
+	s := &ProxyServer{config: &Config{Proxy: Proxy{BehindReverseProxy: true}}, reverseProxies: proxies}
+
+	tests := []struct {
+		remote string
+		xff    string
+		ip     string
+	}{
+		{"10.0.0.1:1234", "203.0.113.5", "203.0.113.5"},
+		{"10.0.0.1:1234", "198.51.100.1, 203.0.113.5, 10.0.0.2", "203.0.113.5"},
+		{"10.0.0.1:1234", "", "10.0.0.1"},
+		{"10.0.0.1:1234", "garbage", "10.0.0.1"},
+		// Header of untrusted client is spoofed
+		{"198.51.100.7:1234", "203.0.113.5", "198.51.100.7"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.RemoteAddr = tt.remote
+		if len(tt.xff) > 0 {
+			r.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		if ip := s.remoteAddr(r); ip != tt.ip {
+			t.Errorf("remoteAddr(%s, %q) = %s, want %s", tt.remote, tt.xff, ip, tt.ip)
+		}
+	}
+
+	s.config.Proxy.BehindReverseProxy = false
+	r := httptest.NewRequest("POST", "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "203.0.113.5")
+	if ip := s.remoteAddr(r); ip != "10.0.0.1" {
+		t.Errorf("Header must be ignored when not behind reverse proxy, got %s", ip)
+	}
+}
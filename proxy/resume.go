@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/etclabscore/core-pool/library/logger"
+)
+
+// Parked state of a disconnected EthereumStratum/2.0.0 session, see EIP-1571 resume
+type resumeEntry struct {
+	port       *stratumPort
+	extranonce string
+	login      string
+	worker     string
+	solo       bool
+	diff       int64
+	fixedDiff  bool
+	jobDetails jobDetails
+	expireAt   time.Time
+}
+
+type sessionStore struct {
+	sync.Mutex
+	ttl     time.Duration
+	entries map[string]*resumeEntry
+}
+
+func newSessionStore(ttl time.Duration) *sessionStore {
+	return &sessionStore{ttl: ttl, entries: make(map[string]*resumeEntry)}
+}
+
+func (st *sessionStore) save(id string, e *resumeEntry) {
+	st.Lock()
+	defer st.Unlock()
+
+	e.expireAt = time.Now().Add(st.ttl)
+	st.entries[id] = e
+}
+
+// take removes parked session, so it can be resumed only once
+func (st *sessionStore) take(id string) (*resumeEntry, bool) {
+	st.Lock()
+	defer st.Unlock()
+
+	e, ok := st.entries[id]
+	if !ok {
+		return nil, false
+	}
+	delete(st.entries, id)
+	if time.Now().After(e.expireAt) {
+		return e, false
+	}
+	return e, true
+}
+
+// expire drops outdated sessions and returns them, so extranonces can be released
+func (st *sessionStore) expire(now time.Time) []*resumeEntry {
+	st.Lock()
+	defer st.Unlock()
+
+	var expired []*resumeEntry
+	for id, e := range st.entries {
+		if now.After(e.expireAt) {
+			expired = append(expired, e)
+			delete(st.entries, id)
+		}
+	}
+	return expired
+}
+
+func (s *ProxyServer) parkSession(cs *Session) {
+	e := &resumeEntry{
+		port:       cs.port,
+		extranonce: cs.Extranonce,
+		diff:       cs.difficulty(),
+		fixedDiff:  cs.isFixedDiff(),
+	}
+	cs.Lock()
+	e.login, e.worker, e.solo = cs.login, cs.worker, cs.solo
+	e.jobDetails = cs.JobDetails
+	cs.Unlock()
+	s.resumable.save(cs.subscriptionID, e)
+}
+
+// resumeSession restores extranonce, authorization and job cache of a parked session.
+// Extranonce belongs to the port, so session is resumed only on the same port.
+func (s *ProxyServer) resumeSession(cs *Session, id string) bool {
+	if s.resumable == nil {
+		return false
+	}
+	e, ok := s.resumable.take(id)
+	if e != nil && (!ok || e.port != cs.port || !s.policy.ApplyLoginPolicy(e.login, cs.ip)) {
+		e.port.extranonces.Release(e.extranonce)
+		return false
+	}
+	if !ok {
+		return false
+	}
+	// Give back extranonce allocated for this connection
+	cs.port.extranonces.Release(cs.Extranonce)
+
+	cs.Extranonce = e.extranonce
+	cs.subscriptionID = id
+	cs.Lock()
+	cs.login, cs.worker, cs.solo = e.login, e.worker, e.solo
+	cs.JobDetails = e.jobDetails
+	cs.Unlock()
+	if e.fixedDiff {
+		cs.fixDifficulty(e.diff)
+	} else {
+		cs.setDifficulty(e.diff)
+		cs.clearPreviousDifficulty()
+	}
+	s.registerSession(cs)
+	return true
+}
+
+func (s *ProxyServer) expireParkedSessions() {
+	expired := s.resumable.expire(time.Now())
+	for _, e := range expired {
+		e.port.extranonces.Release(e.extranonce)
+	}
+	if len(expired) > 0 {
+		logger.Debug("Expired %d parked stratum sessions", len(expired))
+	}
+}
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/etclabscore/core-pool/library/logger"
+	"github.com/etclabscore/core-pool/policy"
+
+	"go.uber.org/zap"
+)
+
+func newResumeTestServer(ttl time.Duration) *ProxyServer {
+	logger.SugarLogger = zap.NewNop().Sugar()
+	extranonces, _ := newExtranonceAllocator(2, 0, 0)
+	return &ProxyServer{
+		sessions:  make(map[uint64]*Session),
+		ports:     []*stratumPort{{extranonces: extranonces}},
+		resumable: newSessionStore(ttl),
+		policy:    &policy.PolicyServer{},
+	}
+}
+
+// Authorized EthereumStratum/2.0.0 session that disconnects
+func parkTestSession(s *ProxyServer, id string) *Session {
+	cs := &Session{stratum: Stratum2, subscriptionID: id, login: "0xb85150eb365e7df0941f0cf08235f987ba91506a", worker: "rig", diff: 1000, port: s.ports[0]}
+	cs.Extranonce, _ = cs.port.extranonces.Allocate()
+	cs.fixDifficulty(8000000000)
+	s.registerSession(cs)
+	s.removeSession(cs)
+	return cs
+}
+
+func newTestConnection(s *ProxyServer) *Session {
+	cs := &Session{stratum: Stratum2, diff: 1000, port: s.ports[0]}
+	cs.Extranonce, _ = cs.port.extranonces.Allocate()
+	return cs
+}
+
+func TestResumeSession(t *testing.T) {
+	s := newResumeTestServer(time.Minute)
+	parked := parkTestSession(s, "abc")
+	if s.ports[0].extranonces.InUse() != 1 {
+		t.Fatal("Parked session must keep its extranonce")
+	}
+
+	cs := newTestConnection(s)
+	if !s.resumeSession(cs, "abc") {
+		t.Fatal("Session must be resumed by its id")
+	}
+	if cs.Extranonce != parked.Extranonce || cs.difficulty() != 8000000000 || !cs.isFixedDiff() {
+		t.Errorf("Extranonce and difficulty must be restored, got %v %v", cs.Extranonce, cs.difficulty())
+	}
+	if cs.login != parked.login || cs.worker != "rig" || cs.subscriptionID != "abc" {
+		t.Errorf("Authorization must be restored, got %v.%v", cs.login, cs.worker)
+	}
+	if _, ok := s.sessions[cs.id]; !ok {
+		t.Error("Resumed session must be registered")
+	}
+	if s.ports[0].extranonces.InUse() != 1 {
+		t.Errorf("Extranonce of new connection must be released, %d in use", s.ports[0].extranonces.InUse())
+	}
+
+	// Token is consumed by resume
+	again := newTestConnection(s)
+	if s.resumeSession(again, "abc") {
+		t.Error("Session must not be resumed twice")
+	}
+	if s.resumeSession(again, "unknown") {
+		t.Error("Unknown session must not be resumed")
+	}
+}
+
+func TestResumeSessionOtherPort(t *testing.T) {
+	s := newResumeTestServer(time.Minute)
+	other, _ := newExtranonceAllocator(2, 1, 1)
+	s.ports = append(s.ports, &stratumPort{extranonces: other})
+	parkTestSession(s, "abc")
+
+	cs := &Session{stratum: Stratum2, diff: 1000, port: s.ports[1]}
+	cs.Extranonce, _ = other.Allocate()
+	if s.resumeSession(cs, "abc") {
+		t.Fatal("Session must not be resumed on another port")
+	}
+	if s.ports[0].extranonces.InUse() != 0 || other.InUse() != 1 {
+		t.Error("Parked extranonce must be released to its own port")
+	}
+}
+
+func TestResumeExpiredSession(t *testing.T) {
+	s := newResumeTestServer(-time.Second)
+	parkTestSession(s, "abc")
+
+	cs := newTestConnection(s)
+	if s.resumeSession(cs, "abc") {
+		t.Fatal("Expired session must not be resumed")
+	}
+	if cs.login != "" || cs.isFixedDiff() {
+		t.Error("Expired session state must not be restored")
+	}
+	// Only extranonce of new connection is left
+	if s.ports[0].extranonces.InUse() != 1 {
+		t.Errorf("Extranonce of expired session must be released, %d in use", s.ports[0].extranonces.InUse())
+	}
+
+	parkTestSession(s, "def")
+	s.expireParkedSessions()
+	if s.ports[0].extranonces.InUse() != 1 || s.resumeSession(newTestConnection(s), "def") {
+		t.Error("Expired sessions must be dropped by expire worker")
+	}
+}
+
+func TestRandomHex(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		id := randomHex(32)
+		if len(id) != 32 || strings.Trim(id, "0123456789abcdef") != "" || seen[id] {
+			t.Fatalf("Must generate unique hex ids, got %q", id)
+		}
+		seen[id] = true
+	}
+	if id := randomHex(15); len(id) != 15 {
+		t.Errorf("Must generate odd length ids, got %q", id)
+	}
+}
@@ -0,0 +1,202 @@
+package proxy
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/etclabscore/core-pool/library/logger"
+	"github.com/etclabscore/core-pool/util"
+
+	"github.com/gorilla/mux"
+)
+
+type sessionInfo struct {
+	Id          uint64 `json:"id"`
+	Login       string `json:"login"`
+	Worker      string `json:"worker"`
+	Ip          string `json:"ip"`
+	Port        string `json:"port"`
+	Protocol    string `json:"protocol"`
+	Extranonce  string `json:"extranonce"`
+	Difficulty  int64  `json:"difficulty"`
+	FixedDiff   bool   `json:"fixedDiff"`
+	VarDiff     bool   `json:"varDiff"`
+	JobId       string `json:"jobId"`
+	JobHeight   string `json:"jobHeight"`
+	ConnectedAt int64  `json:"connectedAt"`
+	LastShareAt int64  `json:"lastShareAt"`
+}
+
+func protocolName(mode int) string {
+	for name, m := range protocolNames {
+		if m == mode {
+			return name
+		}
+	}
+	return ""
+}
+
+func (cs *Session) info() sessionInfo {
+	cs.Lock()
+	login, worker := cs.login, cs.worker
+	job := cs.JobDetails
+	fixedDiff, varDiff := cs.fixedDiff, cs.vardiff != nil
+	cs.Unlock()
+
+	info := sessionInfo{
+		Id:          cs.id,
+		Login:       login,
+		Worker:      worker,
+		Ip:          cs.ip,
+		Protocol:    protocolName(cs.stratumMode()),
+		Extranonce:  cs.Extranonce,
+		Difficulty:  cs.difficulty(),
+		FixedDiff:   fixedDiff,
+		VarDiff:     varDiff,
+		JobId:       job.JobID,
+		ConnectedAt: cs.connectedAt,
+		LastShareAt: atomic.LoadInt64(&cs.lastShareAt),
+	}
+	if len(job.Height) > 0 {
+		info.JobHeight = "0x" + job.Height
+	}
+	if cs.port != nil {
+		info.Port = cs.port.config.Name
+	}
+	return info
+}
+
+// identity returns login, it's safe to call from any goroutine
+func (cs *Session) identity() string {
+	cs.Lock()
+	defer cs.Unlock()
+	return cs.login
+}
+
+// Worker name given on login, resume may change it concurrently
+func (cs *Session) workerName() string {
+	cs.Lock()
+	defer cs.Unlock()
+	return cs.worker
+}
+
+func (cs *Session) isSolo() bool {
+	cs.Lock()
+	defer cs.Unlock()
+	return cs.solo
+}
+
+func (cs *Session) markShare() {
+	atomic.StoreInt64(&cs.lastShareAt, util.MakeTimestamp())
+}
+
+// findSessions returns authorized sessions matching login and ip, empty filter matches any
+func (s *ProxyServer) findSessions(login, ip string) []*Session {
+	s.sessionsMu.RLock()
+	defer s.sessionsMu.RUnlock()
+
+	var found []*Session
+	for _, cs := range s.sessions {
+		if len(login) > 0 && cs.identity() != login {
+			continue
+		}
+		if len(ip) > 0 && cs.ip != ip {
+			continue
+		}
+		found = append(found, cs)
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].id < found[j].id })
+	return found
+}
+
+func (s *ProxyServer) findSession(id uint64) *Session {
+	s.sessionsMu.RLock()
+	defer s.sessionsMu.RUnlock()
+
+	return s.sessions[id]
+}
+
+// Kicked session is not parked for resume
+func (s *ProxyServer) kickSession(cs *Session, ban bool) {
+	s.sessionsMu.Lock()
+	delete(s.sessions, cs.id)
+	s.sessionsMu.Unlock()
+
+	s.removeSession(cs)
+	cs.conn.Close()
+	if ban {
+		s.policy.BanClient(cs.ip)
+	}
+	logger.Info("Kicked stratum session %d %s@%s, ban: %v", cs.id, cs.identity(), cs.ip, ban)
+}
+
+// GET /admin/sessions?login=&ip=
+func (s *ProxyServer) AdminSessions(w http.ResponseWriter, r *http.Request) {
+	login := strings.ToLower(r.URL.Query().Get("login"))
+	sessions := s.findSessions(login, r.URL.Query().Get("ip"))
+	infos := make([]sessionInfo, 0, len(sessions))
+	for _, cs := range sessions {
+		infos = append(infos, cs.info())
+	}
+	writeAdminReply(w, http.StatusOK, map[string]interface{}{
+		"now":           util.MakeTimestamp(),
+		"sessions":      infos,
+		"sessionsTotal": len(infos),
+	})
+}
+
+// GET /admin/sessions/{id}
+func (s *ProxyServer) AdminSession(w http.ResponseWriter, r *http.Request) {
+	cs := s.sessionFromRequest(w, r)
+	if cs == nil {
+		return
+	}
+	writeAdminReply(w, http.StatusOK, cs.info())
+}
+
+// DELETE /admin/sessions/{id}?ban=true
+func (s *ProxyServer) AdminKickSession(w http.ResponseWriter, r *http.Request) {
+	cs := s.sessionFromRequest(w, r)
+	if cs == nil {
+		return
+	}
+	ban, _ := strconv.ParseBool(r.URL.Query().Get("ban"))
+	s.kickSession(cs, ban)
+	writeAdminReply(w, http.StatusOK, map[string]interface{}{"kicked": 1, "banned": ban})
+}
+
+// DELETE /admin/sessions?login=&ip=&ban=true kicks every matching session
+func (s *ProxyServer) AdminKickSessions(w http.ResponseWriter, r *http.Request) {
+	login := strings.ToLower(r.URL.Query().Get("login"))
+	ip := r.URL.Query().Get("ip")
+	if len(login) == 0 && len(ip) == 0 {
+		writeAdminReply(w, http.StatusBadRequest, map[string]string{"error": "login or ip required"})
+		return
+	}
+	ban, _ := strconv.ParseBool(r.URL.Query().Get("ban"))
+	sessions := s.findSessions(login, ip)
+	for _, cs := range sessions {
+		s.kickSession(cs, ban)
+	}
+	// Banned address can't reconnect even if it has no live sessions
+	if ban && len(ip) > 0 && len(sessions) == 0 {
+		s.policy.BanClient(ip)
+	}
+	writeAdminReply(w, http.StatusOK, map[string]interface{}{"kicked": len(sessions), "banned": ban})
+}
+
+func (s *ProxyServer) sessionFromRequest(w http.ResponseWriter, r *http.Request) *Session {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeAdminReply(w, http.StatusBadRequest, map[string]string{"error": "invalid session id"})
+		return nil
+	}
+	cs := s.findSession(id)
+	if cs == nil {
+		writeAdminReply(w, http.StatusNotFound, map[string]string{"error": "session not found"})
+	}
+	return cs
+}
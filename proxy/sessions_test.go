@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/etclabscore/core-pool/library/logger"
+	"github.com/etclabscore/core-pool/policy"
+
+	"go.uber.org/zap"
+)
+
+func newSessionsTestServer() *ProxyServer {
+	logger.SugarLogger = zap.NewNop().Sugar()
+	return &ProxyServer{
+		config:   &Config{},
+		sessions: make(map[uint64]*Session),
+		policy:   &policy.PolicyServer{},
+	}
+}
+
+func TestFindSession(t *testing.T) {
+	s := newSessionsTestServer()
+	logins := []string{"0xb85150eb365e7df0941f0cf08235f987ba91506a", "0x0000000000000000000000000000000000000001"}
+	for i := 1; i <= 4; i++ {
+		cs := &Session{id: uint64(i), ip: "10.0.0.1", login: logins[i%2]}
+		if i == 4 {
+			cs.ip = "10.0.0.2"
+		}
+		s.registerSession(cs)
+	}
+
+	if cs := s.findSession(3); cs == nil || cs.id != 3 {
+		t.Errorf("Must find session by id, got %+v", cs)
+	}
+	if cs := s.findSession(5); cs != nil {
+		t.Errorf("Unknown session must not be found, got %+v", cs)
+	}
+	if found := s.findSessions(logins[1], ""); len(found) != 2 || found[0].id != 1 || found[1].id != 3 {
+		t.Errorf("Must find sessions of login ordered by id, got %d", len(found))
+	}
+	if found := s.findSessions(logins[0], "10.0.0.2"); len(found) != 1 || found[0].id != 4 {
+		t.Errorf("Must filter sessions by login and ip, got %d", len(found))
+	}
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	extranonces, _ := newExtranonceAllocator(2, 0, 0)
+	cs := s.findSession(2)
+	cs.conn, cs.port = conn, &stratumPort{extranonces: extranonces}
+	s.kickSession(cs, false)
+	if s.findSession(2) != nil || len(s.findSessions("", "")) != 3 {
+		t.Error("Kicked session must be removed")
+	}
+}
+
+// Repeated authorize must not race with admin lookups, run with -race
+func TestRepeatedLoginLookup(t *testing.T) {
+	s := newSessionsTestServer()
+	cs := &Session{id: 1, ip: "10.0.0.1"}
+	login := "0xb85150eb365e7df0941f0cf08235f987ba91506a"
+	if ok, errReply := s.handleLoginRPC(cs, []string{login}, "rig"); !ok {
+		t.Fatalf("Login must succeed, got %v", errReply)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			for _, found := range s.findSessions(login, "") {
+				found.info()
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		s.handleLoginRPC(cs, []string{login}, "rig2")
+	}
+	wg.Wait()
+
+	if info := cs.info(); info.Login != login || info.Worker != "rig2" {
+		t.Errorf("Session must keep latest login, got %+v", info)
+	}
+}
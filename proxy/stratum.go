@@ -2,19 +2,21 @@ package proxy
 
 import (
 	"bufio"
+	"crypto/rand"
 	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"math/rand"
 	"net"
 	"strconv"
-	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/etclabscore/core-pool/common"
 	"github.com/etclabscore/core-pool/library/logger"
+	"github.com/etclabscore/core-pool/library/proxyproto"
 	"github.com/etclabscore/core-pool/util"
 )
 
@@ -32,64 +34,115 @@ const (
 func (s *ProxyServer) ListenTCP() {
 	s.timeout = util.MustParseDuration(s.config.Proxy.Stratum.Timeout)
 
-	var err error
-	var server net.Listener
+	for _, port := range s.ports {
+		port := port
+		common.RoutineGroup.GoRecover(func() error {
+			s.listenPort(port)
+			return nil
+		})
+	}
+}
+
+// Every port has own accept loop and connection limit
+func (s *ProxyServer) listenPort(port *stratumPort) {
+	cfg := port.config
+	server, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		logger.Fatal("Listen stratum port error: %v", err)
+	}
+	// PROXY protocol header precedes TLS handshake
+	if s.config.Proxy.ProxyProtocol.Stratum {
+		server = s.proxyProtocolListener(server)
+	}
 	setKeepAlive := func(net.Conn) {}
-	if s.config.Proxy.Stratum.TLS { // 走证书加密通信
+	if cfg.TLS { // 走证书加密通信
 		var cert tls.Certificate
-		cert, err = tls.LoadX509KeyPair(s.config.Proxy.Stratum.CertFile, s.config.Proxy.Stratum.KeyFile)
+		cert, err = tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
 		if err != nil {
 			logger.Fatal("Error loading certificate: %v", err)
 		}
 		tlsCfg := &tls.Config{Certificates: []tls.Certificate{cert}}
-		server, err = tls.Listen("tcp", s.config.Proxy.Stratum.Listen, tlsCfg)
+		server = tls.NewListener(server, tlsCfg)
 	} else { // 不走证书
-		server, err = net.Listen("tcp", s.config.Proxy.Stratum.Listen)
 		setKeepAlive = func(conn net.Conn) {
+			if pc, ok := conn.(*proxyproto.Conn); ok {
+				conn = pc.NetConn()
+			}
 			conn.(*net.TCPConn).SetKeepAlive(true)
 		}
 	}
-	if err != nil {
-		logger.Fatal("Listen stratum port error: %v", err)
-	}
 	defer server.Close()
+	s.addListener(server)
 
-	logger.Info("Stratum listening on %s", s.config.Proxy.Stratum.Listen)
-	var accept = make(chan int, s.config.Proxy.Stratum.MaxConn)
-	n := 0
+	if cfg.WebSocket {
+		s.serveWebSocket(port, server)
+		return
+	}
 
+	logger.Info("Stratum port %s listening on %s, difficulty %v", cfg.Name, cfg.Listen, port.difficulty)
 	for {
 		conn, err := server.Accept()
 		if err != nil {
+			if !s.isListening(server) {
+				logger.Info("Stratum listener %s closed", cfg.Listen)
+				return
+			}
 			continue
 		}
 		setKeepAlive(conn)
+		s.trackConn(conn)
 
-		ip, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
-
-		if s.policy.IsBanned(ip) || !s.policy.ApplyLimitPolicy(ip) {
-			conn.Close()
-			continue
-		}
-		n += 1
-		// make unique extranonce
-		extranonce := s.uniqExtranonce()
-		cs := &Session{conn: conn, ip: ip, Extranonce: extranonce, ExtranonceSub: false, stratum: -1}
-		// allocate stales cache
-		cs.staleJobs = make(map[string]staleJob)
-
-		accept <- n
+		port.acquire()
+		// Remote address of PROXY protocol connection reads the header,
+		// slow or silent client must not hold up accept loop
 		common.RoutineGroup.Go(func() error {
-			if err = s.handleTCPClient(cs); err != nil {
-				s.removeSession(cs)
-				conn.Close()
-			}
-			<-accept
+			ip, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+			s.startSession(port, conn, ip)
 			return nil
 		})
 	}
 }
 
+// startSession serves accepted stratum connection of any transport,
+// caller holds port slot which is released with connection
+func (s *ProxyServer) startSession(port *stratumPort, conn net.Conn, ip string) {
+	s.trackConn(conn)
+	if s.policy.IsBanned(ip) || !s.policy.ApplyLimitPolicy(ip) {
+		s.dropConn(port, conn)
+		return
+	}
+	// make unique extranonce
+	extranonce, err := port.extranonces.Allocate()
+	if err != nil {
+		logger.Warn("Rejected stratum connection from %s: %v", ip, err)
+		s.dropConn(port, conn)
+		return
+	}
+	cs := &Session{conn: conn, ip: ip, port: port, Extranonce: extranonce, ExtranonceSub: false, stratum: -1}
+	cs.id = atomic.AddUint64(&s.sessionSeq, 1)
+	cs.connectedAt = util.MakeTimestamp()
+	cs.diff = port.difficulty
+	if port.vardiff.Enabled {
+		cs.vardiff = newVarDiff(port.vardiff)
+	}
+
+	common.RoutineGroup.Go(func() error {
+		if err := s.handleTCPClient(cs); err != nil {
+			s.removeSession(cs)
+			conn.Close()
+		}
+		s.untrackConn(conn)
+		port.release()
+		return nil
+	})
+}
+
+func (s *ProxyServer) dropConn(port *stratumPort, conn net.Conn) {
+	conn.Close()
+	s.untrackConn(conn)
+	port.release()
+}
+
 func (s *ProxyServer) handleTCPClient(cs *Session) error {
 	cs.enc = json.NewEncoder(cs.conn)
 	connbuff := bufio.NewReaderSize(cs.conn, MaxReqSize)
@@ -122,6 +175,13 @@ func (s *ProxyServer) handleTCPClient(cs *Session) error {
 			if err != nil {
 				return err
 			}
+			if vd := cs.varDiff(); vd != nil {
+				if diff, ok := vd.takePending(); ok {
+					if err = s.retargetSession(cs, diff); err != nil {
+						return err
+					}
+				}
+			}
 		}
 	}
 	return nil
@@ -156,6 +216,10 @@ func (cs *Session) handleTCPMessage(s *ProxyServer, req *StratumReq) error {
 			logger.Error("Malformed stratum request params from %s, params: %s", cs.ip, string(req.Params))
 			return err
 		}
+		if !cs.port.allows(EthProxy) {
+			logger.Warn("EthProxy is not allowed on port %s from %s", cs.port.config.Name, cs.ip)
+			return cs.sendTCPError(req.Id, &ErrorReply{Code: -1, Message: "Unsupported protocol"})
+		}
 		reply, errReply := s.handleLoginRPC(cs, params, req.Worker)
 		if errReply != nil {
 			return cs.sendTCPError(req.Id, errReply)
@@ -172,18 +236,21 @@ func (cs *Session) handleTCPMessage(s *ProxyServer, req *StratumReq) error {
 				logger.Error("Malformed stratum request params from %s, params: %s", cs.ip, string(req.Params))
 				return err
 			}
-			// always set session Id FIXME
-			sessionId := randomHex(32)
-			if len(params) > 0 {
-				// old sessionId: params[0]
-				return cs.sendStratumResult(req.Id, sessionId)
+			// params[0] is id of the session miner wants to resume
+			if len(params) > 0 && s.resumeSession(cs, params[0]) {
+				logger.Info("EthereumStratum/2.0.0 session resumed %s@%s", cs.identity(), cs.ip)
+				if err := cs.sendStratumResult(req.Id, cs.subscriptionID); err != nil {
+					return err
+				}
+				return cs.sendJob(s, req.Id, true)
 			}
 
+			sessionId := randomHex(32)
 			cs.subscriptionID = sessionId
 			return cs.sendStratumResult(req.Id, sessionId)
 		}
 
-		if params[1] != "EthereumStratum/1.0.0" {
+		if params[1] != "EthereumStratum/1.0.0" || !cs.port.allows(NiceHash) {
 			logger.Warn("Unsupported stratum version from %s", cs.ip)
 			return cs.sendStratumError(req.Id, "unsupported stratum version")
 		}
@@ -201,16 +268,20 @@ func (cs *Session) handleTCPMessage(s *ProxyServer, req *StratumReq) error {
 			return err
 		}
 
-		if params["proto"] != "EthereumStratum/2.0.0" {
+		if params["proto"] != "EthereumStratum/2.0.0" || !cs.port.allows(Stratum2) {
 			logger.Warn("Unsupported stratum version from %s", cs.ip)
 			return cs.sendStratumError(req.Id, "unsupported stratum version")
 		}
 
 		logger.Info("EthereumStratum/2.0.0 hello %s", cs.ip)
+		resume := "0"
+		if s.resumable != nil {
+			resume = "1"
+		}
 		result := map[string]interface{}{
 			"proto":     "EthereumStratum/2.0.0",
 			"encoding":  "plain",
-			"resume":    "1",
+			"resume":    resume,
 			"timeout":   "b4", // 180 sec
 			"maxerrors": "5",
 			"node":      "Open-Ethreum-Pool",
@@ -242,9 +313,12 @@ func (cs *Session) handleTCPMessage(s *ProxyServer, req *StratumReq) error {
 			if err != nil || len(params) < 1 {
 				return fmt.Errorf("invalid params %v", req.Params)
 			}
-			splitData := strings.Split(params[0], ".")
-			params[0] = splitData[0]
-			_, errReply := s.handleLoginRPC(cs, params, req.Worker)
+			var worker string
+			params[0], worker = splitWorker(params[0])
+			if len(worker) == 0 {
+				worker = req.Worker
+			}
+			_, errReply := s.handleLoginRPC(cs, params, worker)
 			if errReply != nil {
 				return cs.sendStratumError(req.Id, []string{
 					fmt.Sprint(errReply.Code),
@@ -253,12 +327,35 @@ func (cs *Session) handleTCPMessage(s *ProxyServer, req *StratumReq) error {
 			}
 
 			// send worker
-			if err := cs.sendStratumResult(req.Id, splitData[1]); err != nil {
+			if err := cs.sendStratumResult(req.Id, worker); err != nil {
 				return err
 			}
 
 			return cs.sendJob(s, req.Id, true)
 
+		case "mining.hashrate":
+			var params []string
+			err := json.Unmarshal(req.Params, &params)
+			if err != nil || len(params) < 1 {
+				logger.Error("mining.hashrate: json.Unmarshal fail, params: %v", req.Params)
+				return err
+			}
+
+			// params[0] = Hashrate in hex
+			// params[1] = Worker, optional
+			id := cs.workerName()
+			if len(params) > 1 {
+				id = params[1]
+			}
+			reply, errReply := s.handleSubmitHashrateRPC(cs, cs.identity(), id, params[:1])
+			if errReply != nil {
+				return cs.sendStratumError(req.Id, []string{
+					strconv.Itoa(errReply.Code),
+					errReply.Message,
+				})
+			}
+			return cs.sendStratumResult(req.Id, reply)
+
 		case "mining.submit":
 			var params []string
 			err := json.Unmarshal(req.Params, &params)
@@ -274,30 +371,21 @@ func (cs *Session) handleTCPMessage(s *ProxyServer, req *StratumReq) error {
 
 			id := params[2]
 
-			if cs.JobDetails.JobID != params[0] {
-				stale, ok := cs.staleJobs[params[0]]
-				if ok {
-					logger.Info("Cached stale JobID %s", params[0])
-					params = []string{
-						cs.Extranonce + params[1],
-						stale.SeedHash,
-						stale.HeaderHash,
-					}
-				} else {
-					logger.Warn("Stale share (mining.submit JobID received %s != current %s)", params[0], cs.JobDetails.JobID)
-					if err := cs.sendStratumError(req.Id, map[string]string{"code": "202", "message": "Stale share."}); err != nil {
-						return err
-					}
-					return cs.sendJob(s, req.Id, false)
-				}
-			} else {
-				nonce := cs.Extranonce + params[1]
-
-				params = []string{
-					nonce,
-					cs.JobDetails.SeedHash,
-					cs.JobDetails.HeaderHash,
+			job, ok := s.lookupJob(params[0])
+			if !ok {
+				logger.Warn("Stale share (mining.submit JobID received %s, current %s)", params[0], cs.currentJobID())
+				if err := cs.sendStratumError(req.Id, map[string]string{"code": "202", "message": "Stale share."}); err != nil {
+					return err
 				}
+				return cs.sendJob(s, req.Id, true)
+			}
+			if job.JobID != cs.currentJobID() {
+				logger.Info("Backlog JobID %s", params[0])
+			}
+			params = []string{
+				cs.Extranonce + params[1],
+				job.SeedHash,
+				job.HeaderHash,
 			}
 
 			reply, errReply := s.handleTCPSubmitRPC(cs, id, params)
@@ -328,9 +416,12 @@ func (cs *Session) handleTCPMessage(s *ProxyServer, req *StratumReq) error {
 			if err != nil || len(params) < 1 {
 				return fmt.Errorf("invalid params %v", req.Params)
 			}
-			splitData := strings.Split(params[0], ".")
-			params[0] = splitData[0]
-			reply, errReply := s.handleLoginRPC(cs, params, req.Worker)
+			var worker string
+			params[0], worker = splitWorker(params[0])
+			if len(worker) == 0 {
+				worker = req.Worker
+			}
+			reply, errReply := s.handleLoginRPC(cs, params, worker)
 			if errReply != nil {
 				return cs.sendStratumError(req.Id, []string{
 					fmt.Sprint(errReply.Code),
@@ -342,11 +433,7 @@ func (cs *Session) handleTCPMessage(s *ProxyServer, req *StratumReq) error {
 				return err
 			}
 
-			paramsDiff := []float64{
-				util.DiffIntToFloat(s.config.Proxy.Difficulty),
-			}
-			respReq := JSONStratumReq{Method: "mining.set_difficulty", Params: paramsDiff}
-			if err := cs.sendTCPReq(respReq); err != nil {
+			if err := cs.sendDifficulty(); err != nil {
 				return err
 			}
 
@@ -378,6 +465,8 @@ func (cs *Session) handleTCPMessage(s *ProxyServer, req *StratumReq) error {
 				"20",
 				"Not supported.",
 			})
+		case "eth_submitHashrate":
+			return s.handleTCPSubmitHashrate(cs, req)
 		case "mining.submit":
 			var params []string
 			err := json.Unmarshal(req.Params, &params)
@@ -393,10 +482,9 @@ func (cs *Session) handleTCPMessage(s *ProxyServer, req *StratumReq) error {
 			// https://github.com/nicehash/nhethpool/blob/060817a9e646cd9f1092647b870ed625ee138ab4/nhethpool/EthereumInstance.cs#L369
 
 			// WORKER NAME MANDATORY  0x1234.WORKERNAME
-			splitData := strings.Split(params[0], ".")
-			id := "0"
-			if len(splitData) > 1 {
-				id = splitData[1]
+			_, id := splitWorker(params[0])
+			if len(id) == 0 {
+				id = "0"
 			}
 
 			// check Extranonce subscription.
@@ -406,28 +494,21 @@ func (cs *Session) handleTCPMessage(s *ProxyServer, req *StratumReq) error {
 			}
 			nonce := extranonce + params[2]
 
-			if cs.JobDetails.JobID != params[1] {
-				stale, ok := cs.staleJobs[params[1]]
-				if ok {
-					logger.Info("Cached stale JobID %s", params[1])
-					params = []string{
-						nonce,
-						stale.SeedHash,
-						stale.HeaderHash,
-					}
-				} else {
-					logger.Warn("Stale share (mining.submit JobID received %s != current %s)", params[1], cs.JobDetails.JobID)
-					if err := cs.sendStratumError(req.Id, []string{"21", "Stale share."}); err != nil {
-						return err
-					}
-					return cs.sendJob(s, req.Id, false)
-				}
-			} else {
-				params = []string{
-					nonce,
-					cs.JobDetails.SeedHash,
-					cs.JobDetails.HeaderHash,
+			job, ok := s.lookupJob(params[1])
+			if !ok {
+				logger.Warn("Stale share (mining.submit JobID received %s, current %s)", params[1], cs.currentJobID())
+				if err := cs.sendStratumError(req.Id, []string{"21", "Stale share."}); err != nil {
+					return err
 				}
+				return cs.sendJob(s, req.Id, true)
+			}
+			if job.JobID != cs.currentJobID() {
+				logger.Info("Backlog JobID %s", params[1])
+			}
+			params = []string{
+				nonce,
+				job.SeedHash,
+				job.HeaderHash,
 			}
 
 			reply, errReply := s.handleTCPSubmitRPC(cs, id, params)
@@ -476,13 +557,36 @@ func (cs *Session) handleTCPMessage(s *ProxyServer, req *StratumReq) error {
 		}
 		return cs.sendTCPResult(req.Id, &reply)
 	case "eth_submitHashrate":
-		return cs.sendTCPResult(req.Id, true)
+		return s.handleTCPSubmitHashrate(cs, req)
 	default:
 		errReply := s.handleUnknownRPC(cs, req.Method)
 		return cs.sendTCPError(req.Id, errReply)
 	}
 }
 
+// eth_submitHashrate is sent by EthProxy and NiceHash miners alike
+func (s *ProxyServer) handleTCPSubmitHashrate(cs *Session, req *StratumReq) error {
+	var params []string
+	err := json.Unmarshal(req.Params, &params)
+	if err != nil {
+		logger.Error("Malformed stratum request params from %s, params: %s", cs.ip, string(req.Params))
+		return err
+	}
+	login := cs.identity()
+	if len(login) == 0 {
+		return cs.sendTCPError(req.Id, &ErrorReply{Code: 25, Message: "Not subscribed"})
+	}
+	id := req.Worker
+	if len(id) == 0 {
+		id = cs.workerName()
+	}
+	reply, errReply := s.handleSubmitHashrateRPC(cs, login, id, params)
+	if errReply != nil {
+		return cs.sendTCPError(req.Id, errReply)
+	}
+	return cs.sendTCPResult(req.Id, reply)
+}
+
 func (cs *Session) sendTCPResult(id json.RawMessage, result interface{}) error {
 	cs.Lock()
 	defer cs.Unlock()
@@ -491,46 +595,12 @@ func (cs *Session) sendTCPResult(id json.RawMessage, result interface{}) error {
 	return cs.enc.Encode(&message)
 }
 
-// cache stale jobs
-func (cs *Session) cacheStales(max, n int) {
-	l := len(cs.staleJobIDs)
-	// remove outdated stales except last n caches if l > max
-	if l > max {
-		save := cs.staleJobIDs[l-n : l]
-		del := cs.staleJobIDs[0 : l-n]
-		for _, v := range del {
-			delete(cs.staleJobs, v)
-		}
-		cs.staleJobIDs = save
-	}
-	// save stales cache
-	cs.staleJobs[cs.JobDetails.JobID] = staleJob{
-		cs.JobDetails.SeedHash,
-		cs.JobDetails.HeaderHash,
-	}
-	cs.staleJobIDs = append(cs.staleJobIDs, cs.JobDetails.JobID)
-}
-
-func (cs *Session) pushNewJob(s *ProxyServer, result interface{}) error {
+func (cs *Session) pushNewJob(t *BlockTemplate) error {
 	cs.Lock()
 	defer cs.Unlock()
 
 	if cs.stratumMode() == NiceHash {
-		cs.cacheStales(10, 3)
-
-		t := result.(*[]string)
-		cs.JobDetails = jobDetails{
-			JobID:      randomHex(8),
-			SeedHash:   (*t)[1],
-			HeaderHash: (*t)[0],
-			Height:     (*t)[3],
-		}
-
-		// strip 0x prefix
-		if cs.JobDetails.SeedHash[0:2] == "0x" {
-			cs.JobDetails.SeedHash = cs.JobDetails.SeedHash[2:]
-			cs.JobDetails.HeaderHash = cs.JobDetails.HeaderHash[2:]
-		}
+		cs.JobDetails = t.currentJob()
 
 		resp := JSONStratumReq{
 			Method: "mining.notify",
@@ -553,22 +623,7 @@ func (cs *Session) pushNewJob(s *ProxyServer, result interface{}) error {
 	}
 
 	if cs.stratumMode() == Stratum2 {
-		cs.cacheStales(10, 3)
-
-		t := result.(*[]string)
-		cs.JobDetails = jobDetails{
-			JobID:      randomHex(8),
-			SeedHash:   (*t)[1],
-			HeaderHash: (*t)[0],
-			Height:     (*t)[3],
-		}
-
-		// strip 0x prefix
-		if cs.JobDetails.SeedHash[0:2] == "0x" {
-			cs.JobDetails.SeedHash = cs.JobDetails.SeedHash[2:]
-			cs.JobDetails.HeaderHash = cs.JobDetails.HeaderHash[2:]
-			cs.JobDetails.Height = cs.JobDetails.Height[2:]
-		}
+		cs.JobDetails = t.currentJob()
 
 		resp := JSONStratumReq{
 			Method: "mining.notify",
@@ -584,11 +639,49 @@ func (cs *Session) pushNewJob(s *ProxyServer, result interface{}) error {
 		}
 		return cs.enc.Encode(&resp)
 	}
+	job := []string{t.Header, t.Seed, cs.target(), util.ToHex(int64(t.Height))}
+
 	// FIXME: Temporarily add ID for Claymore compliance
-	message := JSONPushMessage{Version: "2.0", Result: result, Id: 0}
+	message := JSONPushMessage{Version: "2.0", Result: &job, Id: 0}
 	return cs.enc.Encode(&message)
 }
 
+// Announce current session difficulty to the miner.
+// EthProxy has no such message, miner gets target with the next job.
+func (cs *Session) sendDifficulty() error {
+	switch cs.stratumMode() {
+	case NiceHash:
+		req := JSONStratumReq{
+			Method: "mining.set_difficulty",
+			Params: []float64{util.DiffIntToFloat(cs.difficulty())},
+		}
+		return cs.sendTCPReq(req)
+	case Stratum2:
+		req := JSONStratumReq{
+			Method: "mining.set",
+			Params: map[string]interface{}{
+				"target": cs.target()[2:],
+			},
+		}
+		return cs.sendTCPReq(req)
+	}
+	return nil
+}
+
+func (s *ProxyServer) retargetSession(cs *Session, diff int64) error {
+	logger.Debug("Retarget %s@%s difficulty %d => %d", cs.identity(), cs.ip, cs.difficulty(), diff)
+	cs.setDifficulty(diff)
+
+	if cs.stratumMode() != EthProxy {
+		return cs.sendDifficulty()
+	}
+	t := s.currentBlockTemplate()
+	if t == nil || len(t.Header) == 0 || s.isSick() {
+		return nil
+	}
+	return cs.pushNewJob(t)
+}
+
 func (cs *Session) sendTCPError(id json.RawMessage, reply *ErrorReply) error {
 	cs.Lock()
 	defer cs.Unlock()
@@ -632,59 +725,67 @@ func (self *ProxyServer) setDeadline(conn net.Conn) {
 func (s *ProxyServer) registerSession(cs *Session) {
 	s.sessionsMu.Lock()
 	defer s.sessionsMu.Unlock()
-	s.sessions[cs] = struct{}{}
+	s.sessions[cs.id] = cs
 }
 
 func (s *ProxyServer) removeSession(cs *Session) {
 	s.sessionsMu.Lock()
 	defer s.sessionsMu.Unlock()
-	delete(s.Extranonces, cs.Extranonce)
-	delete(s.sessions, cs)
+
+	if cs.removed {
+		return
+	}
+	cs.removed = true
+
+	// Authorized EthereumStratum/2.0.0 session keeps its extranonce while parked
+	_, authorized := s.sessions[cs.id]
+	if authorized && s.resumable != nil && cs.stratumMode() == Stratum2 && len(cs.subscriptionID) > 0 {
+		s.parkSession(cs)
+	} else {
+		cs.port.extranonces.Release(cs.Extranonce)
+	}
+	delete(s.sessions, cs.id)
 }
 
 // nicehash
 func (cs *Session) sendJob(s *ProxyServer, id json.RawMessage, newjob bool) error {
+	var job jobDetails
 	if newjob {
-		reply, errReply := s.handleGetWorkRPC(cs)
+		var errReply *ErrorReply
+		job, errReply = s.currentJob()
 		if errReply != nil {
 			return cs.sendStratumError(id, []string{
 				fmt.Sprint(errReply.Code),
 				errReply.Message,
 			})
 		}
+	}
 
-		cs.JobDetails = jobDetails{
-			JobID:      randomHex(8),
-			SeedHash:   reply[1],
-			HeaderHash: reply[0],
-			Height:     reply[3],
-		}
-
-		// The NiceHash official .NET pool omits 0x...
-		// TO DO: clean up once everything works
-		if cs.JobDetails.SeedHash[0:2] == "0x" {
-			cs.JobDetails.SeedHash = cs.JobDetails.SeedHash[2:]
-			cs.JobDetails.HeaderHash = cs.JobDetails.HeaderHash[2:]
-		}
+	// Broadcaster pushes jobs concurrently, job is set and sent under session lock
+	cs.Lock()
+	defer cs.Unlock()
+	if newjob {
+		cs.JobDetails = job
 	}
+	job = cs.JobDetails
 
 	var resp JSONStratumReq
 	if cs.stratumMode() == NiceHash {
 		resp = JSONStratumReq{
 			Method: "mining.notify",
 			Params: []interface{}{
-				cs.JobDetails.JobID,
-				cs.JobDetails.SeedHash,
-				cs.JobDetails.HeaderHash,
-				cs.JobDetails.Height,
+				job.JobID,
+				job.SeedHash,
+				job.HeaderHash,
+				"0x" + job.Height,
 				true,
 			},
 		}
 	}
 
 	if cs.stratumMode() == Stratum2 {
-		target := util.GetTargetHex(s.config.Proxy.Difficulty)[2:]
-		height, _ := strconv.ParseInt(cs.JobDetails.Height, 16, 64)
+		target := cs.target()[2:]
+		height, _ := strconv.ParseInt(job.Height, 16, 64)
 
 		result := map[string]interface{}{
 			"epoch":      util.ToHex(int64(height / epochLength))[2:],
@@ -695,19 +796,28 @@ func (cs *Session) sendJob(s *ProxyServer, id json.RawMessage, newjob bool) erro
 			Method: "mining.set",
 			Params: result,
 		}
-		cs.sendTCPReq(resp)
+		if err := cs.enc.Encode(&resp); err != nil {
+			return err
+		}
 
 		resp = JSONStratumReq{
 			Method: "mining.notify",
 			Params: []interface{}{
-				cs.JobDetails.JobID,
-				cs.JobDetails.Height,
-				cs.JobDetails.HeaderHash,
+				job.JobID,
+				job.Height,
+				job.HeaderHash,
 				"1",
 			},
 		}
 	}
-	return cs.sendTCPReq(resp)
+	return cs.enc.Encode(&resp)
+}
+
+// Job last sent to the miner
+func (cs *Session) currentJobID() string {
+	cs.Lock()
+	defer cs.Unlock()
+	return cs.JobDetails.JobID
 }
 
 func (s *ProxyServer) broadcastNewJobs() {
@@ -715,62 +825,24 @@ func (s *ProxyServer) broadcastNewJobs() {
 	if t == nil || len(t.Header) == 0 || s.isSick() {
 		return
 	}
-	reply := []string{t.Header, t.Seed, s.diff, util.ToHex(int64(t.Height))}
-
 	s.sessionsMu.RLock()
-	defer s.sessionsMu.RUnlock()
-
-	count := len(s.sessions)
-	logger.Info("Broadcasting new job to %d stratum miners", count)
-
-	start := time.Now()
-	bcast := make(chan int, 1024)
-	n := 0
-
-	for m, _ := range s.sessions {
-		n++
-		bcast <- n
-
-		var cs = m
-		common.RoutineGroup.Go(func() (err error) {
-			err = cs.pushNewJob(s, &reply)
-			<-bcast
-			if err != nil {
-				logger.Error("Job transmit error to %s@%s: %v", cs.login, cs.ip, err)
-				s.removeSession(cs)
-			} else {
-				s.setDeadline(cs.conn)
-			}
-			return nil
-		})
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, cs := range s.sessions {
+		sessions = append(sessions, cs)
 	}
-	logger.Info("Jobs broadcast finished %s", time.Since(start))
-}
-
-func (s *ProxyServer) uniqExtranonce() string {
-	s.sessionsMu.RLock()
-	defer s.sessionsMu.RUnlock()
+	s.sessionsMu.RUnlock()
 
-	extranonce := randomHex(4)
-	for {
-		if _, ok := s.Extranonces[extranonce]; ok {
-			extranonce = randomHex(4)
-		} else {
-			break
-		}
-	}
-	s.Extranonces[extranonce] = true
-	return extranonce
+	logger.Info("Broadcasting new job to %d stratum miners", len(sessions))
+	s.broadcaster.broadcast(t, sessions)
 }
 
+// Subscription id is the credential to resume session, it must not be guessable
 func randomHex(strlen int) string {
-	rand.Seed(time.Now().UTC().UnixNano())
-	const chars = "0123456789abcdef"
-	result := make([]byte, strlen)
-	for i := 0; i < strlen; i++ {
-		result[i] = chars[rand.Intn(len(chars))]
+	b := make([]byte, (strlen+1)/2)
+	if _, err := rand.Read(b); err != nil {
+		logger.Fatal("Failed to generate random id: %v", err)
 	}
-	return string(result)
+	return hex.EncodeToString(b)[:strlen]
 }
 
 func (cs *Session) getNotificationResponse(s *ProxyServer) interface{} {
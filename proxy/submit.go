@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/etclabscore/core-pool/library/logger"
+	"github.com/etclabscore/core-pool/rpc"
+	"github.com/etclabscore/core-pool/util"
+)
+
+type submitResult struct {
+	upstream *rpc.RPCClient
+	ok       bool
+	err      error
+	latency  time.Duration
+}
+
+// Block submission counters of one upstream
+type SubmitStats struct {
+	Accepted    int64  `json:"accepted"`
+	Rejected    int64  `json:"rejected"`
+	Failed      int64  `json:"failed"`
+	LastLatency int64  `json:"lastLatency"`
+	LastHeight  uint64 `json:"lastHeight"`
+	LastResult  string `json:"lastResult"`
+	LastAt      int64  `json:"lastAt"`
+}
+
+type submitStats struct {
+	sync.Mutex
+	upstreams map[string]*SubmitStats
+}
+
+func (st *submitStats) record(height uint64, res submitResult) {
+	st.Lock()
+	defer st.Unlock()
+
+	if st.upstreams == nil {
+		st.upstreams = make(map[string]*SubmitStats)
+	}
+	x, ok := st.upstreams[res.upstream.Name]
+	if !ok {
+		x = &SubmitStats{}
+		st.upstreams[res.upstream.Name] = x
+	}
+	switch {
+	case res.err != nil:
+		x.Failed++
+		x.LastResult = res.err.Error()
+	case res.ok:
+		x.Accepted++
+		x.LastResult = "accepted"
+	default:
+		x.Rejected++
+		x.LastResult = "rejected"
+	}
+	x.LastLatency = int64(res.latency / time.Millisecond)
+	x.LastHeight = height
+	x.LastAt = util.MakeTimestamp()
+}
+
+func (st *submitStats) snapshot() map[string]SubmitStats {
+	st.Lock()
+	defer st.Unlock()
+
+	stats := make(map[string]SubmitStats, len(st.upstreams))
+	for name, x := range st.upstreams {
+		stats[name] = *x
+	}
+	return stats
+}
+
+// submitBlock sends solution to every upstream which is not sick, active one is always included.
+// It returns as soon as any node accepts the block, results of slower nodes are still recorded.
+// rejected is true if no node accepted and at least one of them replied with rejection.
+func (s *ProxyServer) submitBlock(params []string, height uint64) (accepted bool, rejected bool) {
+	active := s.rpc()
+	targets := []*rpc.RPCClient{active}
+	for _, r := range s.upstreams {
+		if r != active && !r.Sick() {
+			targets = append(targets, r)
+		}
+	}
+
+	results := make(chan submitResult, len(targets))
+	for _, r := range targets {
+		r := r
+		go func() {
+			start := time.Now()
+			// Solution must reach nodes even during shutdown
+			ctx, cancel := context.WithTimeout(context.Background(), r.Timeout("eth_submitWork"))
+			defer cancel()
+			ok, err := r.SubmitBlock(ctx, params)
+			results <- submitResult{upstream: r, ok: ok, err: err, latency: time.Since(start)}
+		}()
+	}
+
+	decided := make(chan bool, 1)
+	go func() {
+		rejected := false
+		sent := false
+		for range targets {
+			res := <-results
+			s.submits.record(height, res)
+			switch {
+			case res.err != nil:
+				logger.Error("Block submission failure at height %v on %s in %v: %v", height, res.upstream.Name, res.latency, res.err)
+			case !res.ok:
+				rejected = true
+				logger.Error("Block rejected at height %v by %s in %v", height, res.upstream.Name, res.latency)
+			default:
+				logger.Info("Block accepted at height %v by %s in %v", height, res.upstream.Name, res.latency)
+			}
+			if res.ok && !sent {
+				decided <- true
+				sent = true
+			}
+		}
+		if !sent {
+			if rejected {
+				decided <- false
+			} else {
+				close(decided)
+			}
+		}
+	}()
+
+	accepted, ok := <-decided
+	return accepted, ok && !accepted
+}
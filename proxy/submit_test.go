@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/etclabscore/core-pool/library/logger"
+	"github.com/etclabscore/core-pool/rpc"
+
+	"go.uber.org/zap"
+)
+
+func fakeNode(result string, delay time.Duration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Id uint64 `json:"id"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		time.Sleep(delay)
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%d,"result":%s}`, req.Id, result)
+	}))
+}
+
+func newTestClient(t *testing.T, name, url string) *rpc.RPCClient {
+	r, err := rpc.NewClient(name, url, "1s", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestSubmitBlockAnyAccepts(t *testing.T) {
+	logger.SugarLogger = zap.NewNop().Sugar()
+	rejecting := fakeNode("false", 0)
+	defer rejecting.Close()
+	accepting := fakeNode("true", 50*time.Millisecond)
+	defer accepting.Close()
+
+	s := &ProxyServer{upstreams: []*rpc.RPCClient{
+		newTestClient(t, "main", rejecting.URL),
+		newTestClient(t, "backup", accepting.URL),
+		newTestClient(t, "broken", "http://127.0.0.1:1"),
+	}}
+
+	accepted, rejected := s.submitBlock([]string{"0x0", "0x0", "0x0"}, 1)
+	if !accepted || rejected {
+		t.Fatalf("Block must be accepted by backup node, got accepted=%v rejected=%v", accepted, rejected)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(s.submits.snapshot()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	stats := s.submits.snapshot()
+	if stats["main"].Rejected != 1 || stats["backup"].Accepted != 1 || stats["broken"].Failed != 1 {
+		t.Fatalf("Every node result must be recorded: %+v", stats)
+	}
+}
+
+func TestSubmitBlockRejected(t *testing.T) {
+	logger.SugarLogger = zap.NewNop().Sugar()
+	rejecting := fakeNode("false", 0)
+	defer rejecting.Close()
+
+	s := &ProxyServer{upstreams: []*rpc.RPCClient{
+		newTestClient(t, "main", rejecting.URL),
+		newTestClient(t, "broken", "http://127.0.0.1:1"),
+	}}
+	accepted, rejected := s.submitBlock([]string{"0x0", "0x0", "0x0"}, 1)
+	if accepted || !rejected {
+		t.Fatalf("Block must be rejected, got accepted=%v rejected=%v", accepted, rejected)
+	}
+
+	s.upstreams = s.upstreams[1:]
+	accepted, rejected = s.submitBlock([]string{"0x0", "0x0", "0x0"}, 2)
+	if accepted || rejected {
+		t.Fatalf("Failed submission is neither accepted nor rejected, got accepted=%v rejected=%v", accepted, rejected)
+	}
+}
@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/etclabscore/core-pool/common"
+	"github.com/etclabscore/core-pool/library/logger"
+	"github.com/etclabscore/core-pool/rpc"
+	"github.com/etclabscore/core-pool/util"
+)
+
+const (
+	defaultSubscribeRetry   = 5 * time.Second
+	defaultSubscribeTimeout = 10 * time.Second
+	// A few block times without notification means node or connection stalled
+	defaultSubscribeIdle = 60 * time.Second
+)
+
+var defaultSubscribeTopics = []string{"newHeads"}
+
+// Block templates are fetched as soon as node announces new work,
+// poller keeps running at a slower pace and takes over when subscription drops
+func (s *ProxyServer) subscribeTemplates() {
+	cfg := s.config.Proxy.BlockSubscribe
+	topics := cfg.Topics
+	if len(topics) == 0 {
+		topics = defaultSubscribeTopics
+	}
+	retryIntv := defaultSubscribeRetry
+	if len(cfg.RetryInterval) > 0 {
+		retryIntv = util.MustParseDuration(cfg.RetryInterval)
+	}
+	idle := defaultSubscribeIdle
+	if len(cfg.IdleTimeout) > 0 {
+		idle = util.MustParseDuration(cfg.IdleTimeout)
+	}
+
+	common.RoutineGroup.GoRecover(func() error {
+		for {
+			i := atomic.LoadInt32(&s.upstream)
+			upstream := s.config.Upstream[i]
+			if len(upstream.SubscribeUrl) > 0 {
+				s.runSubscription(i, &upstream, topics, idle)
+			}
+			select {
+			case <-common.RoutineCtx.Done():
+				logger.Info("Stopping block subscription worker")
+				return nil
+			case <-time.After(retryIntv):
+			}
+		}
+	})
+}
+
+// runSubscription returns when subscription drops, stays silent for idle timeout
+// or active upstream changes
+func (s *ProxyServer) runSubscription(upstream int32, cfg *Upstream, topics []string, idle time.Duration) {
+	name := s.upstreams[upstream].Name
+	sub, err := rpc.Subscribe(cfg.SubscribeUrl, defaultSubscribeTimeout, cfg.Auth, topics...)
+	if err != nil {
+		logger.Warn("Block subscription to %s failed: %v", name, err)
+		return
+	}
+	logger.Info("Subscribed to %v on %s", sub.Topics(), name)
+	atomic.StoreInt32(&s.subscribed, 1)
+
+	done := make(chan struct{})
+	defer close(done)
+	stop := common.RoutineCtx.Done()
+	go func() {
+		for {
+			select {
+			case <-stop:
+				sub.Close()
+				return
+			case <-s.upstreamSwitch:
+				// Signal may be left from a switch before this subscription started
+				if atomic.LoadInt32(&s.upstream) != upstream {
+					logger.Info("Upstream switched, closing block subscription to %s", name)
+					sub.Close()
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		sub.SetReadDeadline(time.Now().Add(idle))
+		topic, err := sub.Next()
+		if err != nil {
+			logger.Warn("Block subscription to %s dropped, falling back to polling: %v", name, err)
+			break
+		}
+		logger.Debug("Got %s notification from %s", topic, name)
+		if atomic.LoadInt32(&s.upstream) != upstream {
+			logger.Info("Upstream switched, closing block subscription to %s", name)
+			break
+		}
+		s.fetchBlockTemplate()
+	}
+	atomic.StoreInt32(&s.subscribed, 0)
+	sub.Close()
+}
+
+func (s *ProxyServer) isSubscribed() bool {
+	return atomic.LoadInt32(&s.subscribed) > 0
+}
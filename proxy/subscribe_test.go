@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/etclabscore/core-pool/common"
+	"github.com/etclabscore/core-pool/library/logger"
+
+	"go.uber.org/zap"
+)
+
+// Silent node accepts subscription and never notifies, like a stalled one
+func serveSilentNode(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			var req struct {
+				Id int `json:"id"`
+			}
+			json.NewDecoder(conn).Decode(&req)
+			json.NewEncoder(conn).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.Id, "result": "0x1"})
+		}()
+	}
+}
+
+func newSubscribeTestServer(t *testing.T) (*ProxyServer, *Upstream, func()) {
+	logger.SugarLogger = zap.NewNop().Sugar()
+	common.RoutineCtx = context.Background()
+	dir, err := ioutil.TempDir("", "proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "node.ipc")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Skip("unix sockets are not supported")
+	}
+	go serveSilentNode(l)
+
+	cfg := &Config{Upstream: []Upstream{{Name: "main", SubscribeUrl: path}, {Name: "backup"}}}
+	s := &ProxyServer{config: cfg, upstreamSwitch: make(chan struct{}, 1)}
+	for _, u := range cfg.Upstream {
+		s.upstreams = append(s.upstreams, newTestClient(t, u.Name, "http://127.0.0.1:1"))
+	}
+	return s, &cfg.Upstream[0], func() {
+		l.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func runSubscriptionAsync(s *ProxyServer, cfg *Upstream, idle time.Duration) chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		s.runSubscription(0, cfg, []string{"newHeads"}, idle)
+		close(done)
+	}()
+	return done
+}
+
+func TestSubscriptionIdleTimeout(t *testing.T) {
+	s, cfg, cleanup := newSubscribeTestServer(t)
+	defer cleanup()
+
+	done := runSubscriptionAsync(s, cfg, 200*time.Millisecond)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Silent subscription must be dropped after idle timeout")
+	}
+	if s.isSubscribed() {
+		t.Error("Dropped subscription must fall back to polling")
+	}
+}
+
+func TestSubscriptionUpstreamSwitch(t *testing.T) {
+	s, cfg, cleanup := newSubscribeTestServer(t)
+	defer cleanup()
+
+	// Signal left from an earlier switch must not close subscription to active upstream
+	s.upstreamSwitch <- struct{}{}
+	done := runSubscriptionAsync(s, cfg, time.Minute)
+	time.Sleep(200 * time.Millisecond)
+	if !s.isSubscribed() {
+		t.Fatal("Must subscribe to active upstream")
+	}
+
+	atomic.StoreInt32(&s.upstream, 1)
+	s.upstreamSwitch <- struct{}{}
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Subscription must be closed on upstream switch without notification")
+	}
+	if s.isSubscribed() {
+		t.Error("Closed subscription must fall back to polling")
+	}
+}
@@ -0,0 +1,192 @@
+package proxy
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/etclabscore/core-pool/common"
+	"github.com/etclabscore/core-pool/library/logger"
+	"github.com/etclabscore/core-pool/rpc"
+	"github.com/etclabscore/core-pool/storage"
+)
+
+const (
+	defaultMaxHeadLag   = 3
+	defaultSwitchChecks = 3
+)
+
+type upstreamState struct {
+	health *rpc.NodeHealth
+	lag    uint64
+	// Why upstream can't serve work, empty if it is eligible
+	problem string
+	// Consecutive checks upstream was eligible
+	healthyChecks int
+}
+
+// Why active upstream was selected
+type upstreamSelection struct {
+	sync.RWMutex
+	states []upstreamState
+	reason string
+}
+
+func (s *ProxyServer) upstreamPolicy() UpstreamPolicy {
+	p := s.config.UpstreamPolicy
+	if p.MaxHeadLag == 0 {
+		p.MaxHeadLag = defaultMaxHeadLag
+	}
+	if p.SwitchChecks <= 0 {
+		p.SwitchChecks = defaultSwitchChecks
+	}
+	return p
+}
+
+func (s *ProxyServer) upstreamConfig(i int) Upstream {
+	if i < len(s.config.Upstream) {
+		return s.config.Upstream[i]
+	}
+	return Upstream{}
+}
+
+// Whether upstream i is preferred over j by priority and weight
+func (s *ProxyServer) preferredUpstream(i, j int) bool {
+	a, b := s.upstreamConfig(i), s.upstreamConfig(j)
+	if a.Priority != b.Priority {
+		return a.Priority < b.Priority
+	}
+	return a.Weight > b.Weight
+}
+
+func upstreamProblem(r *rpc.RPCClient, h *rpc.NodeHealth, lag uint64, policy *UpstreamPolicy) string {
+	switch {
+	case !h.Alive:
+		return fmt.Sprintf("unreachable: %v", h.Err)
+	case h.Err != nil:
+		return fmt.Sprintf("health check failed: %v", h.Err)
+	case r.Sick():
+		return "sick"
+	case h.Syncing:
+		return "syncing"
+	case policy.MinPeers > 0 && h.Peers >= 0 && h.Peers < policy.MinPeers:
+		return fmt.Sprintf("%d peers", h.Peers)
+	case lag > policy.MaxHeadLag:
+		return fmt.Sprintf("%d blocks behind", lag)
+	}
+	return ""
+}
+
+func (s *ProxyServer) checkUpstreams() {
+	policy := s.upstreamPolicy()
+	healths := make([]*rpc.NodeHealth, len(s.upstreams))
+	var wg sync.WaitGroup
+	for i, v := range s.upstreams {
+		wg.Add(1)
+		go func(i int, v *rpc.RPCClient) {
+			defer wg.Done()
+			healths[i] = v.Health(common.RoutineCtx, policy.MinPeers > 0)
+		}(i, v)
+	}
+	wg.Wait()
+
+	var head uint64
+	for _, h := range healths {
+		if h.Alive && h.Height > head {
+			head = h.Height
+		}
+	}
+
+	sel := &s.selection
+	sel.Lock()
+	defer sel.Unlock()
+
+	if len(sel.states) != len(s.upstreams) {
+		sel.states = make([]upstreamState, len(s.upstreams))
+	}
+	best := -1
+	for i, h := range healths {
+		st := &sel.states[i]
+		st.health = h
+		st.lag = 0
+		if h.Alive && h.Height < head {
+			st.lag = head - h.Height
+		}
+		st.problem = upstreamProblem(s.upstreams[i], h, st.lag, &policy)
+		if len(st.problem) > 0 {
+			st.healthyChecks = 0
+			continue
+		}
+		st.healthyChecks++
+		if best < 0 || s.preferredUpstream(i, best) {
+			best = i
+		}
+	}
+
+	current := int(atomic.LoadInt32(&s.upstream))
+	candidate := current
+	switch {
+	case best < 0:
+		sel.reason = "no eligible upstream, keeping current"
+		if current < len(sel.states) && len(sel.states[current].problem) > 0 {
+			sel.reason = "no eligible upstream, current is " + sel.states[current].problem
+		}
+	case len(sel.states[current].problem) > 0:
+		// Fail over without waiting, current one can't serve work
+		candidate = best
+		sel.reason = fmt.Sprintf("failover from %s: %s", s.upstreams[current].Name, sel.states[current].problem)
+	case best != current && s.preferredUpstream(best, current):
+		if sel.states[best].healthyChecks >= policy.SwitchChecks {
+			candidate = best
+			sel.reason = "preferred by priority"
+		} else {
+			sel.reason = fmt.Sprintf("healthy, preferred %s is healthy for %d/%d checks",
+				s.upstreams[best].Name, sel.states[best].healthyChecks, policy.SwitchChecks)
+		}
+	default:
+		sel.reason = "healthy"
+	}
+
+	if candidate != current {
+		logger.Info("Switching to upstream %v: %v", s.upstreams[candidate].Name, sel.reason)
+		atomic.StoreInt32(&s.upstream, int32(candidate))
+		// Block subscription to previous upstream is closed without waiting for its notification
+		select {
+		case s.upstreamSwitch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Upstream states for backend and admin API
+func (s *ProxyServer) upstreamStates() []*storage.UpstreamState {
+	sel := &s.selection
+	sel.RLock()
+	defer sel.RUnlock()
+
+	current := int(atomic.LoadInt32(&s.upstream))
+	states := make([]*storage.UpstreamState, len(s.upstreams))
+	for i, u := range s.upstreams {
+		cfg := s.upstreamConfig(i)
+		state := &storage.UpstreamState{
+			Name:     u.Name,
+			Active:   i == current,
+			Sick:     u.Sick(),
+			Priority: cfg.Priority,
+			Weight:   cfg.Weight,
+		}
+		if i < len(sel.states) && sel.states[i].health != nil {
+			st := sel.states[i]
+			state.Height = st.health.Height
+			state.Lag = st.lag
+			state.Peers = st.health.Peers
+			state.Syncing = st.health.Syncing
+			state.Reason = st.problem
+		}
+		if state.Active {
+			state.Reason = sel.reason
+		}
+		states[i] = state
+	}
+	return states
+}
@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/etclabscore/core-pool/common"
+	"github.com/etclabscore/core-pool/library/logger"
+
+	"go.uber.org/zap"
+)
+
+type fakeUpstream struct {
+	height  uint64
+	peers   int64
+	syncing bool
+	// Node without net namespace and eth_syncing
+	minimal bool
+}
+
+func (f *fakeUpstream) serve() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Id     uint64 `json:"id"`
+			Method string `json:"method"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if f.minimal && (req.Method == "net_peerCount" || req.Method == "eth_syncing") {
+			json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.Id,
+				"error": map[string]interface{}{"code": -32601, "message": "the method " + req.Method + " does not exist/is not available"}})
+			return
+		}
+		var result interface{}
+		switch req.Method {
+		case "eth_getWork":
+			result = []string{"0x1", "0x2", "0x3"}
+		case "eth_syncing":
+			result = false
+			if f.syncing {
+				result = map[string]string{"currentBlock": "0x1"}
+			}
+		case "net_peerCount":
+			result = fmt.Sprintf("0x%x", f.peers)
+		case "eth_getBlockByNumber":
+			result = map[string]string{"number": fmt.Sprintf("0x%x", atomic.LoadUint64(&f.height))}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.Id, "result": result})
+	}))
+}
+
+func TestCheckUpstreamsFailoverAndHysteresis(t *testing.T) {
+	logger.SugarLogger = zap.NewNop().Sugar()
+	common.RoutineCtx = context.Background()
+	primary := &fakeUpstream{height: 100, peers: 10}
+	backup := &fakeUpstream{height: 110, peers: 10}
+	syncing := &fakeUpstream{height: 110, peers: 10, syncing: true}
+	srvs := []*httptest.Server{primary.serve(), backup.serve(), syncing.serve()}
+	for _, srv := range srvs {
+		defer srv.Close()
+	}
+
+	cfg := &Config{
+		Upstream: []Upstream{
+			{Name: "main", Priority: 0},
+			{Name: "backup", Priority: 1},
+			{Name: "syncing", Priority: 1, Weight: 10},
+		},
+		UpstreamPolicy: UpstreamPolicy{MaxHeadLag: 2, SwitchChecks: 2},
+	}
+	s := &ProxyServer{config: cfg}
+	for i, srv := range srvs {
+		s.upstreams = append(s.upstreams, newTestClient(t, cfg.Upstream[i].Name, srv.URL))
+	}
+
+	s.checkUpstreams()
+	if s.rpc().Name != "backup" {
+		t.Fatalf("Must fail over from lagging upstream to backup, got %v", s.rpc().Name)
+	}
+	states := s.upstreamStates()
+	if states[0].Lag != 10 || states[0].Reason != "10 blocks behind" || states[2].Reason != "syncing" {
+		t.Fatalf("Unexpected upstream states: %+v %+v", states[0], states[2])
+	}
+
+	atomic.StoreUint64(&primary.height, 110)
+	s.checkUpstreams()
+	if s.rpc().Name != "backup" {
+		t.Fatalf("Must not switch back before preferred upstream is healthy long enough")
+	}
+	s.checkUpstreams()
+	if s.rpc().Name != "main" {
+		t.Fatalf("Must switch back to preferred upstream, got %v", s.rpc().Name)
+	}
+	if states = s.upstreamStates(); !states[0].Active || states[0].Reason != "preferred by priority" {
+		t.Fatalf("Unexpected active upstream state: %+v", states[0])
+	}
+}
+
+func TestCheckUpstreamsUnknownPeers(t *testing.T) {
+	logger.SugarLogger = zap.NewNop().Sugar()
+	common.RoutineCtx = context.Background()
+	srv := (&fakeUpstream{height: 100, minimal: true}).serve()
+	defer srv.Close()
+
+	for _, minPeers := range []int64{0, 3} {
+		cfg := &Config{
+			Upstream:       []Upstream{{Name: "main"}},
+			UpstreamPolicy: UpstreamPolicy{MinPeers: minPeers},
+		}
+		s := &ProxyServer{config: cfg}
+		s.upstreams = append(s.upstreams, newTestClient(t, "main", srv.URL))
+
+		s.checkUpstreams()
+		states := s.upstreamStates()
+		if states[0].Peers != -1 || states[0].Reason != "healthy" {
+			t.Errorf("Node without net namespace must be eligible with minPeers %d, got %+v", minPeers, states[0])
+		}
+	}
+}
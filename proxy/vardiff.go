@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/etclabscore/core-pool/util"
+)
+
+const (
+	// Max factor difficulty may move in one retarget, avoids wild swings on bursty shares
+	maxRetargetFactor = 4.0
+)
+
+// vardiff watches share timing of one stratum session and retargets its
+// difficulty so the session submits between MinSharesPerMin and MaxSharesPerMin shares.
+type vardiff struct {
+	sync.Mutex
+	config       *VarDiff
+	retargetIntv time.Duration
+	lastRetarget time.Time
+	shares       int64
+	pending      int64
+}
+
+func newVarDiff(cfg *VarDiff) *vardiff {
+	return &vardiff{
+		config:       cfg,
+		retargetIntv: util.MustParseDuration(cfg.RetargetTime),
+		lastRetarget: time.Now(),
+	}
+}
+
+// onShare accounts a valid share and schedules a retarget if share rate left the window
+func (v *vardiff) onShare(now time.Time, current int64) {
+	v.Lock()
+	defer v.Unlock()
+
+	v.shares++
+	if diff, ok := v.retarget(now, current); ok {
+		v.pending = diff
+	}
+}
+
+// onIdle is checked on job broadcast, so sessions that stopped submitting are retargeted as well
+func (v *vardiff) onIdle(now time.Time, current int64) (int64, bool) {
+	v.Lock()
+	defer v.Unlock()
+
+	if v.shares > 0 {
+		return current, false
+	}
+	return v.retarget(now, current)
+}
+
+// takePending returns scheduled difficulty and resets it
+func (v *vardiff) takePending() (int64, bool) {
+	v.Lock()
+	defer v.Unlock()
+
+	diff := v.pending
+	v.pending = 0
+	return diff, diff > 0
+}
+
+func (v *vardiff) retarget(now time.Time, current int64) (int64, bool) {
+	elapsed := now.Sub(v.lastRetarget)
+	if elapsed < v.retargetIntv {
+		return current, false
+	}
+	rate := float64(v.shares) / elapsed.Minutes()
+	v.lastRetarget = now
+	v.shares = 0
+
+	if rate >= v.config.MinSharesPerMin && rate <= v.config.MaxSharesPerMin {
+		return current, false
+	}
+
+	// diff / rate is constant for given hashrate, so scale diff towards the middle of the window
+	targetRate := (v.config.MinSharesPerMin + v.config.MaxSharesPerMin) / 2
+	factor := rate / targetRate
+	if factor > maxRetargetFactor {
+		factor = maxRetargetFactor
+	} else if factor < 1/maxRetargetFactor {
+		factor = 1 / maxRetargetFactor
+	}
+	next := v.clamp(int64(float64(current) * factor))
+	return next, next != current
+}
+
+func (v *vardiff) clamp(diff int64) int64 {
+	if v.config.MinDiff > 0 && diff < v.config.MinDiff {
+		return v.config.MinDiff
+	}
+	if v.config.MaxDiff > 0 && diff > v.config.MaxDiff {
+		return v.config.MaxDiff
+	}
+	return diff
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testVarDiff(min, max int64) (*vardiff, time.Time) {
+	v := newVarDiff(&VarDiff{Enabled: true, MinDiff: min, MaxDiff: max, MinSharesPerMin: 10, MaxSharesPerMin: 20, RetargetTime: "60s"})
+	return v, v.lastRetarget
+}
+
+func TestVarDiffOnShare(t *testing.T) {
+	tests := []struct {
+		name     string
+		min, max int64
+		shares   int
+		pending  int64
+	}{
+		{"retarget up", 0, 0, 30, 2000},
+		{"retarget down", 0, 0, 5, 333},
+		{"within band", 0, 0, 15, 0},
+		{"lower band edge", 0, 0, 10, 0},
+		{"upper band edge", 0, 0, 20, 0},
+		{"limit factor up", 0, 0, 100, 4000},
+		{"limit factor down", 0, 0, 1, 250},
+		{"clamp at max", 0, 3000, 100, 3000},
+		{"clamp at min", 500, 0, 1, 500},
+		{"already at max", 0, 1000, 30, 0},
+	}
+	for _, tt := range tests {
+		v, start := testVarDiff(tt.min, tt.max)
+		// Shares within retarget time never retarget, the last one ends the interval
+		for i := 1; i < tt.shares; i++ {
+			v.onShare(start.Add(time.Second), 1000)
+		}
+		if _, ok := v.takePending(); ok {
+			t.Errorf("%s: must not retarget before retarget time", tt.name)
+		}
+		v.onShare(start.Add(time.Minute), 1000)
+		diff, ok := v.takePending()
+		if diff != tt.pending || ok != (tt.pending > 0) {
+			t.Errorf("%s: pending difficulty %v, want %v", tt.name, diff, tt.pending)
+		}
+		if _, ok := v.takePending(); ok {
+			t.Errorf("%s: pending difficulty must be taken once", tt.name)
+		}
+	}
+}
+
+func TestVarDiffOnIdle(t *testing.T) {
+	v, start := testVarDiff(0, 0)
+	if _, ok := v.onIdle(start.Add(time.Second), 1000); ok {
+		t.Error("Must not retarget before retarget time")
+	}
+	if diff, ok := v.onIdle(start.Add(time.Minute), 1000); !ok || diff != 250 {
+		t.Errorf("Idle session must be retargeted down, got %v", diff)
+	}
+
+	v, start = testVarDiff(800, 0)
+	if diff, ok := v.onIdle(start.Add(time.Minute), 1000); !ok || diff != 800 {
+		t.Errorf("Idle session must be clamped at min difficulty, got %v", diff)
+	}
+	if _, ok := v.onIdle(start.Add(2*time.Minute), 800); ok {
+		t.Error("Must not retarget session already at min difficulty")
+	}
+
+	// Submitting session is retargeted on shares only
+	v, start = testVarDiff(0, 0)
+	v.onShare(start.Add(time.Second), 1000)
+	if _, ok := v.onIdle(start.Add(time.Minute), 1000); ok {
+		t.Error("Must not retarget session with shares on idle check")
+	}
+}
+
+func TestPreviousDifficultyGrace(t *testing.T) {
+	cs := &Session{diff: 1000}
+	cs.setDifficulty(4000)
+	if cs.difficulty() != 4000 || cs.previousDifficulty() != 1000 {
+		t.Fatalf("Previous difficulty must be accepted after retarget, got %v", cs.previousDifficulty())
+	}
+	atomic.StoreInt64(&cs.prevDiffUntil, 1)
+	if cs.previousDifficulty() != 0 {
+		t.Error("Previous difficulty must expire after grace period")
+	}
+}
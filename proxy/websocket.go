@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/etclabscore/core-pool/library/logger"
+	"github.com/etclabscore/core-pool/library/websocket"
+)
+
+// WebSocket port carries the same line-delimited stratum messages,
+// upgraded connection is served by the usual session code.
+func (s *ProxyServer) serveWebSocket(port *stratumPort, l net.Listener) {
+	cfg := port.config
+	r := http.NewServeMux()
+	r.HandleFunc(cfg.Path, func(w http.ResponseWriter, r *http.Request) {
+		ip := s.remoteAddr(r)
+		if s.policy.IsBanned(ip) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		port.acquire()
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			logger.Warn("WebSocket handshake from %s failed: %v", ip, err)
+			port.release()
+			return
+		}
+		s.startSession(port, conn, ip)
+	})
+	srv := &http.Server{Handler: r}
+	// Connection in HTTP handshake is closed by drain too, upgraded one is tracked by session
+	srv.ConnState = func(conn net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			s.trackConn(conn)
+		case http.StateHijacked, http.StateClosed:
+			s.untrackConn(conn)
+		}
+	}
+
+	logger.Info("Stratum WebSocket port %s listening on %s%s, difficulty %v", cfg.Name, cfg.Listen, cfg.Path, port.difficulty)
+	err := srv.Serve(l)
+	if !s.isListening(l) {
+		logger.Info("Stratum listener %s closed", cfg.Listen)
+		return
+	}
+	logger.Fatal("Failed to serve stratum WebSocket: %v", err)
+}
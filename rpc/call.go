@@ -0,0 +1,350 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultBackoff      = 200 * time.Millisecond
+	defaultMaxBackoff   = 5 * time.Second
+	defaultMaxBatchSize = 100
+)
+
+// CallPolicy configures per method timeouts and retries of idempotent calls
+type CallPolicy struct {
+	// Retries of failed idempotent calls, 0 disables retrying
+	Retries    int    `json:"retries"`
+	Backoff    string `json:"backoff"`
+	MaxBackoff string `json:"maxBackoff"`
+	// Method name to timeout, overrides client timeout
+	Timeouts map[string]string `json:"timeouts"`
+	// Requests per JSON-RPC batch, larger batches are split
+	MaxBatchSize int `json:"maxBatchSize"`
+}
+
+type callPolicy struct {
+	timeout      time.Duration
+	timeouts     map[string]time.Duration
+	retries      int
+	backoff      time.Duration
+	maxBackoff   time.Duration
+	maxBatchSize int
+}
+
+func newCallPolicy(timeout string, cfg *CallPolicy) (callPolicy, error) {
+	p := callPolicy{
+		timeouts:     make(map[string]time.Duration),
+		backoff:      defaultBackoff,
+		maxBackoff:   defaultMaxBackoff,
+		maxBatchSize: defaultMaxBatchSize,
+	}
+	var err error
+	if p.timeout, err = time.ParseDuration(timeout); err != nil {
+		return p, err
+	}
+	if cfg == nil {
+		return p, nil
+	}
+	p.retries = cfg.Retries
+	if len(cfg.Backoff) > 0 {
+		if p.backoff, err = time.ParseDuration(cfg.Backoff); err != nil {
+			return p, err
+		}
+	}
+	if len(cfg.MaxBackoff) > 0 {
+		if p.maxBackoff, err = time.ParseDuration(cfg.MaxBackoff); err != nil {
+			return p, err
+		}
+	}
+	for method, v := range cfg.Timeouts {
+		if p.timeouts[method], err = time.ParseDuration(v); err != nil {
+			return p, fmt.Errorf("invalid timeout of %s: %v", method, err)
+		}
+	}
+	if cfg.MaxBatchSize > 0 {
+		p.maxBatchSize = cfg.MaxBatchSize
+	}
+	return p, nil
+}
+
+// Read-only methods which are safe to send again
+var idempotentMethods = map[string]bool{
+	"eth_getWork":                       true,
+	"eth_syncing":                       true,
+	"eth_chainId":                       true,
+	"net_peerCount":                     true,
+	"eth_getBalance":                    true,
+	"eth_getBlockByNumber":              true,
+	"eth_getBlockByHash":                true,
+	"eth_getUncleByBlockNumberAndIndex": true,
+	"eth_getTransactionReceipt":         true,
+	"eth_getBlockReceipts":              true,
+}
+
+func isIdempotent(method string) bool {
+	return idempotentMethods[method]
+}
+
+// Timeout of method including per method override
+func (r *RPCClient) Timeout(method string) time.Duration {
+	if t, ok := r.policy.timeouts[method]; ok {
+		return t
+	}
+	return r.policy.timeout
+}
+
+// withRetry runs call with its own timeout, failed idempotent calls are repeated with exponential backoff
+func (r *RPCClient) withRetry(ctx context.Context, timeout time.Duration, idempotent bool, call func(ctx context.Context) error) error {
+	backoff := r.policy.backoff
+	for attempt := 0; ; attempt++ {
+		callCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := call(callCtx)
+		cancel()
+		if err == nil || !idempotent || attempt >= r.policy.retries || ctx.Err() != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > r.policy.maxBackoff {
+			backoff = r.policy.maxBackoff
+		}
+	}
+}
+
+type jsonRequest struct {
+	Version string      `json:"jsonrpc"`
+	Id      uint64      `json:"id"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+func replyId(resp *JSONRpcResp) uint64 {
+	if resp.Id == nil {
+		return 0
+	}
+	id, err := strconv.ParseUint(strings.Trim(string(*resp.Id), `"`), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return id
+}
+
+// Error is JSON-RPC error reply of node
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func replyError(e map[string]interface{}) error {
+	err := &Error{}
+	if code, ok := e["code"].(float64); ok {
+		err.Code = int(code)
+	}
+	if msg, ok := e["message"].(string); ok {
+		err.Message = msg
+	} else {
+		err.Message = fmt.Sprintf("rpc error %v", e)
+	}
+	return err
+}
+
+// IsMethodNotFound reports whether node doesn't implement requested method
+func IsMethodNotFound(err error) bool {
+	e, ok := err.(*Error)
+	if !ok {
+		return false
+	}
+	msg := strings.ToLower(e.Message)
+	return e.Code == -32601 || strings.Contains(msg, "not found") || strings.Contains(msg, "does not exist") ||
+		strings.Contains(msg, "not supported") || strings.Contains(msg, "not available")
+}
+
+// Counters returns number of round trips to node and JSON-RPC requests sent in them
+func (r *RPCClient) Counters() (calls, requests uint64) {
+	return atomic.LoadUint64(&r.calls), atomic.LoadUint64(&r.requests)
+}
+
+// BatchElem is a single request of batch call
+type BatchElem struct {
+	Method string
+	Params interface{}
+	// Pointer to decode result into, stays untouched if node replies with null
+	Result interface{}
+	// Error of this request only
+	Error error
+}
+
+// BatchCall sends requests as JSON-RPC arrays of at most MaxBatchSize elements,
+// returned error means the whole batch failed, errors of single requests are set on elements
+func (r *RPCClient) BatchCall(ctx context.Context, batch []BatchElem) error {
+	for start := 0; start < len(batch); start += r.policy.maxBatchSize {
+		end := start + r.policy.maxBatchSize
+		if end > len(batch) {
+			end = len(batch)
+		}
+		if err := r.batchCall(ctx, batch[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *RPCClient) batchCall(ctx context.Context, batch []BatchElem) error {
+	idempotent := true
+	var timeout time.Duration
+	for _, e := range batch {
+		idempotent = idempotent && isIdempotent(e.Method)
+		if t := r.Timeout(e.Method); t > timeout {
+			timeout = t
+		}
+	}
+
+	// Like doPost, only failure to reach the node makes it sick
+	var unreachable bool
+	err := r.withRetry(ctx, timeout, idempotent, func(ctx context.Context) error {
+		unreachable = false
+		reqs := make([]jsonRequest, len(batch))
+		index := make(map[uint64]int, len(batch))
+		for i, e := range batch {
+			id := atomic.AddUint64(&r.seq, 1)
+			reqs[i] = jsonRequest{Version: "2.0", Id: id, Method: e.Method, Params: e.Params}
+			index[id] = i
+		}
+		data, err := json.Marshal(reqs)
+		if err != nil {
+			return err
+		}
+		atomic.AddUint64(&r.calls, 1)
+		atomic.AddUint64(&r.requests, uint64(len(reqs)))
+		reply, err := r.transport.call(ctx, data)
+		if err != nil {
+			unreachable = true
+			return err
+		}
+		var resps []*JSONRpcResp
+		if err = json.Unmarshal(reply, &resps); err != nil {
+			return err
+		}
+
+		for i := range batch {
+			batch[i].Error = errors.New("no reply in batch")
+		}
+		for _, resp := range resps {
+			if resp == nil {
+				continue
+			}
+			i, ok := index[replyId(resp)]
+			if !ok {
+				continue
+			}
+			e := &batch[i]
+			switch {
+			case resp.Error != nil:
+				e.Error = replyError(resp.Error)
+			case resp.Result != nil && e.Result != nil:
+				e.Error = json.Unmarshal(*resp.Result, e.Result)
+			default:
+				e.Error = nil
+			}
+		}
+		return nil
+	})
+	if unreachable {
+		r.markSick()
+	}
+	return err
+}
+
+// GetBlocksByHeight fetches full blocks in batches, missing blocks are nil
+func (r *RPCClient) GetBlocksByHeight(ctx context.Context, heights []int64) ([]*GetBlockReply, error) {
+	blocks := make([]*GetBlockReply, len(heights))
+	batch := make([]BatchElem, len(heights))
+	for i, height := range heights {
+		batch[i] = BatchElem{
+			Method: "eth_getBlockByNumber",
+			Params: []interface{}{fmt.Sprintf("0x%x", height), true},
+			Result: &blocks[i],
+		}
+	}
+	if err := r.BatchCall(ctx, batch); err != nil {
+		return nil, err
+	}
+	for i, e := range batch {
+		if e.Error != nil {
+			return nil, fmt.Errorf("block %v: %v", heights[i], e.Error)
+		}
+	}
+	return blocks, nil
+}
+
+// GetBlockReceipts fetches all receipts of block in one request, not every node implements it
+func (r *RPCClient) GetBlockReceipts(ctx context.Context, height int64) ([]*TxReceipt, error) {
+	rpcResp, err := r.doPost(ctx, "eth_getBlockReceipts", []string{fmt.Sprintf("0x%x", height)})
+	if err != nil {
+		return nil, err
+	}
+	var reply []*TxReceipt
+	if rpcResp.Result != nil {
+		err = json.Unmarshal(*rpcResp.Result, &reply)
+	}
+	return reply, err
+}
+
+// GetUncles fetches uncles by block height and index in batches
+func (r *RPCClient) GetUncles(ctx context.Context, heights []int64, indexes []int) ([]*GetBlockReply, error) {
+	uncles := make([]*GetBlockReply, len(heights))
+	batch := make([]BatchElem, len(heights))
+	for i, height := range heights {
+		batch[i] = BatchElem{
+			Method: "eth_getUncleByBlockNumberAndIndex",
+			Params: []interface{}{fmt.Sprintf("0x%x", height), fmt.Sprintf("0x%x", indexes[i])},
+			Result: &uncles[i],
+		}
+	}
+	if err := r.BatchCall(ctx, batch); err != nil {
+		return nil, err
+	}
+	for i, e := range batch {
+		if e.Error != nil {
+			return nil, fmt.Errorf("uncle %v of block %v: %v", indexes[i], heights[i], e.Error)
+		}
+	}
+	return uncles, nil
+}
+
+// GetTxReceipts fetches receipts in batches, receipts of unknown transactions are nil
+func (r *RPCClient) GetTxReceipts(ctx context.Context, hashes []string) ([]*TxReceipt, error) {
+	receipts := make([]*TxReceipt, len(hashes))
+	batch := make([]BatchElem, len(hashes))
+	for i, hash := range hashes {
+		batch[i] = BatchElem{
+			Method: "eth_getTransactionReceipt",
+			Params: []string{hash},
+			Result: &receipts[i],
+		}
+	}
+	if err := r.BatchCall(ctx, batch); err != nil {
+		return nil, err
+	}
+	for i, e := range batch {
+		if e.Error != nil {
+			return nil, fmt.Errorf("receipt of %v: %v", hashes[i], e.Error)
+		}
+	}
+	return receipts, nil
+}
@@ -0,0 +1,195 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryIdempotentCalls(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req jsonRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.Id, "result": "0x10"})
+	}))
+	defer srv.Close()
+
+	r, err := NewClient("node", srv.URL, "1s", nil, &CallPolicy{Retries: 2, Backoff: "1ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := r.GetPeerCount(context.Background())
+	if err != nil || n != 16 {
+		t.Fatalf("Must succeed after retries, got %v, %v", n, err)
+	}
+
+	atomic.StoreInt32(&calls, 0)
+	if _, err = r.SendTransaction(context.Background(), "0x0", "0x1", "", "", "0x1", true); err == nil {
+		t.Error("Must not retry transaction")
+	}
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("Transaction must be sent once, sent %v times", calls)
+	}
+}
+
+func TestCallTimeoutAndCancel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	r, _ := NewClient("node", srv.URL, "1s", nil, &CallPolicy{Timeouts: map[string]string{"eth_getWork": "20ms"}})
+	start := time.Now()
+	if _, err := r.GetWork(context.Background()); err == nil || time.Since(start) > 500*time.Millisecond {
+		t.Errorf("Must time out with per method timeout, got %v in %v", err, time.Since(start))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := r.GetPeerCount(ctx); err == nil {
+		t.Error("Must fail on cancelled context")
+	}
+}
+
+func TestBatchCall(t *testing.T) {
+	var batches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&batches, 1)
+		var reqs []jsonRequest
+		json.NewDecoder(r.Body).Decode(&reqs)
+		resps := make([]map[string]interface{}, 0, len(reqs))
+		// Replies may come in any order
+		for i := len(reqs) - 1; i >= 0; i-- {
+			req := reqs[i]
+			resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.Id}
+			switch hash := req.Params.([]interface{})[0]; hash {
+			case "0xbad":
+				resp["error"] = map[string]interface{}{"code": -32000, "message": "bad hash"}
+			case "0xunknown":
+				resp["result"] = nil
+			default:
+				resp["result"] = map[string]string{"transactionHash": hash.(string), "gasUsed": "0x5208"}
+			}
+			resps = append(resps, resp)
+		}
+		json.NewEncoder(w).Encode(resps)
+	}))
+	defer srv.Close()
+
+	r, _ := NewClient("node", srv.URL, "1s", nil, &CallPolicy{MaxBatchSize: 2})
+	receipts, err := r.GetTxReceipts(context.Background(), []string{"0x1", "0xunknown", "0x3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&batches) != 2 {
+		t.Errorf("Must split into 2 batches, sent %v", batches)
+	}
+	if receipts[0].TxHash != "0x1" || receipts[1] != nil || receipts[2].TxHash != "0x3" {
+		t.Errorf("Receipts must be matched by id: %+v", receipts)
+	}
+
+	batch := []BatchElem{
+		{Method: "eth_getTransactionReceipt", Params: []string{"0xbad"}},
+		{Method: "eth_getTransactionReceipt", Params: []string{"0x2"}, Result: new(TxReceipt)},
+	}
+	if err = r.BatchCall(context.Background(), batch); err != nil {
+		t.Fatal(err)
+	}
+	if batch[0].Error == nil || batch[0].Error.Error() != "bad hash" || batch[1].Error != nil {
+		t.Errorf("Errors must be set per element: %v, %v", batch[0].Error, batch[1].Error)
+	}
+}
+
+func TestSickOnTransportErrorsOnly(t *testing.T) {
+	var fail int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req jsonRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if atomic.LoadInt32(&fail) > 0 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.Id,
+			"error": map[string]interface{}{"code": -32000, "message": "invalid share"}})
+	}))
+	defer srv.Close()
+
+	r, err := NewClient("node", srv.URL, "1s", nil, &CallPolicy{Backoff: "1ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err = r.GetPeerCount(context.Background()); err == nil {
+			t.Fatal("Must return error reply")
+		}
+	}
+	if r.Sick() {
+		t.Error("Error replies must not make node sick")
+	}
+
+	atomic.StoreInt32(&fail, 1)
+	for i := 0; i < 5; i++ {
+		r.GetPeerCount(context.Background())
+	}
+	if !r.Sick() {
+		t.Error("Transport errors must make node sick")
+	}
+}
+
+func TestBatchSickOnTransportErrorsOnly(t *testing.T) {
+	var mode int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reqs []jsonRequest
+		json.NewDecoder(r.Body).Decode(&reqs)
+		switch atomic.LoadInt32(&mode) {
+		case 0:
+			resps := make([]map[string]interface{}, len(reqs))
+			for i, req := range reqs {
+				resps[i] = map[string]interface{}{"jsonrpc": "2.0", "id": req.Id,
+					"error": map[string]interface{}{"code": -32000, "message": "header not found"}}
+			}
+			json.NewEncoder(w).Encode(resps)
+		case 1:
+			w.Write([]byte("<html>maintenance</html>"))
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+
+	r, err := NewClient("node", srv.URL, "1s", nil, &CallPolicy{Backoff: "1ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []int32{0, 1} {
+		atomic.StoreInt32(&mode, m)
+		for i := 0; i < 10; i++ {
+			r.BatchCall(context.Background(), []BatchElem{{Method: "eth_getBlockByNumber", Params: []interface{}{"0x1", false}}})
+		}
+		if r.Sick() {
+			t.Errorf("Replies of reachable node must not make it sick, mode %d", m)
+		}
+	}
+
+	atomic.StoreInt32(&mode, 2)
+	for i := 0; i < 5; i++ {
+		if err = r.BatchCall(context.Background(), []BatchElem{{Method: "eth_getBlockByNumber"}}); err == nil {
+			t.Fatal("Must fail on transport error")
+		}
+	}
+	if !r.Sick() {
+		t.Error("Transport errors must make node sick")
+	}
+}
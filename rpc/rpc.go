@@ -1,16 +1,17 @@
 package rpc
 
 import (
-	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"math/big"
-	"net/http"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common/hexutil"
 
@@ -18,13 +19,19 @@ import (
 )
 
 type RPCClient struct {
+	// Accessed atomically, kept first for 64-bit alignment
+	seq      uint64
+	calls    uint64
+	requests uint64
+
 	sync.RWMutex
 	Url         string
 	Name        string
 	sick        bool
 	sickRate    int
 	successRate int
-	client      *http.Client
+	transport   transport
+	policy      callPolicy
 }
 
 type GetBlockReply struct {
@@ -80,17 +87,22 @@ type JSONRpcResp struct {
 	Error  map[string]interface{} `json:"error"`
 }
 
-func NewRPCClient(name, url, timeout string) *RPCClient {
-	rpcClient := &RPCClient{Name: name, Url: url}
-	timeoutIntv := util.MustParseDuration(timeout)
-	rpcClient.client = &http.Client{
-		Timeout: timeoutIntv,
+// NewClient makes client of HTTP or IPC endpoint with optional auth and call policy,
+// fails if credentials can't be loaded or policy is invalid
+func NewClient(name, url, timeout string, auth *Auth, policy *CallPolicy) (*RPCClient, error) {
+	creds, err := newCredentials(auth)
+	if err != nil {
+		return nil, err
+	}
+	p, err := newCallPolicy(timeout, policy)
+	if err != nil {
+		return nil, err
 	}
-	return rpcClient
+	return &RPCClient{Name: name, Url: url, transport: newTransport(url, creds), policy: p}, nil
 }
 
-func (r *RPCClient) GetWork() ([]string, error) {
-	rpcResp, err := r.doPost(r.Url, "eth_getWork", []string{})
+func (r *RPCClient) GetWork(ctx context.Context) ([]string, error) {
+	rpcResp, err := r.doPost(ctx, "eth_getWork", []string{})
 	if err != nil {
 		return nil, err
 	}
@@ -99,8 +111,8 @@ func (r *RPCClient) GetWork() ([]string, error) {
 	return reply, err
 }
 
-func (r *RPCClient) GetLatestBlock() (*GetBlockReplyPart, error) {
-	rpcResp, err := r.doPost(r.Url, "eth_getBlockByNumber", []interface{}{"latest", false})
+func (r *RPCClient) GetLatestBlock(ctx context.Context) (*GetBlockReplyPart, error) {
+	rpcResp, err := r.doPost(ctx, "eth_getBlockByNumber", []interface{}{"latest", false})
 	if err != nil {
 		return nil, err
 	}
@@ -112,23 +124,23 @@ func (r *RPCClient) GetLatestBlock() (*GetBlockReplyPart, error) {
 	return nil, nil
 }
 
-func (r *RPCClient) GetBlockByHeight(height int64) (*GetBlockReply, error) {
+func (r *RPCClient) GetBlockByHeight(ctx context.Context, height int64) (*GetBlockReply, error) {
 	params := []interface{}{fmt.Sprintf("0x%x", height), true}
-	return r.getBlockBy("eth_getBlockByNumber", params)
+	return r.getBlockBy(ctx, "eth_getBlockByNumber", params)
 }
 
-func (r *RPCClient) GetBlockByHash(hash string) (*GetBlockReply, error) {
+func (r *RPCClient) GetBlockByHash(ctx context.Context, hash string) (*GetBlockReply, error) {
 	params := []interface{}{hash, true}
-	return r.getBlockBy("eth_getBlockByHash", params)
+	return r.getBlockBy(ctx, "eth_getBlockByHash", params)
 }
 
-func (r *RPCClient) GetUncleByBlockNumberAndIndex(height int64, index int) (*GetBlockReply, error) {
+func (r *RPCClient) GetUncleByBlockNumberAndIndex(ctx context.Context, height int64, index int) (*GetBlockReply, error) {
 	params := []interface{}{fmt.Sprintf("0x%x", height), fmt.Sprintf("0x%x", index)}
-	return r.getBlockBy("eth_getUncleByBlockNumberAndIndex", params)
+	return r.getBlockBy(ctx, "eth_getUncleByBlockNumberAndIndex", params)
 }
 
-func (r *RPCClient) getBlockBy(method string, params []interface{}) (*GetBlockReply, error) {
-	rpcResp, err := r.doPost(r.Url, method, params)
+func (r *RPCClient) getBlockBy(ctx context.Context, method string, params []interface{}) (*GetBlockReply, error) {
+	rpcResp, err := r.doPost(ctx, method, params)
 	if err != nil {
 		return nil, err
 	}
@@ -140,8 +152,8 @@ func (r *RPCClient) getBlockBy(method string, params []interface{}) (*GetBlockRe
 	return nil, nil
 }
 
-func (r *RPCClient) GetTxReceipt(hash string) (*TxReceipt, error) {
-	rpcResp, err := r.doPost(r.Url, "eth_getTransactionReceipt", []string{hash})
+func (r *RPCClient) GetTxReceipt(ctx context.Context, hash string) (*TxReceipt, error) {
+	rpcResp, err := r.doPost(ctx, "eth_getTransactionReceipt", []string{hash})
 	if err != nil {
 		return nil, err
 	}
@@ -153,8 +165,8 @@ func (r *RPCClient) GetTxReceipt(hash string) (*TxReceipt, error) {
 	return nil, nil
 }
 
-func (r *RPCClient) SubmitBlock(params []string) (bool, error) {
-	rpcResp, err := r.doPost(r.Url, "eth_submitWork", params)
+func (r *RPCClient) SubmitBlock(ctx context.Context, params []string) (bool, error) {
+	rpcResp, err := r.doPost(ctx, "eth_submitWork", params)
 	if err != nil {
 		return false, err
 	}
@@ -163,8 +175,8 @@ func (r *RPCClient) SubmitBlock(params []string) (bool, error) {
 	return reply, err
 }
 
-func (r *RPCClient) GetBalance(address string) (*big.Int, error) {
-	rpcResp, err := r.doPost(r.Url, "eth_getBalance", []string{address, "latest"})
+func (r *RPCClient) GetBalance(ctx context.Context, address string) (*big.Int, error) {
+	rpcResp, err := r.doPost(ctx, "eth_getBalance", []string{address, "latest"})
 	if err != nil {
 		return nil, err
 	}
@@ -176,9 +188,9 @@ func (r *RPCClient) GetBalance(address string) (*big.Int, error) {
 	return util.String2Big(reply), err
 }
 
-func (r *RPCClient) Sign(from string, s string) (string, error) {
+func (r *RPCClient) Sign(ctx context.Context, from string, s string) (string, error) {
 	hash := sha256.Sum256([]byte(s))
-	rpcResp, err := r.doPost(r.Url, "eth_sign", []string{from, hexutil.Encode(hash[:])})
+	rpcResp, err := r.doPost(ctx, "eth_sign", []string{from, hexutil.Encode(hash[:])})
 	var reply string
 	if err != nil {
 		return reply, err
@@ -193,8 +205,8 @@ func (r *RPCClient) Sign(from string, s string) (string, error) {
 	return reply, err
 }
 
-func (r *RPCClient) GetPeerCount() (int64, error) {
-	rpcResp, err := r.doPost(r.Url, "net_peerCount", nil)
+func (r *RPCClient) GetPeerCount(ctx context.Context) (int64, error) {
+	rpcResp, err := r.doPost(ctx, "net_peerCount", nil)
 	if err != nil {
 		return 0, err
 	}
@@ -206,7 +218,20 @@ func (r *RPCClient) GetPeerCount() (int64, error) {
 	return strconv.ParseInt(strings.Replace(reply, "0x", "", -1), 16, 64)
 }
 
-func (r *RPCClient) SendTransaction(from, to, gas, gasPrice, value string, autoGas bool) (string, error) {
+func (r *RPCClient) GetChainID(ctx context.Context) (uint64, error) {
+	rpcResp, err := r.doPost(ctx, "eth_chainId", nil)
+	if err != nil {
+		return 0, err
+	}
+	var reply string
+	err = json.Unmarshal(*rpcResp.Result, &reply)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.Replace(reply, "0x", "", -1), 16, 64)
+}
+
+func (r *RPCClient) SendTransaction(ctx context.Context, from, to, gas, gasPrice, value string, autoGas bool) (string, error) {
 	params := map[string]string{
 		"from":  from,
 		"to":    to,
@@ -216,7 +241,7 @@ func (r *RPCClient) SendTransaction(from, to, gas, gasPrice, value string, autoG
 		params["gas"] = gas
 		params["gasPrice"] = gasPrice
 	}
-	rpcResp, err := r.doPost(r.Url, "eth_sendTransaction", []interface{}{params})
+	rpcResp, err := r.doPost(ctx, "eth_sendTransaction", []interface{}{params})
 	var reply string
 	if err != nil {
 		return reply, err
@@ -235,37 +260,50 @@ func (r *RPCClient) SendTransaction(from, to, gas, gasPrice, value string, autoG
 	return reply, err
 }
 
-func (r *RPCClient) doPost(url string, method string, params interface{}) (*JSONRpcResp, error) {
-	jsonReq := map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params, "id": 0}
-	data, _ := json.Marshal(jsonReq)
-
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(data))
-	req.Header.Set("Content-Length", (string)(len(data)))
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
-
-	resp, err := r.client.Do(req)
-	if err != nil {
+func (r *RPCClient) doPost(ctx context.Context, method string, params interface{}) (*JSONRpcResp, error) {
+	var rpcResp *JSONRpcResp
+	var unreachable bool
+	err := r.withRetry(ctx, r.Timeout(method), isIdempotent(method), func(ctx context.Context) error {
+		unreachable = false
+		id := atomic.AddUint64(&r.seq, 1)
+		data, err := json.Marshal(&jsonRequest{Version: "2.0", Id: id, Method: method, Params: params})
+		if err != nil {
+			return err
+		}
+		atomic.AddUint64(&r.calls, 1)
+		atomic.AddUint64(&r.requests, 1)
+		reply, err := r.transport.call(ctx, data)
+		if err != nil {
+			unreachable = true
+			return err
+		}
+		rpcResp = nil
+		if err = json.Unmarshal(reply, &rpcResp); err != nil {
+			return err
+		}
+		if rpcResp == nil {
+			return errors.New("empty reply")
+		}
+		if replyId(rpcResp) != id {
+			return fmt.Errorf("reply id mismatch for %s", method)
+		}
+		return nil
+	})
+	// Node replied, malformed reply or error like rejected share doesn't make it sick
+	if unreachable {
 		r.markSick()
-		return nil, err
 	}
-	defer resp.Body.Close()
-
-	var rpcResp *JSONRpcResp
-	err = json.NewDecoder(resp.Body).Decode(&rpcResp)
 	if err != nil {
-		r.markSick()
 		return nil, err
 	}
 	if rpcResp.Error != nil {
-		r.markSick()
-		return nil, errors.New(rpcResp.Error["message"].(string))
+		return nil, replyError(rpcResp.Error)
 	}
-	return rpcResp, err
+	return rpcResp, nil
 }
 
-func (r *RPCClient) Check() bool {
-	_, err := r.GetWork()
+func (r *RPCClient) Check(ctx context.Context) bool {
+	_, err := r.GetWork(ctx)
 	if err != nil {
 		return false
 	}
@@ -273,6 +311,71 @@ func (r *RPCClient) Check() bool {
 	return !r.Sick()
 }
 
+// Result of a single health probe of the node
+type NodeHealth struct {
+	Alive   bool
+	Syncing bool
+	// -1 if not probed or node doesn't expose net namespace
+	Peers   int64
+	Height  uint64
+	Latency time.Duration
+	Err     error
+}
+
+// Health probes work availability, sync status, peer count if asked and head height of the node.
+// Sync status and peer count of node not implementing their methods are left unknown.
+func (r *RPCClient) Health(ctx context.Context, peers bool) *NodeHealth {
+	h := &NodeHealth{Peers: -1}
+	start := time.Now()
+	defer func() {
+		h.Latency = time.Since(start)
+	}()
+
+	if _, h.Err = r.GetWork(ctx); h.Err != nil {
+		return h
+	}
+	r.markAlive()
+	h.Alive = true
+
+	var err error
+	if h.Syncing, err = r.Syncing(ctx); err != nil && !IsMethodNotFound(err) {
+		h.Err = err
+		return h
+	}
+	if peers {
+		n, err := r.GetPeerCount(ctx)
+		switch {
+		case err == nil:
+			h.Peers = n
+		case !IsMethodNotFound(err):
+			h.Err = err
+			return h
+		}
+	}
+	block, err := r.GetLatestBlock(ctx)
+	if err != nil {
+		h.Err = err
+		return h
+	}
+	if block != nil {
+		h.Height, h.Err = strconv.ParseUint(strings.Replace(block.Number, "0x", "", -1), 16, 64)
+	}
+	return h
+}
+
+func (r *RPCClient) Syncing(ctx context.Context) (bool, error) {
+	rpcResp, err := r.doPost(ctx, "eth_syncing", []string{})
+	if err != nil {
+		return false, err
+	}
+	// Node replies with false when synced and with progress object otherwise
+	var reply bool
+	if err = json.Unmarshal(*rpcResp.Result, &reply); err == nil {
+		return reply, nil
+	}
+	return true, nil
+}
+
 func (r *RPCClient) Sick() bool {
 	r.RLock()
 	defer r.RUnlock()
@@ -0,0 +1,142 @@
+package rpc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Subscription streams eth_subscribe notifications of a node over WebSocket or IPC
+type Subscription struct {
+	Url    string
+	conn   net.Conn
+	dec    *json.Decoder
+	topics map[string]string
+	// Notifications received while waiting for subscribe replies
+	queued []*subscribeMsg
+}
+
+type subscribeReq struct {
+	Version string        `json:"jsonrpc"`
+	Id      int           `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type subscribeMsg struct {
+	Id     *int                   `json:"id"`
+	Method string                 `json:"method"`
+	Result *json.RawMessage       `json:"result"`
+	Error  map[string]interface{} `json:"error"`
+	Params struct {
+		Subscription string `json:"subscription"`
+	} `json:"params"`
+}
+
+// Subscribe opens subscriptions for topics node supports, fails only if none of them is supported
+func Subscribe(url string, timeout time.Duration, auth *Auth, topics ...string) (*Subscription, error) {
+	creds, err := newCredentials(auth)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialNode(url, timeout, creds)
+	if err != nil {
+		return nil, err
+	}
+	return newSubscription(url, conn, timeout, topics)
+}
+
+// newSubscription subscribes topics one by one over connected node
+func newSubscription(url string, conn net.Conn, timeout time.Duration, topics []string) (*Subscription, error) {
+	s := &Subscription{Url: url, conn: conn, dec: json.NewDecoder(conn), topics: make(map[string]string)}
+	enc := json.NewEncoder(conn)
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	var errs []string
+	for i, topic := range topics {
+		req := subscribeReq{Version: "2.0", Id: i + 1, Method: "eth_subscribe", Params: []interface{}{topic}}
+		if err := enc.Encode(&req); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		msg, err := s.reply(req.Id)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if msg.Error != nil || msg.Result == nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", topic, msg.Error["message"]))
+			continue
+		}
+		var id string
+		if err = json.Unmarshal(*msg.Result, &id); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		s.topics[id] = topic
+	}
+	conn.SetDeadline(time.Time{})
+
+	if len(s.topics) == 0 {
+		conn.Close()
+		return nil, errors.New("no subscription accepted, " + strings.Join(errs, ", "))
+	}
+	return s, nil
+}
+
+// reply skips messages until reply to request id, notifications of
+// already accepted subscriptions may come first and are kept for Next
+func (s *Subscription) reply(id int) (*subscribeMsg, error) {
+	for {
+		msg := &subscribeMsg{}
+		if err := s.dec.Decode(msg); err != nil {
+			return nil, err
+		}
+		if msg.Method == "eth_subscription" {
+			s.queued = append(s.queued, msg)
+			continue
+		}
+		if msg.Id != nil && *msg.Id == id {
+			return msg, nil
+		}
+	}
+}
+
+// Topics of accepted subscriptions
+func (s *Subscription) Topics() []string {
+	topics := make([]string, 0, len(s.topics))
+	for _, t := range s.topics {
+		topics = append(topics, t)
+	}
+	return topics
+}
+
+// Next blocks until notification arrives and returns its topic
+func (s *Subscription) Next() (string, error) {
+	for {
+		msg := &subscribeMsg{}
+		if len(s.queued) > 0 {
+			msg, s.queued = s.queued[0], s.queued[1:]
+		} else if err := s.dec.Decode(msg); err != nil {
+			return "", err
+		}
+		if msg.Method != "eth_subscription" {
+			continue
+		}
+		if topic, ok := s.topics[msg.Params.Subscription]; ok {
+			return topic, nil
+		}
+	}
+}
+
+// SetReadDeadline makes Next fail if no notification arrives in time
+func (s *Subscription) SetReadDeadline(t time.Time) error {
+	return s.conn.SetReadDeadline(t)
+}
+
+func (s *Subscription) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,97 @@
+package rpc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Fake node accepts newHeads only and sends one notification
+func serveFakeNode(t *testing.T, l net.Listener) {
+	conn, err := l.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	dec, enc := json.NewDecoder(conn), json.NewEncoder(conn)
+	for i := 0; i < 2; i++ {
+		var req subscribeReq
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		if req.Params[0] == "newHeads" {
+			enc.Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.Id, "result": "0xcd0c3e8af590364c09d0fa6a1210faf5"})
+		} else {
+			enc.Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.Id, "error": map[string]interface{}{"code": -32601, "message": "no such subscription"}})
+		}
+	}
+	enc.Encode(map[string]interface{}{"jsonrpc": "2.0", "method": "eth_subscription",
+		"params": map[string]interface{}{"subscription": "0xcd0c3e8af590364c09d0fa6a1210faf5", "result": map[string]string{"number": "0x1"}}})
+	time.Sleep(100 * time.Millisecond)
+}
+
+func TestSubscribeIPC(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "node.ipc")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skip("unix sockets are not supported")
+	}
+	defer l.Close()
+	go serveFakeNode(t, l)
+
+	sub, err := Subscribe(path, time.Second, nil, "newHeads", "newWork")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	if topics := sub.Topics(); len(topics) != 1 || topics[0] != "newHeads" {
+		t.Errorf("Must subscribe supported topics only, got %v", topics)
+	}
+	topic, err := sub.Next()
+	if err != nil || topic != "newHeads" {
+		t.Errorf("Must receive notification, got %v %v", topic, err)
+	}
+}
+
+// Node may notify about accepted subscription before it replies to the next request
+func TestSubscribeInterleavedNotification(t *testing.T) {
+	conn, node := net.Pipe()
+	defer node.Close()
+	go func() {
+		dec, enc := json.NewDecoder(node), json.NewEncoder(node)
+		var req subscribeReq
+		dec.Decode(&req)
+		enc.Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.Id, "result": "0x1"})
+		dec.Decode(&req)
+		enc.Encode(map[string]interface{}{"jsonrpc": "2.0", "method": "eth_subscription",
+			"params": map[string]interface{}{"subscription": "0x1", "result": map[string]string{"number": "0x1"}}})
+		enc.Encode(map[string]interface{}{"jsonrpc": "2.0", "id": 99, "result": true})
+		enc.Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.Id, "result": "0x2"})
+		enc.Encode(map[string]interface{}{"jsonrpc": "2.0", "method": "eth_subscription",
+			"params": map[string]interface{}{"subscription": "0x2", "result": "0xabc"}})
+	}()
+
+	sub, err := newSubscription("pipe", conn, time.Second, []string{"newHeads", "newPendingTransactions"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	if len(sub.Topics()) != 2 {
+		t.Fatalf("Must subscribe both topics, got %v", sub.Topics())
+	}
+	for _, want := range []string{"newHeads", "newPendingTransactions"} {
+		topic, err := sub.Next()
+		if err != nil || topic != want {
+			t.Errorf("Must receive %s notification in order, got %v %v", want, topic, err)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/etclabscore/core-pool/library/websocket"
+)
+
+// Auth holds credentials and TLS options of a node endpoint
+type Auth struct {
+	// Static bearer token
+	Bearer string `json:"bearer"`
+	// File with hex encoded secret for HS256 JWT, token is issued for every request
+	JWTSecret string `json:"jwtSecret"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	// Static headers, e.g. API keys of node providers
+	Headers map[string]string `json:"headers"`
+	// Client certificate, key and CA bundle for https and wss
+	TLSCert            string `json:"tlsCert"`
+	TLSKey             string `json:"tlsKey"`
+	TLSCA              string `json:"tlsCA"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify"`
+}
+
+// Credentials resolved from Auth config
+type credentials struct {
+	auth      *Auth
+	jwtSecret []byte
+	tls       *tls.Config
+}
+
+func newCredentials(auth *Auth) (*credentials, error) {
+	c := &credentials{auth: auth}
+	if auth == nil {
+		return c, nil
+	}
+	if len(auth.JWTSecret) > 0 {
+		data, err := ioutil.ReadFile(auth.JWTSecret)
+		if err != nil {
+			return nil, err
+		}
+		secret := strings.TrimPrefix(strings.TrimSpace(string(data)), "0x")
+		if c.jwtSecret, err = hex.DecodeString(secret); err != nil {
+			return nil, fmt.Errorf("invalid JWT secret %s: %v", auth.JWTSecret, err)
+		}
+	}
+	if len(auth.TLSCert) > 0 || len(auth.TLSCA) > 0 || auth.InsecureSkipVerify {
+		c.tls = &tls.Config{InsecureSkipVerify: auth.InsecureSkipVerify}
+		if len(auth.TLSCert) > 0 {
+			cert, err := tls.LoadX509KeyPair(auth.TLSCert, auth.TLSKey)
+			if err != nil {
+				return nil, err
+			}
+			c.tls.Certificates = []tls.Certificate{cert}
+		}
+		if len(auth.TLSCA) > 0 {
+			data, err := ioutil.ReadFile(auth.TLSCA)
+			if err != nil {
+				return nil, err
+			}
+			c.tls.RootCAs = x509.NewCertPool()
+			if !c.tls.RootCAs.AppendCertsFromPEM(data) {
+				return nil, fmt.Errorf("no certificates found in %s", auth.TLSCA)
+			}
+		}
+	}
+	return c, nil
+}
+
+// header returns request headers, JWT is issued on every call since nodes check its age
+func (c *credentials) header() http.Header {
+	h := make(http.Header)
+	if c.auth == nil {
+		return h
+	}
+	for k, v := range c.auth.Headers {
+		h.Set(k, v)
+	}
+	if len(c.auth.Username) > 0 {
+		token := base64.StdEncoding.EncodeToString([]byte(c.auth.Username + ":" + c.auth.Password))
+		h.Set("Authorization", "Basic "+token)
+	}
+	if len(c.auth.Bearer) > 0 {
+		h.Set("Authorization", "Bearer "+c.auth.Bearer)
+	}
+	if len(c.jwtSecret) > 0 {
+		h.Set("Authorization", "Bearer "+issueJWT(c.jwtSecret, time.Now()))
+	}
+	return h
+}
+
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// issueJWT makes HS256 token with iat claim, as required by geth authenticated endpoints
+func issueJWT(secret []byte, now time.Time) string {
+	claims := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"iat":%d}`, now.Unix())))
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(jwtHeader + "." + claims))
+	return jwtHeader + "." + claims + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// transport sends JSON-RPC request or batch and returns raw reply
+type transport interface {
+	call(ctx context.Context, data []byte) ([]byte, error)
+}
+
+func isIPC(url string) bool {
+	return strings.HasPrefix(url, "unix://") || strings.HasPrefix(url, "ipc://") || strings.HasPrefix(url, "/")
+}
+
+func ipcPath(url string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(url, "ipc://"), "unix://")
+}
+
+// newTransport makes HTTP transport or IPC one for unix://, ipc:// urls and absolute socket paths
+func newTransport(url string, c *credentials) transport {
+	if isIPC(url) {
+		return &ipcTransport{path: ipcPath(url)}
+	}
+	// Timeouts come from call context
+	client := &http.Client{}
+	if c.tls != nil {
+		client.Transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: c.tls,
+		}
+	}
+	return &httpTransport{url: url, client: client, creds: c}
+}
+
+type httpTransport struct {
+	url    string
+	client *http.Client
+	creds  *credentials
+}
+
+func (t *httpTransport) call(ctx context.Context, data []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", t.url, bytes.NewBuffer(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = t.creds.header()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	reply, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 && !json.Valid(reply) {
+		return nil, errors.New(resp.Status)
+	}
+	return reply, nil
+}
+
+// ipcTransport keeps one connection to node IPC socket, requests are serialized
+type ipcTransport struct {
+	sync.Mutex
+	path string
+	conn net.Conn
+	dec  *json.Decoder
+}
+
+func (t *ipcTransport) call(ctx context.Context, data []byte) ([]byte, error) {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.conn == nil {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "unix", t.path)
+		if err != nil {
+			return nil, err
+		}
+		t.conn = conn
+		t.dec = json.NewDecoder(bufio.NewReader(conn))
+	}
+	deadline, _ := ctx.Deadline()
+	t.conn.SetDeadline(deadline)
+
+	// Interrupt blocked read or write on cancellation, watcher must exit before next call resets deadline
+	stop, exited := make(chan struct{}), make(chan struct{})
+	defer func() {
+		close(stop)
+		<-exited
+	}()
+	go func(conn net.Conn) {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}(t.conn)
+
+	var reply json.RawMessage
+	_, err := t.conn.Write(data)
+	if err == nil {
+		err = t.dec.Decode(&reply)
+	}
+	if err != nil {
+		// Reply may still arrive later and break ordering, start over with a new connection
+		t.conn.Close()
+		t.conn = nil
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return reply, nil
+}
+
+// dialNode connects to ws:// or wss:// url, anything else is IPC socket path
+func dialNode(url string, timeout time.Duration, c *credentials) (net.Conn, error) {
+	if strings.HasPrefix(url, "ws://") || strings.HasPrefix(url, "wss://") {
+		return websocket.DialConfig(url, timeout, c.header(), c.tls)
+	}
+	return net.DialTimeout("unix", ipcPath(url), timeout)
+}
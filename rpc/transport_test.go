@@ -0,0 +1,123 @@
+package rpc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIssueJWT(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	token := issueJWT(secret, time.Unix(1700000000, 0))
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("Token must have 3 parts: %v", token)
+	}
+	claims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	if string(claims) != `{"iat":1700000000}` {
+		t.Errorf("Unexpected claims %s", claims)
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if parts[2] != base64.RawURLEncoding.EncodeToString(mac.Sum(nil)) {
+		t.Error("Token signature mismatch")
+	}
+}
+
+func TestHTTPAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	secret := filepath.Join(dir, "jwt.hex")
+	ioutil.WriteFile(secret, []byte("0x3031323334353637383961626364656630313233343536373839616263646566\n"), 0600)
+
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		if r.Header.Get("X-Api-Key") != "key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req jsonRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.Id, "result": "0x5"})
+	}))
+	defer srv.Close()
+
+	r, err := NewClient("node", srv.URL, "1s", &Auth{JWTSecret: secret, Headers: map[string]string{"X-Api-Key": "key"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peers, err := r.GetPeerCount(context.Background())
+	if err != nil || peers != 5 {
+		t.Fatalf("Must get peer count, got %v, %v", peers, err)
+	}
+	if !strings.HasPrefix(header.Get("Authorization"), "Bearer ey") {
+		t.Errorf("Must send JWT, got %q", header.Get("Authorization"))
+	}
+
+	r, _ = NewClient("node", srv.URL, "1s", &Auth{Username: "user", Password: "pass"}, nil)
+	if _, err = r.GetPeerCount(context.Background()); err == nil || err.Error() != "401 Unauthorized" {
+		t.Errorf("Must fail with HTTP status, got %v", err)
+	}
+	if user, pass, ok := (&http.Request{Header: header}).BasicAuth(); !ok || user != "user" || pass != "pass" {
+		t.Error("Must send basic auth")
+	}
+
+	if _, err = NewClient("node", srv.URL, "1s", &Auth{JWTSecret: filepath.Join(dir, "missing")}, nil); err == nil {
+		t.Error("Must fail on missing JWT secret")
+	}
+}
+
+func TestIPCTransport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "node.ipc")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skip("unix sockets are not supported")
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		dec, enc := json.NewDecoder(conn), json.NewEncoder(conn)
+		for {
+			var req map[string]interface{}
+			if err := dec.Decode(&req); err != nil {
+				return
+			}
+			enc.Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req["id"], "result": false})
+		}
+	}()
+
+	r, err := NewClient("node", "unix://"+path, "1s", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		syncing, err := r.Syncing(context.Background())
+		if err != nil || syncing {
+			t.Fatalf("Must reuse IPC connection, got %v, %v", syncing, err)
+		}
+	}
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/yvasiyarov/gorelic"
 
+	"github.com/etclabscore/core-pool/chain"
 	"github.com/etclabscore/core-pool/common"
 	"github.com/etclabscore/core-pool/library/clean"
 	"github.com/etclabscore/core-pool/library/routine"
@@ -33,6 +34,20 @@ func Init() {
 	logger.DEBUG = runLevelMap[cfg.RunLevel]
 	logger.Info("Loading config complete")
 
+	// 加载链规范
+	if len(cfg.ChainSpecs) > 0 {
+		specs, err := chain.LoadFile(cfg.ChainSpecs)
+		if err != nil {
+			logger.Fatal("Loading chain specs fail, err: %v", err)
+		}
+		logger.Info("Loaded %v chain specs from %s", len(specs), cfg.ChainSpecs)
+	}
+	chainSpec, err = chain.Lookup(cfg.Network)
+	if err != nil {
+		logger.Fatal("Invalid network config, err: %v", err)
+	}
+	logger.Info("Using %s chain spec", chainSpec.Name())
+
 	if cfg.Threads > 0 {
 		runtime.GOMAXPROCS(cfg.Threads)
 		logger.Info("Running with %v threads", cfg.Threads)
@@ -51,28 +66,28 @@ func Init() {
 
 func startProxy() {
 	if cfg.Proxy.Enabled {
-		s := proxy.NewProxy(&cfg, backend)
+		s := proxy.NewProxy(&cfg, backend, chainSpec)
 		s.Start()
 	}
 }
 
 func startApi() {
 	if cfg.Api.Enabled {
-		s := api.NewApiServer(&cfg.Api, backend)
+		s := api.NewApiServer(&cfg.Api, backend, chainSpec)
 		s.Start()
 	}
 }
 
 func startBlockUnlocker() {
 	if cfg.BlockUnlocker.Enabled {
-		u := payouts.NewBlockUnlocker(&cfg.BlockUnlocker, backend, cfg.Network)
+		u := payouts.NewBlockUnlocker(&cfg.BlockUnlocker, backend, chainSpec)
 		u.Start()
 	}
 }
 
 func startPayoutsProcessor() {
 	if cfg.Payouts.Enabled {
-		u := payouts.NewPayoutsProcessor(&cfg.Payouts, backend)
+		u := payouts.NewPayoutsProcessor(&cfg.Payouts, backend, chainSpec)
 		u.Start()
 	}
 }
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"fmt"
+	"math"
 	"math/big"
 	"strconv"
 	"strings"
@@ -22,8 +23,50 @@ type Config struct {
 type RedisClient struct {
 	client *redis.Client
 	prefix string
+	// Append every share to PPLNS share log
+	shareLog bool
+	// Weight shares by submit time, zero disables scores
+	scoreDecay time.Duration
 }
 
+// Share in PPLNS share log
+type ShareLogEntry struct {
+	Login string
+	Diff  int64
+}
+
+const (
+	shareLogChunk = 1000
+	// Reserve balance samples kept for variance
+	ppsHistorySize = 1000
+	// Scores are rebased once newest share weighs diff * e^100, far from float64 overflow
+	scoreRebaseExponent = 100
+)
+
+// Adds score of a share to current round, see writeScore.
+// KEYS: scores, round start; ARGV: login, diff, submit ms, decay ms, rebase exponent
+var scoreScript = redis.NewScript(`
+local ms = tonumber(ARGV[3])
+local start = tonumber(redis.call('GET', KEYS[2]))
+if not start then
+	start = ms
+	redis.call('SET', KEYS[2], ARGV[3])
+end
+local exp = (ms - start) / tonumber(ARGV[4])
+if exp > tonumber(ARGV[5]) then
+	local factor = math.exp(-exp)
+	local scores = redis.call('HGETALL', KEYS[1])
+	for i = 1, #scores, 2 do
+		redis.call('HSET', KEYS[1], scores[i], string.format('%.17g', tonumber(scores[i + 1]) * factor))
+	end
+	redis.call('SET', KEYS[2], ARGV[3])
+	exp = 0
+elseif exp < 0 then
+	exp = 0
+end
+return redis.call('HINCRBYFLOAT', KEYS[1], ARGV[1], string.format('%.17g', tonumber(ARGV[2]) * math.exp(exp)))
+`)
+
 type BlockData struct {
 	Height         int64    `json:"height"`
 	Timestamp      int64    `json:"timestamp"`
@@ -38,11 +81,15 @@ type BlockData struct {
 	MixDigest      string   `json:"-"`
 	Reward         *big.Int `json:"-"`
 	ExtraReward    *big.Int `json:"-"`
+	TxFees         *big.Int `json:"-"`
 	ImmatureReward string   `json:"-"`
 	RewardString   string   `json:"reward"`
 	RoundHeight    int64    `json:"-"`
-	candidateKey   string
-	immatureKey    string
+	// Found by solo miner, who gets the whole block
+	Solo         bool   `json:"solo"`
+	SoloLogin    string `json:"soloLogin,omitempty"`
+	candidateKey string
+	immatureKey  string
 }
 
 func (b *BlockData) RewardInShannon() int64 {
@@ -63,6 +110,9 @@ func (b *BlockData) RoundKey() string {
 }
 
 func (b *BlockData) key() string {
+	if b.Solo {
+		return join(b.UncleHeight, b.Orphan, b.Nonce, b.serializeHash(), b.Timestamp, b.Difficulty, b.TotalShares, b.Reward, b.SoloLogin)
+	}
 	return join(b.UncleHeight, b.Orphan, b.Nonce, b.serializeHash(), b.Timestamp, b.Difficulty, b.TotalShares, b.Reward)
 }
 
@@ -76,6 +126,9 @@ type Miner struct {
 type Worker struct {
 	Miner
 	TotalHR int64 `json:"hr2"`
+	// Hashrate reported by mining software with eth_submitHashrate
+	ReportedHR int64  `json:"rhr"`
+	RigId      string `json:"rigId,omitempty"`
 }
 
 func NewRedisClient(cfg *Config, prefix string) *RedisClient {
@@ -134,6 +187,106 @@ func (r *RedisClient) WriteNodeState(id string, height uint64, diff *big.Int) er
 	return err
 }
 
+type UpstreamState struct {
+	Name     string
+	Active   bool
+	Reason   string
+	Height   uint64
+	Lag      uint64
+	Peers    int64
+	Syncing  bool
+	Sick     bool
+	Priority int
+	Weight   int
+}
+
+// Write upstreams of proxy instance with active one and the reason of selection,
+// fields of upstreams removed from config are deleted
+func (r *RedisClient) WriteUpstreamStates(id string, states []*UpstreamState) error {
+	fields, err := r.client.HKeys(r.formatKey("upstreams")).Result()
+	if err != nil {
+		return err
+	}
+	names := make(map[string]bool, len(states))
+	for _, v := range states {
+		names[v.Name] = true
+	}
+	var stale []string
+	for _, f := range fields {
+		if name, ok := upstreamFieldName(id, f); ok && !names[name] {
+			stale = append(stale, f)
+		}
+	}
+
+	tx := r.client.Multi()
+	defer tx.Close()
+
+	now := util.MakeTimestamp() / 1000
+
+	_, err = tx.Exec(func() error {
+		if len(stale) > 0 {
+			tx.HDel(r.formatKey("upstreams"), stale...)
+		}
+		for _, v := range states {
+			if v.Active {
+				tx.HSet(r.formatKey("nodes"), join(id, "upstream"), v.Name)
+				tx.HSet(r.formatKey("nodes"), join(id, "upstreamReason"), v.Reason)
+			}
+			tx.HSet(r.formatKey("upstreams"), join(id, v.Name, "node"), id)
+			tx.HSet(r.formatKey("upstreams"), join(id, v.Name, "name"), v.Name)
+			tx.HSet(r.formatKey("upstreams"), join(id, v.Name, "active"), join(v.Active))
+			tx.HSet(r.formatKey("upstreams"), join(id, v.Name, "reason"), v.Reason)
+			tx.HSet(r.formatKey("upstreams"), join(id, v.Name, "height"), strconv.FormatUint(v.Height, 10))
+			tx.HSet(r.formatKey("upstreams"), join(id, v.Name, "lag"), strconv.FormatUint(v.Lag, 10))
+			tx.HSet(r.formatKey("upstreams"), join(id, v.Name, "peers"), strconv.FormatInt(v.Peers, 10))
+			tx.HSet(r.formatKey("upstreams"), join(id, v.Name, "syncing"), join(v.Syncing))
+			tx.HSet(r.formatKey("upstreams"), join(id, v.Name, "sick"), join(v.Sick))
+			tx.HSet(r.formatKey("upstreams"), join(id, v.Name, "priority"), strconv.Itoa(v.Priority))
+			tx.HSet(r.formatKey("upstreams"), join(id, v.Name, "weight"), strconv.Itoa(v.Weight))
+			tx.HSet(r.formatKey("upstreams"), join(id, v.Name, "lastBeat"), strconv.FormatInt(now, 10))
+		}
+		return nil
+	})
+	return err
+}
+
+// Upstream name of "<id>:<name>:<field>" hash field written by proxy instance id
+func upstreamFieldName(id, field string) (string, bool) {
+	if !strings.HasPrefix(field, id+":") {
+		return "", false
+	}
+	field = field[len(id)+1:]
+	i := strings.LastIndex(field, ":")
+	if i < 0 {
+		return "", false
+	}
+	return field[:i], true
+}
+
+func (r *RedisClient) GetUpstreamStates() ([]map[string]interface{}, error) {
+	cmd := r.client.HGetAllMap(r.formatKey("upstreams"))
+	if cmd.Err() != nil {
+		return nil, cmd.Err()
+	}
+	m := make(map[string]map[string]interface{})
+	for key, value := range cmd.Val() {
+		i := strings.LastIndex(key, ":")
+		if i < 0 {
+			continue
+		}
+		if val, ok := m[key[:i]]; ok {
+			val[key[i+1:]] = value
+		} else {
+			m[key[:i]] = map[string]interface{}{key[i+1:]: value}
+		}
+	}
+	v := make([]map[string]interface{}, 0, len(m))
+	for _, value := range m {
+		v = append(v, value)
+	}
+	return v, nil
+}
+
 func (r *RedisClient) GetNodeStates() ([]map[string]interface{}, error) {
 	cmd := r.client.HGetAllMap(r.formatKey("nodes"))
 	if cmd.Err() != nil {
@@ -166,7 +319,8 @@ func (r *RedisClient) checkPoWExist(height uint64, params []string) (bool, error
 	return val == 0, err
 }
 
-func (r *RedisClient) WriteShare(login, id string, params []string, diff int64, height uint64, window time.Duration) (bool, error) {
+// Credit is paid to miner balance right away under PPS, in Shannon
+func (r *RedisClient) WriteShare(login, id string, params []string, diff int64, height uint64, window time.Duration, credit int64) (bool, error) {
 	exist, err := r.checkPoWExist(height, params)
 	if err != nil {
 		return false, err
@@ -183,13 +337,41 @@ func (r *RedisClient) WriteShare(login, id string, params []string, diff int64,
 
 	_, err = tx.Exec(func() error {
 		r.writeShare(tx, ms, ts, login, id, diff, window)
+		r.writePPSCredit(tx, login, credit)
 		tx.HIncrBy(r.formatKey("stats"), "roundShares", diff)
 		return nil
 	})
 	return false, err
 }
 
-func (r *RedisClient) WriteBlock(login, id string, params []string, diff, roundDiff int64, height uint64, window time.Duration) (bool, error) {
+// Shares are also appended to PPLNS share log, see GetShareWindow
+func (r *RedisClient) EnableShareLog() {
+	r.shareLog = true
+}
+
+// Shares are also scored by submit time, see GetRoundScores
+func (r *RedisClient) EnableShareScores(decay time.Duration) {
+	r.scoreDecay = decay
+}
+
+// Score of a share is diff * e^(age / decay), age is time since start of the round.
+// Before exponent grows past scoreRebaseExponent round start is moved to the share
+// and scores so far are divided by the same e^(age / decay), so weights of shares
+// relative to each other stay the same. Score is computed in redis, start read and
+// rebase are atomic with the write whichever proxy submits the share.
+func (r *RedisClient) writeScore(tx *redis.Multi, ms int64, login string, diff int64) {
+	keys := []string{r.formatKey("shares", "scoreCurrent"), r.formatKey("shares", "scoreStart")}
+	args := []string{
+		login,
+		strconv.FormatInt(diff, 10),
+		strconv.FormatInt(ms, 10),
+		strconv.FormatInt(int64(r.scoreDecay/time.Millisecond), 10),
+		strconv.Itoa(scoreRebaseExponent),
+	}
+	scoreScript.Eval(tx, keys, args)
+}
+
+func (r *RedisClient) WriteBlock(login, id string, params []string, diff, roundDiff int64, height uint64, window time.Duration, credit int64) (bool, error) {
 	exist, err := r.checkPoWExist(height, params)
 	if err != nil {
 		return false, err
@@ -204,25 +386,40 @@ func (r *RedisClient) WriteBlock(login, id string, params []string, diff, roundD
 	ms := util.MakeTimestamp()
 	ts := ms / 1000
 
-	cmds, err := tx.Exec(func() error {
-		r.writeShare(tx, ms, ts, login, id, diff, window)
+	var roundShares *redis.StringStringMapCmd
+	var seq *redis.IntCmd
+	_, err = tx.Exec(func() error {
+		seq = r.writeShare(tx, ms, ts, login, id, diff, window)
+		r.writePPSCredit(tx, login, credit)
 		tx.HSet(r.formatKey("stats"), "lastBlockFound", strconv.FormatInt(ts, 10))
 		tx.HDel(r.formatKey("stats"), "roundShares")
 		tx.ZIncrBy(r.formatKey("finders"), 1, login)
 		tx.HIncrBy(r.formatKey("miners", login), "blocksFound", 1)
 		tx.Rename(r.formatKey("shares", "roundCurrent"), r.formatRound(int64(height), params[0]))
-		tx.HGetAllMap(r.formatRound(int64(height), params[0]))
+		if r.scoreDecay > 0 {
+			// Scores start over with the next round
+			tx.Rename(r.formatKey("shares", "scoreCurrent"), r.formatKey("shares", "scores", params[0]))
+			tx.Set(r.formatKey("shares", "scoreStart"), strconv.FormatInt(ms, 10), 0)
+		}
+		roundShares = tx.HGetAllMap(r.formatRound(int64(height), params[0]))
 		return nil
 	})
 	if err != nil {
 		return false, err
 	} else {
-		sharesMap, _ := cmds[10].(*redis.StringStringMapCmd).Result()
+		sharesMap, _ := roundShares.Result()
 		totalShares := int64(0)
 		for _, v := range sharesMap {
 			n, _ := strconv.ParseInt(v, 10, 64)
 			totalShares += n
 		}
+		// Remember position of block share in share log, PPLNS window ends there
+		if seq != nil {
+			err = r.client.HSet(r.formatKey("shares", "pplns", "blocks"), params[0], strconv.FormatInt(seq.Val(), 10)).Err()
+			if err != nil {
+				return false, err
+			}
+		}
 		hashHex := strings.Join(params, ":")
 		s := join(hashHex, ts, roundDiff, totalShares)
 		cmd := r.client.ZAdd(r.formatKey("blocks", "candidates"), redis.Z{Score: float64(height), Member: s})
@@ -230,14 +427,130 @@ func (r *RedisClient) WriteBlock(login, id string, params []string, diff, roundD
 	}
 }
 
-func (r *RedisClient) writeShare(tx *redis.Multi, ms, ts int64, login, id string, diff int64, expire time.Duration) {
+// Solo shares only go to miner's own solo round, shared round and share log are untouched
+func (r *RedisClient) WriteSoloShare(login, id string, params []string, diff int64, height uint64, window time.Duration) (bool, error) {
+	exist, err := r.checkPoWExist(height, params)
+	if err != nil {
+		return false, err
+	}
+	// Duplicate share, (nonce, powHash, mixDigest) pair exist
+	if exist {
+		return true, nil
+	}
+	tx := r.client.Multi()
+	defer tx.Close()
+
+	ms := util.MakeTimestamp()
+	ts := ms / 1000
+
+	_, err = tx.Exec(func() error {
+		r.writeSoloHashrate(tx, ms, ts, login, id, diff, window)
+		tx.HIncrBy(r.formatKey("shares", "soloCurrent"), login, diff)
+		return nil
+	})
+	return false, err
+}
+
+// Solo round of the finder becomes round of the block
+func (r *RedisClient) WriteSoloBlock(login, id string, params []string, diff, roundDiff int64, height uint64, window time.Duration) (bool, error) {
+	exist, err := r.checkPoWExist(height, params)
+	if err != nil {
+		return false, err
+	}
+	// Duplicate share, (nonce, powHash, mixDigest) pair exist
+	if exist {
+		return true, nil
+	}
+	tx := r.client.Multi()
+	defer tx.Close()
+
+	ms := util.MakeTimestamp()
+	ts := ms / 1000
+
+	var roundShares *redis.IntCmd
+	_, err = tx.Exec(func() error {
+		r.writeSoloHashrate(tx, ms, ts, login, id, diff, window)
+		roundShares = tx.HIncrBy(r.formatKey("shares", "soloCurrent"), login, diff)
+		tx.HDel(r.formatKey("shares", "soloCurrent"), login)
+		tx.ZIncrBy(r.formatKey("finders"), 1, login)
+		tx.HIncrBy(r.formatKey("miners", login), "blocksFound", 1)
+		tx.HSet(r.formatKey("miners", login), "lastSoloBlockFound", strconv.FormatInt(ts, 10))
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	totalShares := roundShares.Val()
+	candidate := r.client.Multi()
+	defer candidate.Close()
+
+	_, err = candidate.Exec(func() error {
+		candidate.HSet(r.formatRound(int64(height), params[0]), login, strconv.FormatInt(totalShares, 10))
+		hashHex := strings.Join(params, ":")
+		s := join(hashHex, ts, roundDiff, totalShares, login)
+		candidate.ZAdd(r.formatKey("blocks", "candidates"), redis.Z{Score: float64(height), Member: s})
+		return nil
+	})
+	return false, err
+}
+
+func (r *RedisClient) writeShare(tx *redis.Multi, ms, ts int64, login, id string, diff int64, expire time.Duration) *redis.IntCmd {
 	tx.HIncrBy(r.formatKey("shares", "roundCurrent"), login, diff)
+	if r.scoreDecay > 0 {
+		r.writeScore(tx, ms, login, diff)
+	}
+	r.writeHashrate(tx, ms, ts, login, id, diff, expire)
+	if r.shareLog {
+		// Shares are numbered by "seq" from 1, "trimmed" of them were removed from list head
+		tx.RPush(r.formatKey("shares", "pplns"), join(login, diff))
+		return tx.Incr(r.formatKey("shares", "pplns", "seq"))
+	}
+	return nil
+}
+
+func (r *RedisClient) writeHashrate(tx *redis.Multi, ms, ts int64, login, id string, diff int64, expire time.Duration) {
 	tx.ZAdd(r.formatKey("hashrate"), redis.Z{Score: float64(ts), Member: join(diff, login, id, ms)})
+	r.writeMinerHashrate(tx, ms, ts, login, id, diff, expire)
+}
+
+// Solo hashrate is kept apart from pool hashrate shared rounds are measured by
+func (r *RedisClient) writeSoloHashrate(tx *redis.Multi, ms, ts int64, login, id string, diff int64, expire time.Duration) {
+	tx.ZAdd(r.formatKey("soloHashrate"), redis.Z{Score: float64(ts), Member: join(diff, login, id, ms)})
+	r.writeMinerHashrate(tx, ms, ts, login, id, diff, expire)
+}
+
+func (r *RedisClient) writeMinerHashrate(tx *redis.Multi, ms, ts int64, login, id string, diff int64, expire time.Duration) {
 	tx.ZAdd(r.formatKey("hashrate", login), redis.Z{Score: float64(ts), Member: join(diff, id, ms)})
 	tx.Expire(r.formatKey("hashrate", login), expire) // Will delete hashrates for miners that gone
 	tx.HSet(r.formatKey("miners", login), "lastShare", strconv.FormatInt(ts, 10))
 }
 
+// PPS credits are paid from pool risk reserve, matured blocks refill it
+func (r *RedisClient) writePPSCredit(tx *redis.Multi, login string, credit int64) {
+	if credit <= 0 {
+		return
+	}
+	tx.HIncrBy(r.formatKey("miners", login), "balance", credit)
+	tx.HIncrBy(r.formatKey("finances"), "balance", credit)
+	tx.HIncrBy(r.formatKey("pps"), "reserve", -credit)
+	tx.HIncrBy(r.formatKey("pps"), "paid", credit)
+}
+
+// Reported hashrate is kept per login in hash worker => "hashrate:timestamp:rigId"
+func (r *RedisClient) WriteReportedHashrate(login, id, rigId string, hashrate int64, expire time.Duration) error {
+	tx := r.client.Multi()
+	defer tx.Close()
+
+	ts := util.MakeTimestamp() / 1000
+
+	_, err := tx.Exec(func() error {
+		tx.HSet(r.formatKey("reported", login), id, join(hashrate, ts, rigId))
+		tx.Expire(r.formatKey("reported", login), expire)
+		return nil
+	})
+	return err
+}
+
 func (r *RedisClient) formatKey(args ...interface{}) string {
 	return join(r.prefix, join(args...))
 }
@@ -310,6 +623,177 @@ func (r *RedisClient) GetRoundShares(height int64, nonce string) (map[string]int
 	return result, nil
 }
 
+// Scores of the round ending with block, empty if scores were not written
+func (r *RedisClient) GetRoundScores(nonce string) (map[string]float64, error) {
+	result := make(map[string]float64)
+	cmd := r.client.HGetAllMap(r.formatKey("shares", "scores", nonce))
+	if cmd.Err() != nil {
+		return nil, cmd.Err()
+	}
+	for login, v := range cmd.Val() {
+		n, _ := strconv.ParseFloat(v, 64)
+		result[login] = n
+	}
+	return result, nil
+}
+
+// Walks share log back from block share until window is filled or log is exhausted.
+// Returns shares newest first and number of the oldest one.
+func (r *RedisClient) GetShareWindow(nonce string, window int64) ([]ShareLogEntry, int64, error) {
+	seq, err := r.client.HGet(r.formatKey("shares", "pplns", "blocks"), nonce).Int64()
+	if err == redis.Nil {
+		return nil, 0, fmt.Errorf("no share log position for block %v", nonce)
+	} else if err != nil {
+		return nil, 0, err
+	}
+	trimmed, err := r.client.Get(r.formatKey("shares", "pplns", "trimmed")).Int64()
+	if err != nil && err != redis.Nil {
+		return nil, 0, err
+	}
+	end := seq - trimmed - 1
+	if end < 0 {
+		return nil, 0, fmt.Errorf("share log was trimmed past block %v", nonce)
+	}
+
+	var entries []ShareLogEntry
+	sum := int64(0)
+	for end >= 0 && sum < window {
+		start := end - shareLogChunk + 1
+		if start < 0 {
+			start = 0
+		}
+		values, err := r.client.LRange(r.formatKey("shares", "pplns"), start, end).Result()
+		if err != nil {
+			return nil, 0, err
+		}
+		for i := len(values) - 1; i >= 0 && sum < window; i-- {
+			fields := strings.Split(values[i], ":")
+			diff, _ := strconv.ParseInt(fields[1], 10, 64)
+			entries = append(entries, ShareLogEntry{Login: fields[0], Diff: diff})
+			sum += diff
+		}
+		end = start - 1
+	}
+	return entries, seq - int64(len(entries)) + 1, nil
+}
+
+// Removes shares numbered below first from share log and forgets block position
+func (r *RedisClient) TrimShareLog(nonce string, first int64) error {
+	trimmed, err := r.client.Get(r.formatKey("shares", "pplns", "trimmed")).Int64()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	n := first - 1 - trimmed
+	tx := r.client.Multi()
+	defer tx.Close()
+
+	_, err = tx.Exec(func() error {
+		if n > 0 {
+			tx.LTrim(r.formatKey("shares", "pplns"), n, -1)
+			tx.IncrBy(r.formatKey("shares", "pplns", "trimmed"), n)
+		}
+		tx.HDel(r.formatKey("shares", "pplns", "blocks"), nonce)
+		return nil
+	})
+	return err
+}
+
+// Credits matured block revenue to PPS risk reserve and keeps its tx fees for FPPS
+func (r *RedisClient) WritePPSReserve(block *BlockData, revenue int64, feeWindow int64) error {
+	tx := r.client.Multi()
+	defer tx.Close()
+
+	var reserve *redis.IntCmd
+	_, err := tx.Exec(func() error {
+		reserve = tx.HIncrBy(r.formatKey("pps"), "reserve", revenue)
+		tx.HIncrBy(r.formatKey("pps"), "mined", revenue)
+		if block.TxFees != nil && !block.Uncle {
+			tx.LPush(r.formatKey("pps", "fees"), block.TxFees.String())
+			tx.LTrim(r.formatKey("pps", "fees"), 0, feeWindow-1)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	ts := util.MakeTimestamp() / 1000
+	history := r.client.Multi()
+	defer history.Close()
+
+	_, err = history.Exec(func() error {
+		history.LPush(r.formatKey("pps", "history"), join(ts, reserve.Val()))
+		history.LTrim(r.formatKey("pps", "history"), 0, ppsHistorySize-1)
+		return nil
+	})
+	return err
+}
+
+// Average tx fees of recent matured blocks in Wei
+func (r *RedisClient) GetAverageBlockFees() (*big.Int, error) {
+	values, err := r.client.LRange(r.formatKey("pps", "fees"), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	sum := new(big.Int)
+	for _, v := range values {
+		fee, ok := new(big.Int).SetString(v, 10)
+		if ok {
+			sum.Add(sum, fee)
+		}
+	}
+	if len(values) == 0 {
+		return sum, nil
+	}
+	return sum.Div(sum, big.NewInt(int64(len(values)))), nil
+}
+
+// Risk reserve balance with mean and variance of its samples taken at every matured block
+func (r *RedisClient) GetPPSStats() (map[string]interface{}, error) {
+	tx := r.client.Multi()
+	defer tx.Close()
+
+	cmds, err := tx.Exec(func() error {
+		tx.HGetAllMap(r.formatKey("pps"))
+		tx.LRange(r.formatKey("pps", "history"), 0, -1)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	ledger, _ := cmds[0].(*redis.StringStringMapCmd).Result()
+	if len(ledger) == 0 {
+		return nil, nil
+	}
+	stats := convertStringMap(ledger)
+
+	history, _ := cmds[1].(*redis.StringSliceCmd).Result()
+	samples := make([]float64, 0, len(history))
+	for _, v := range history {
+		// "timestamp:reserve"
+		fields := strings.Split(v, ":")
+		n, _ := strconv.ParseInt(fields[1], 10, 64)
+		samples = append(samples, float64(n))
+	}
+	mean, variance := 0.0, 0.0
+	for _, n := range samples {
+		mean += n
+	}
+	if len(samples) > 0 {
+		mean /= float64(len(samples))
+	}
+	for _, n := range samples {
+		variance += (n - mean) * (n - mean)
+	}
+	if len(samples) > 1 {
+		variance /= float64(len(samples) - 1)
+	}
+	stats["samples"] = len(samples)
+	stats["mean"] = int64(mean)
+	stats["variance"] = variance
+	stats["stdDev"] = int64(math.Sqrt(variance))
+	return stats, nil
+}
+
 func (r *RedisClient) GetPayees() ([]string, error) {
 	payees := make(map[string]struct{})
 	var result []string
@@ -464,7 +948,8 @@ func (r *RedisClient) WriteImmatureBlock(block *BlockData, roundRewards map[stri
 	return err
 }
 
-func (r *RedisClient) WriteMaturedBlock(block *BlockData, roundRewards map[string]int64) error {
+// Fee charged from each miner is kept in block credits as "fee:<login>", in Shannon like the rewards
+func (r *RedisClient) WriteMaturedBlock(block *BlockData, roundRewards map[string]int64, roundFees map[string]int64) error {
 	creditKey := r.formatKey("credits", "immature", block.RoundHeight, block.Hash)
 	tx, err := r.client.Watch(creditKey)
 	// Must decrement immatures using existing log entry
@@ -497,6 +982,9 @@ func (r *RedisClient) WriteMaturedBlock(block *BlockData, roundRewards map[strin
 			tx.HIncrBy(r.formatKey("miners", login), "balance", amount)
 			tx.HSetNX(r.formatKey("credits", block.Height, block.Hash), login, strconv.FormatInt(amount, 10))
 		}
+		for login, fee := range roundFees {
+			tx.HSetNX(r.formatKey("credits", block.Height, block.Hash), "fee:"+login, strconv.FormatInt(fee, 10))
+		}
 		tx.Del(creditKey)
 		tx.HIncrBy(r.formatKey("finances"), "balance", total)
 		tx.HIncrBy(r.formatKey("finances"), "immature", (totalImmature * -1))
@@ -530,6 +1018,7 @@ func (r *RedisClient) WriteOrphan(block *BlockData) error {
 		}
 		tx.Del(creditKey)
 		tx.HIncrBy(r.formatKey("finances"), "immature", (totalImmature * -1))
+		tx.HDel(r.formatKey("shares", "pplns", "blocks"), block.Nonce)
 		return nil
 	})
 	return err
@@ -559,8 +1048,39 @@ func (r *RedisClient) writeImmatureBlock(tx *redis.Multi, block *BlockData) {
 
 func (r *RedisClient) writeMaturedBlock(tx *redis.Multi, block *BlockData) {
 	tx.Del(r.formatRound(block.RoundHeight, block.Nonce))
+	tx.Del(r.formatKey("shares", "scores", block.Nonce))
 	tx.ZRem(r.formatKey("blocks", "immature"), block.immatureKey)
 	tx.ZAdd(r.formatKey("blocks", "matured"), redis.Z{Score: float64(block.Height), Member: block.key()})
+	// Only latest matured blocks are listed, solo ones are counted to split total
+	if block.Solo {
+		tx.Incr(r.formatKey("blocks", "matured", "solo"))
+	}
+}
+
+// Pool fee overrides in percent by login, poolFee applies to everyone else
+func (r *RedisClient) GetMinerFees() (map[string]float64, error) {
+	cmd := r.client.HGetAllMap(r.formatKey("minerFees"))
+	if cmd.Err() != nil {
+		return nil, cmd.Err()
+	}
+	result := make(map[string]float64)
+	for login, v := range cmd.Val() {
+		fee, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fee %q for %s", v, login)
+		}
+		result[login] = fee
+	}
+	return result, nil
+}
+
+func (r *RedisClient) SetMinerFee(login string, fee float64) error {
+	return r.client.HSet(r.formatKey("minerFees"), login, strconv.FormatFloat(fee, 'f', -1, 64)).Err()
+}
+
+func (r *RedisClient) DeleteMinerFee(login string) (bool, error) {
+	n, err := r.client.HDel(r.formatKey("minerFees"), login).Result()
+	return n > 0, err
 }
 
 func (r *RedisClient) IsMinerExists(login string) (bool, error) {
@@ -617,6 +1137,11 @@ func (r *RedisClient) FlushStaleStats(window, largeWindow time.Duration) (int64,
 	if err != nil {
 		return total, err
 	}
+	n, err := r.client.ZRemRangeByScore(r.formatKey("soloHashrate"), "-inf", max).Result()
+	if err != nil {
+		return total, err
+	}
+	total += n
 
 	var c int64
 	miners := make(map[string]struct{})
@@ -668,10 +1193,13 @@ func (r *RedisClient) CollectStats(smallWindow time.Duration, maxBlocks, maxPaym
 		tx.ZCard(r.formatKey("blocks", "matured"))
 		tx.ZCard(r.formatKey("payments", "all"))
 		tx.ZRevRangeWithScores(r.formatKey("payments", "all"), 0, maxPayments-1)
+		tx.ZRemRangeByScore(r.formatKey("soloHashrate"), "-inf", fmt.Sprint("(", now-window))
+		tx.ZRangeWithScores(r.formatKey("soloHashrate"), 0, -1)
+		tx.Get(r.formatKey("blocks", "matured", "solo"))
 		return nil
 	})
 
-	if err != nil {
+	if err != nil && err != redis.Nil {
 		return nil, err
 	}
 
@@ -697,6 +1225,12 @@ func (r *RedisClient) CollectStats(smallWindow time.Duration, maxBlocks, maxPaym
 	stats["miners"] = miners
 	stats["minersTotal"] = len(miners)
 	stats["hashrate"] = totalHashrate
+
+	// Solo blocks are in the same lists, caller splits them using solo matured total
+	soloHashrate, soloMiners := convertMinersStats(window, cmds[12].(*redis.ZSliceCmd))
+	stats["soloMiners"] = soloMiners
+	stats["soloHashrate"] = soloHashrate
+	stats["soloMaturedTotal"], _ = cmds[13].(*redis.StringCmd).Int64()
 	return stats, nil
 }
 
@@ -713,18 +1247,22 @@ func (r *RedisClient) CollectWorkersStats(sWindow, lWindow time.Duration, login
 	cmds, err := tx.Exec(func() error {
 		tx.ZRemRangeByScore(r.formatKey("hashrate", login), "-inf", fmt.Sprint("(", now-largeWindow))
 		tx.ZRangeWithScores(r.formatKey("hashrate", login), 0, -1)
+		tx.HGetAllMap(r.formatKey("reported", login))
 		return nil
 	})
 
-	if err != nil {
+	if err != nil && err != redis.Nil {
 		return nil, err
 	}
 
 	totalHashrate := int64(0)
 	currentHashrate := int64(0)
+	reportedHashrate := int64(0)
 	online := int64(0)
 	offline := int64(0)
 	workers := convertWorkersStats(smallWindow, cmds[1].(*redis.ZSliceCmd))
+	reported, _ := cmds[2].(*redis.StringStringMapCmd).Result()
+	addReportedStats(workers, reported, now-smallWindow)
 
 	for id, worker := range workers {
 		timeOnline := now - worker.startedAt
@@ -753,6 +1291,7 @@ func (r *RedisClient) CollectWorkersStats(sWindow, lWindow time.Duration, login
 
 		currentHashrate += worker.HR
 		totalHashrate += worker.TotalHR
+		reportedHashrate += worker.ReportedHR
 		workers[id] = worker
 	}
 	stats["workers"] = workers
@@ -761,10 +1300,39 @@ func (r *RedisClient) CollectWorkersStats(sWindow, lWindow time.Duration, login
 	stats["workersOffline"] = offline
 	stats["hashrate"] = totalHashrate
 	stats["currentHashrate"] = currentHashrate
+	stats["reportedHashrate"] = reportedHashrate
 	return stats, nil
 }
 
+// Merge reported hashrate into workers, reports older than since are stale
+// worker => "hashrate:timestamp:rigId"
+func addReportedStats(workers map[string]Worker, reported map[string]string, since int64) {
+	for id, v := range reported {
+		parts := strings.SplitN(v, ":", 3)
+		if len(parts) < 3 {
+			continue
+		}
+		ts, _ := strconv.ParseInt(parts[1], 10, 64)
+		if ts < since {
+			continue
+		}
+		worker := workers[id]
+		worker.ReportedHR, _ = strconv.ParseInt(parts[0], 10, 64)
+		worker.RigId = parts[2]
+		workers[id] = worker
+	}
+}
+
+// Luck of shared rounds, solo blocks are left out
 func (r *RedisClient) CollectLuckStats(windows []int) (map[string]interface{}, error) {
+	return r.collectLuckStats(windows, false)
+}
+
+func (r *RedisClient) CollectSoloLuckStats(windows []int) (map[string]interface{}, error) {
+	return r.collectLuckStats(windows, true)
+}
+
+func (r *RedisClient) collectLuckStats(windows []int, solo bool) (map[string]interface{}, error) {
 	stats := make(map[string]interface{})
 
 	tx := r.client.Multi()
@@ -780,7 +1348,12 @@ func (r *RedisClient) CollectLuckStats(windows []int) (map[string]interface{}, e
 	if err != nil {
 		return stats, err
 	}
-	blocks := convertBlockResults(cmds[0].(*redis.ZSliceCmd), cmds[1].(*redis.ZSliceCmd))
+	var blocks []*BlockData
+	for _, block := range convertBlockResults(cmds[0].(*redis.ZSliceCmd), cmds[1].(*redis.ZSliceCmd)) {
+		if block.Solo == solo {
+			blocks = append(blocks, block)
+		}
+	}
 
 	calcLuck := func(max int) (int, float64, float64, float64) {
 		var total int
@@ -821,7 +1394,7 @@ func (r *RedisClient) CollectLuckStats(windows []int) (map[string]interface{}, e
 func convertCandidateResults(raw *redis.ZSliceCmd) []*BlockData {
 	var result []*BlockData
 	for _, v := range raw.Val() {
-		// "nonce:powHash:mixDigest:timestamp:diff:totalShares"
+		// "nonce:powHash:mixDigest:timestamp:diff:totalShares[:soloLogin]"
 		block := BlockData{}
 		block.Height = int64(v.Score)
 		block.RoundHeight = block.Height
@@ -832,6 +1405,11 @@ func convertCandidateResults(raw *redis.ZSliceCmd) []*BlockData {
 		block.Timestamp, _ = strconv.ParseInt(fields[3], 10, 64)
 		block.Difficulty, _ = strconv.ParseInt(fields[4], 10, 64)
 		block.TotalShares, _ = strconv.ParseInt(fields[5], 10, 64)
+		// Solo candidates carry finder login
+		if len(fields) > 6 {
+			block.Solo = true
+			block.SoloLogin = fields[6]
+		}
 		block.candidateKey = v.Member.(string)
 		result = append(result, &block)
 	}
@@ -842,7 +1420,7 @@ func convertBlockResults(rows ...*redis.ZSliceCmd) []*BlockData {
 	var result []*BlockData
 	for _, row := range rows {
 		for _, v := range row.Val() {
-			// "uncleHeight:orphan:nonce:blockHash:timestamp:diff:totalShares:rewardInWei"
+			// "uncleHeight:orphan:nonce:blockHash:timestamp:diff:totalShares:rewardInWei[:soloLogin]"
 			block := BlockData{}
 			block.Height = int64(v.Score)
 			block.RoundHeight = block.Height
@@ -857,6 +1435,10 @@ func convertBlockResults(rows ...*redis.ZSliceCmd) []*BlockData {
 			block.TotalShares, _ = strconv.ParseInt(fields[6], 10, 64)
 			block.RewardString = fields[7]
 			block.ImmatureReward = fields[7]
+			if len(fields) > 8 {
+				block.Solo = true
+				block.SoloLogin = fields[8]
+			}
 			block.immatureKey = v.Member.(string)
 			result = append(result, &block)
 		}
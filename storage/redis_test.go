@@ -1,10 +1,14 @@
 package storage
 
 import (
+	"math"
+	"math/big"
 	"os"
 	"reflect"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"gopkg.in/redis.v3"
 )
@@ -24,23 +28,23 @@ func TestMain(m *testing.M) {
 func TestWriteShareCheckExist(t *testing.T) {
 	reset()
 
-	exist, _ := r.WriteShare("x", "x", []string{"0x0", "0x0", "0x0"}, 10, 1008, 0)
+	exist, _ := r.WriteShare("x", "x", []string{"0x0", "0x0", "0x0"}, 10, 1008, 0, 0)
 	if exist {
 		t.Error("PoW must not exist")
 	}
-	exist, _ = r.WriteShare("x", "x", []string{"0x0", "0x1", "0x0"}, 10, 1008, 0)
+	exist, _ = r.WriteShare("x", "x", []string{"0x0", "0x1", "0x0"}, 10, 1008, 0, 0)
 	if exist {
 		t.Error("PoW must not exist")
 	}
-	exist, _ = r.WriteShare("x", "x", []string{"0x0", "0x0", "0x1"}, 100, 1010, 0)
+	exist, _ = r.WriteShare("x", "x", []string{"0x0", "0x0", "0x1"}, 100, 1010, 0, 0)
 	if exist {
 		t.Error("PoW must not exist")
 	}
-	exist, _ = r.WriteShare("z", "x", []string{"0x0", "0x0", "0x1"}, 100, 1016, 0)
+	exist, _ = r.WriteShare("z", "x", []string{"0x0", "0x0", "0x1"}, 100, 1016, 0, 0)
 	if !exist {
 		t.Error("PoW must exist")
 	}
-	exist, _ = r.WriteShare("x", "x", []string{"0x0", "0x0", "0x1"}, 100, 1025, 0)
+	exist, _ = r.WriteShare("x", "x", []string{"0x0", "0x0", "0x1"}, 100, 1025, 0, 0)
 	if exist {
 		t.Error("PoW must not exist")
 	}
@@ -321,6 +325,246 @@ func TestCollectLuckStats(t *testing.T) {
 	}
 }
 
+func TestCollectWorkersStatsReported(t *testing.T) {
+	reset()
+
+	exist, _ := r.WriteShare("x", "rig1", []string{"0x0", "0x0", "0x0"}, 1000, 1008, time.Hour, 0)
+	if exist {
+		t.Error("PoW must not exist")
+	}
+	r.WriteReportedHashrate("x", "rig1", "0xab", 500, time.Hour)
+	r.WriteReportedHashrate("x", "rig2", "", 250, time.Hour)
+
+	stats, err := r.CollectWorkersStats(30*time.Minute, 3*time.Hour, "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	workers := stats["workers"].(map[string]Worker)
+	if workers["rig1"].ReportedHR != 500 || workers["rig1"].RigId != "0xab" {
+		t.Error("Must merge reported hashrate into worker")
+	}
+	if workers["rig2"].ReportedHR != 250 {
+		t.Error("Must list worker which only reports hashrate")
+	}
+	if stats["reportedHashrate"] != int64(750) {
+		t.Errorf("Invalid total reported hashrate: %v", stats["reportedHashrate"])
+	}
+}
+
+func TestShareWindow(t *testing.T) {
+	reset()
+	r.EnableShareLog()
+	defer func() { r.shareLog = false }()
+
+	r.WriteShare("x", "x", []string{"0x1", "0x0", "0x0"}, 10, 1000, time.Minute, 0)
+	r.WriteShare("y", "x", []string{"0x2", "0x0", "0x0"}, 20, 1000, time.Minute, 0)
+	r.WriteShare("x", "x", []string{"0x3", "0x0", "0x0"}, 30, 1000, time.Minute, 0)
+	r.WriteBlock("y", "x", []string{"0x4", "0x0", "0x0"}, 40, 100, 1000, time.Minute, 0)
+	r.WriteShare("z", "x", []string{"0x5", "0x0", "0x0"}, 50, 1001, time.Minute, 0)
+
+	entries, first, err := r.GetShareWindow("0x4", 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ShareLogEntry{{"y", 40}, {"x", 30}}
+	if !reflect.DeepEqual(entries, expected) || first != 3 {
+		t.Errorf("Invalid share window: %v from %v", entries, first)
+	}
+
+	if err := r.TrimShareLog("0x4", first); err != nil {
+		t.Fatal(err)
+	}
+	if n := r.client.LLen(r.formatKey("shares", "pplns")).Val(); n != 3 {
+		t.Errorf("Share log must keep 3 shares, got %v", n)
+	}
+	if _, _, err := r.GetShareWindow("0x4", 60); err == nil {
+		t.Error("Block position must be removed after trim")
+	}
+}
+
+func TestPPSReserve(t *testing.T) {
+	reset()
+
+	r.WriteShare("x", "x", []string{"0x1", "0x0", "0x0"}, 10, 1000, time.Minute, 300)
+	r.WriteShare("y", "x", []string{"0x2", "0x0", "0x0"}, 10, 1000, time.Minute, 700)
+	if balance, _ := r.GetBalance("y"); balance != 700 {
+		t.Errorf("Share must be credited to balance, got %v", balance)
+	}
+
+	r.WritePPSReserve(&BlockData{TxFees: big.NewInt(100)}, 2000, 2)
+	r.WritePPSReserve(&BlockData{TxFees: big.NewInt(300)}, 0, 2)
+	r.WritePPSReserve(&BlockData{TxFees: big.NewInt(500)}, 0, 2)
+
+	fees, _ := r.GetAverageBlockFees()
+	if fees.Int64() != 400 {
+		t.Errorf("Must average fees of last 2 blocks, got %v", fees)
+	}
+	stats, err := r.GetPPSStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats["reserve"] != int64(1000) || stats["paid"] != int64(1000) || stats["mined"] != int64(2000) {
+		t.Errorf("Invalid reserve ledger: %v", stats)
+	}
+	if stats["samples"] != 3 || stats["mean"] != int64(1000) || stats["variance"] != 0.0 {
+		t.Errorf("Invalid reserve variance: %v", stats)
+	}
+}
+
+func TestRoundScores(t *testing.T) {
+	reset()
+	r.EnableShareScores(time.Hour)
+	defer func() { r.scoreDecay = 0 }()
+
+	r.WriteShare("x", "x", []string{"0x1", "0x0", "0x0"}, 10, 1000, time.Minute, 0)
+	r.WriteBlock("y", "x", []string{"0x2", "0x0", "0x0"}, 20, 100, 1000, time.Minute, 0)
+	r.WriteShare("z", "x", []string{"0x3", "0x0", "0x0"}, 30, 1001, time.Minute, 0)
+
+	scores, err := r.GetRoundScores("0x2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scores) != 2 || scores["x"] < 10 || scores["y"] < 20 || scores["y"] > 21 {
+		t.Errorf("Round scores must be written with block: %v", scores)
+	}
+	current, _ := r.client.HGetAllMap(r.formatKey("shares", "scoreCurrent")).Result()
+	if len(current) != 1 || current["z"] == "" {
+		t.Errorf("Scores must start over after block: %v", current)
+	}
+}
+
+// Round far longer than rebase exponent, newer shares must keep weighing more
+func TestRoundScoresRebase(t *testing.T) {
+	reset()
+	r.EnableShareScores(time.Millisecond)
+	defer func() { r.scoreDecay = 0 }()
+
+	r.WriteShare("w", "x", []string{"0x1", "0x0", "0x0"}, 10, 1000, time.Minute, 0)
+	time.Sleep(3 * scoreRebaseExponent * time.Millisecond)
+	r.WriteShare("x", "x", []string{"0x2", "0x0", "0x0"}, 10, 1000, time.Minute, 0)
+	time.Sleep(10 * time.Millisecond)
+	r.WriteShare("y", "x", []string{"0x3", "0x0", "0x0"}, 10, 1000, time.Minute, 0)
+	r.WriteBlock("z", "x", []string{"0x4", "0x0", "0x0"}, 10, 100, 1000, time.Minute, 0)
+
+	scores, err := r.GetRoundScores("0x4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scores) != 4 || math.IsInf(scores["z"], 0) || scores["x"] < 10 || scores["x"] > 10*math.Exp(scoreRebaseExponent) {
+		t.Fatalf("Scores must be rebased instead of growing past the cap: %v", scores)
+	}
+	if scores["w"] >= scores["x"]*math.Exp(-2*scoreRebaseExponent) || scores["y"] < scores["x"]*math.Exp(5) || scores["z"] < scores["y"] {
+		t.Errorf("Scores must keep decaying after rebase: %v", scores)
+	}
+}
+
+func TestMinerFees(t *testing.T) {
+	reset()
+
+	r.SetMinerFee("x", 0.5)
+	r.SetMinerFee("y", 0)
+	fees, err := r.GetMinerFees()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fees) != 2 || fees["x"] != 0.5 || fees["y"] != 0 {
+		t.Errorf("Wrong miner fees: %v", fees)
+	}
+	if deleted, _ := r.DeleteMinerFee("x"); !deleted {
+		t.Error("Fee must be deleted")
+	}
+	if deleted, _ := r.DeleteMinerFee("x"); deleted {
+		t.Error("Fee must be deleted once")
+	}
+
+	block := &BlockData{Height: 1000, RoundHeight: 1000, Hash: "0x1", Nonce: "0x2", Reward: big.NewInt(0)}
+	r.WriteMaturedBlock(block, map[string]int64{"x": 100}, map[string]int64{"x": 2})
+	credits, _ := r.client.HGetAllMap(r.formatKey("credits", block.Height, block.Hash)).Result()
+	if credits["x"] != "100" || credits["fee:x"] != "2" {
+		t.Errorf("Charged fee must be kept in block credits: %v", credits)
+	}
+}
+
+func TestWriteSoloBlock(t *testing.T) {
+	reset()
+
+	r.WriteShare("x", "x", []string{"0x1", "0x0", "0x0"}, 10, 1000, time.Minute, 0)
+	r.WriteSoloShare("y", "x", []string{"0x2", "0x0", "0x0"}, 20, 1000, time.Minute)
+	r.WriteSoloBlock("y", "x", []string{"0x3", "0x0", "0x0"}, 30, 100, 1000, time.Minute)
+
+	shared, _ := r.client.HGetAllMap(r.formatKey("shares", "roundCurrent")).Result()
+	if len(shared) != 1 || shared["x"] != "10" {
+		t.Errorf("Solo shares must be kept out of shared round: %v", shared)
+	}
+	shares, _ := r.GetRoundShares(1000, "0x3")
+	if len(shares) != 1 || shares["y"] != 50 {
+		t.Errorf("Solo round must be round of the block: %v", shares)
+	}
+	candidates, _ := r.GetCandidates(1000)
+	if len(candidates) != 1 || !candidates[0].Solo || candidates[0].SoloLogin != "y" || candidates[0].TotalShares != 50 {
+		t.Errorf("Candidate must be marked solo: %+v", candidates)
+	}
+	block := candidates[0]
+	block.Reward = big.NewInt(1)
+	if !strings.HasSuffix(block.key(), ":y") {
+		t.Errorf("Solo login must be kept in block key: %v", block.key())
+	}
+
+	block.Hash = "0x4"
+	block.Height = block.RoundHeight
+	r.WriteImmatureBlock(block, map[string]int64{"y": 1})
+	r.WriteMaturedBlock(block, map[string]int64{"y": 1}, map[string]int64{})
+	stats, err := r.CollectStats(time.Minute, 10, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	miners := stats["miners"].(map[string]Miner)
+	soloMiners := stats["soloMiners"].(map[string]Miner)
+	if len(miners) != 1 || miners["y"].HR != 0 || len(soloMiners) != 1 || soloMiners["y"].HR == 0 {
+		t.Errorf("Solo hashrate must be kept apart from pool hashrate: %v, %v", miners, soloMiners)
+	}
+	if stats["maturedTotal"].(int64) != 1 || stats["soloMaturedTotal"].(int64) != 1 {
+		t.Errorf("Matured solo blocks must be counted: %v, %v", stats["maturedTotal"], stats["soloMaturedTotal"])
+	}
+}
+
+func TestWriteUpstreamStates(t *testing.T) {
+	reset()
+
+	r.WriteUpstreamStates("proxy-1", []*UpstreamState{{Name: "main", Active: true}, {Name: "backup"}})
+	r.WriteUpstreamStates("proxy-2", []*UpstreamState{{Name: "backup", Active: true}})
+	r.WriteUpstreamStates("proxy-1", []*UpstreamState{{Name: "main", Active: true}})
+
+	states, _ := r.GetUpstreamStates()
+	if len(states) != 2 {
+		t.Fatalf("Removed upstream must be deleted, got %v", states)
+	}
+	for _, v := range states {
+		if v["node"] == "proxy-1" && v["name"] != "main" {
+			t.Errorf("Stale upstream of proxy must be deleted: %v", v)
+		}
+	}
+}
+
+func TestUpstreamFieldName(t *testing.T) {
+	tests := []struct {
+		field string
+		name  string
+		ok    bool
+	}{
+		{"proxy-1:main:height", "main", true},
+		{"proxy-1:node:a:lag", "node:a", true},
+		{"proxy-10:main:height", "", false},
+		{"proxy-1:height", "", false},
+	}
+	for _, tt := range tests {
+		name, ok := upstreamFieldName("proxy-1", tt.field)
+		if name != tt.name || ok != tt.ok {
+			t.Errorf("upstreamFieldName(%q) = %q %v, want %q %v", tt.field, name, ok, tt.name, tt.ok)
+		}
+	}
+}
+
 func reset() {
 	keys := r.client.Keys(r.prefix + ":*").Val()
 	for _, k := range keys {